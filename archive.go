@@ -0,0 +1,217 @@
+package metabase
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/metabase/metadata"
+)
+
+// The metadata key recording which version of an archive its members were last indexed from.
+const ArchiveIndexKey = `_archive_indexed`
+
+// Returns whether this entry represents a member of an archive rather than a file on disk.
+func (self *Entry) IsVirtual() bool {
+	return self.Container != ``
+}
+
+// Expose the members of the given archive entry as virtual child entries.  Archives whose contents
+// haven't changed since they were last indexed are skipped (unless deep scanning).
+func (self *Group) indexArchive(archive *Entry, absPath string) error {
+	version := archiveVersion(archive)
+
+	if indexed, ok := archive.Metadata[ArchiveIndexKey].(string); ok && indexed == version && !self.DeepScan {
+		return nil
+	}
+
+	if err := self.indexArchiveMembers(archive, absPath); err != nil {
+		return err
+	}
+
+	if archive.Metadata == nil {
+		archive.Metadata = make(map[string]interface{})
+	}
+
+	archive.Metadata[ArchiveIndexKey] = version
+	return nil
+}
+
+// The archive's checksum identifies its contents; if it hasn't been calculated in this pass, the
+// size and modification time are used instead.
+func archiveVersion(archive *Entry) string {
+	if archive.Checksum != `` {
+		return archive.Checksum
+	}
+
+	return fmt.Sprintf("%d:%d", archive.Size, archive.LastModifiedAt)
+}
+
+func (self *Group) indexArchiveMembers(archive *Entry, absPath string) error {
+	listing, err := metadata.ListArchive(absPath)
+
+	if err != nil {
+		return err
+	}
+
+	if !listing.Listable {
+		archive.ChildCount = 0
+		return removeContainedEntries(archive.ID)
+	}
+
+	directories := make(map[string]*Entry)
+	members := make([]*Entry, 0)
+	childCounts := make(map[string]int)
+
+	var parentOf func(dir string) string
+
+	// return the ID of the virtual directory for the given path (creating it and its ancestors as needed)
+	parentOf = func(dir string) string {
+		if dir == `.` || dir == `` {
+			return archive.ID
+		}
+
+		if directory, ok := directories[dir]; ok {
+			return directory.ID
+		}
+
+		directory := self.newArchiveMember(archive, dir, parentOf(path.Dir(dir)))
+		directory.IsGroup = true
+		directory.Type = `directory`
+		directories[dir] = directory
+
+		return directory.ID
+	}
+
+	for _, member := range listing.Members {
+		name := path.Clean(strings.TrimPrefix(member.Name, `/`))
+
+		// don't allow members to escape the archive
+		if name == `.` || name == `..` || strings.HasPrefix(name, `../`) {
+			continue
+		}
+
+		if member.IsDir {
+			parentOf(name)
+
+			if !member.ModTime.IsZero() {
+				directories[name].LastModifiedAt = member.ModTime.UnixNano()
+			}
+
+			continue
+		}
+
+		entry := self.newArchiveMember(archive, name, parentOf(path.Dir(name)))
+		entry.Type = metadata.GetGeneralFileType(name)
+		entry.Size = member.Size
+
+		if !member.ModTime.IsZero() {
+			entry.LastModifiedAt = member.ModTime.UnixNano()
+		}
+
+		entry.Metadata = map[string]interface{}{
+			`archive`: map[string]interface{}{
+				`member`: map[string]interface{}{
+					`compressed_size`: member.CompressedSize,
+					`crc32`:           member.CRC32,
+					`encrypted`:       member.Encrypted,
+					`mode`:            member.Mode.String(),
+				},
+			},
+		}
+
+		members = append(members, entry)
+	}
+
+	for _, directory := range directories {
+		members = append(members, directory)
+	}
+
+	for _, entry := range members {
+		childCounts[entry.Parent] += 1
+	}
+
+	seen := make(map[string]bool)
+
+	for _, entry := range members {
+		if entry.IsGroup {
+			entry.ChildCount = childCounts[entry.ID]
+		}
+
+		if err := Metadata.CreateOrUpdate(entry.ID, entry); err == nil {
+			seen[entry.ID] = true
+		} else {
+			return err
+		}
+	}
+
+	archive.ChildCount = childCounts[archive.ID]
+
+	// remove members that are no longer present in the archive
+	if f, err := ParseFilter(map[string]interface{}{
+		`container`: archive.ID,
+	}); err == nil {
+		if values, err := Metadata.ListWithFilter([]string{`id`}, f); err == nil {
+			stale := make([]interface{}, 0)
+
+			for _, id := range values[`id`] {
+				if idS, ok := id.(string); ok && !seen[idS] {
+					stale = append(stale, id)
+				}
+			}
+
+			if len(stale) > 0 {
+				return self.cleanup(stale...)
+			}
+
+			return nil
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+func (self *Group) newArchiveMember(archive *Entry, name string, parent string) *Entry {
+	relPath := archive.RelativePath + `/` + name
+
+	return &Entry{
+		ID:                FileIdFromName(self.ID, relPath),
+		RelativePath:      relPath,
+		Parent:            parent,
+		RootGroup:         archive.RootGroup,
+		Container:         archive.ID,
+		LastModifiedAt:    archive.LastModifiedAt,
+		LastDeepScannedAt: time.Now().UnixNano(),
+		Metadata:          make(map[string]interface{}),
+	}
+}
+
+// Remove all virtual entries belonging to the given container entries.
+func removeContainedEntries(ids ...interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if f, err := ParseFilter(map[string]interface{}{
+		`container`: ids,
+	}); err == nil {
+		if values, err := Metadata.ListWithFilter([]string{`id`}, f); err == nil {
+			if memberIds, ok := values[`id`]; ok && len(memberIds) > 0 {
+				if err := Metadata.Delete(memberIds...); err == nil {
+					return removeEntryChunks(memberIds...)
+				} else {
+					return err
+				}
+			}
+
+			return nil
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
@@ -0,0 +1,273 @@
+package metabase
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/metabase/metadata"
+)
+
+// Describes files (e.g.: "movie.nfo", "movie.info.json", "poster.jpg") that are attachments of
+// another entry rather than being of interest on their own.
+//
+// Patterns are shell globs matched against filenames in the same directory as the primary file.
+// The placeholder "{base}" is replaced with the primary's filename without its extension, and
+// "{name}" with its full filename.  Rules with Directory set match files inside a directory and
+// attach them to the directory itself.  Types optionally limits which general file types (as
+// returned by metadata.GetGeneralFileType) a rule will attach sidecars to.
+type AttachmentRule struct {
+	Patterns  []string `json:"patterns"`
+	Directory bool     `json:"directory,omitempty"`
+	Types     []string `json:"types,omitempty"`
+	Hidden    bool     `json:"hidden"`
+}
+
+var DefaultAttachmentRules = []AttachmentRule{
+	{
+		Patterns: []string{`{base}.nfo`, `{base}.info.json`, `{name}.md5`, `{name}.sha1`, `{name}.sha256`},
+		Hidden:   true,
+	}, {
+		Patterns: []string{`{base}-poster.jpg`, `{base}-fanart.jpg`, `{base}-thumb.jpg`, `{base}.jpg`, `{base}.png`},
+		Types:    []string{`video`},
+		Hidden:   true,
+	}, {
+		Patterns: []string{`{base}.srt`, `{base}.*.srt`, `{base}.ass`, `{base}.*.ass`, `{base}.vtt`, `{base}.*.vtt`, `{base}.idx`, `{base}.sub`},
+		Types:    []string{`video`},
+	}, {
+		Patterns:  []string{`poster.jpg`, `folder.jpg`, `fanart.jpg`, `banner.jpg`, `cover.jpg`, `tvshow.nfo`, `artist.nfo`, `album.nfo`},
+		Directory: true,
+		Hidden:    true,
+	},
+}
+
+// The rules used to associate sidecar files with their primary entries.
+var AttachmentRules = DefaultAttachmentRules
+
+// resolved attachments for a single directory, keyed on filename
+type attachmentSet struct {
+	primaries   map[string]string
+	attachments map[string][]string
+	hidden      map[string]bool
+	changed     sync.Map
+}
+
+// caches resolved attachments for each directory seen during a scan
+var attachmentCache sync.Map
+
+func ValidateAttachmentRules(rules []AttachmentRule) error {
+	for i, rule := range rules {
+		if len(rule.Patterns) == 0 {
+			return fmt.Errorf("Attachment rule %d: at least one pattern is required", i)
+		}
+
+		for _, pattern := range rule.Patterns {
+			if _, err := filepath.Match(metadata.ExpandFilenamePattern(pattern, `x.y`), ``); err != nil {
+				return fmt.Errorf("Attachment rule %d: invalid pattern %q: %v", i, pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Work out which files in the given directory are sidecars of other files (or of the directory
+// itself, which is represented by an empty primary filename).  Sidecars are never primaries
+// themselves; if a file could belong to several primaries, the first one by name wins.
+func resolveAttachments(dir string, files []os.FileInfo) *attachmentSet {
+	set := &attachmentSet{
+		primaries:   make(map[string]string),
+		attachments: make(map[string][]string),
+		hidden:      make(map[string]bool),
+	}
+
+	names := make([]string, 0)
+
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	candidates := make(map[string][]string)
+	hidden := make(map[string]map[string]bool)
+
+	addCandidate := func(sidecar string, primary string, isHidden bool) {
+		if sidecar == primary {
+			return
+		}
+
+		if _, ok := hidden[sidecar]; !ok {
+			hidden[sidecar] = make(map[string]bool)
+		}
+
+		if !sliceutil.ContainsString(candidates[sidecar], primary) {
+			candidates[sidecar] = append(candidates[sidecar], primary)
+		}
+
+		hidden[sidecar][primary] = hidden[sidecar][primary] || isHidden
+	}
+
+	for _, rule := range AttachmentRules {
+		if rule.Directory {
+			for _, pattern := range rule.Patterns {
+				for _, name := range names {
+					if ok, _ := filepath.Match(pattern, name); ok {
+						addCandidate(name, ``, rule.Hidden)
+					}
+				}
+			}
+
+			continue
+		}
+
+		for _, primary := range names {
+			if len(rule.Types) > 0 && !sliceutil.ContainsString(rule.Types, metadata.GetGeneralFileType(path.Join(dir, primary))) {
+				continue
+			}
+
+			for _, pattern := range rule.Patterns {
+				pattern = metadata.ExpandFilenamePattern(pattern, primary)
+
+				// only filenames sharing the pattern's literal prefix can possibly match
+				prefix := pattern
+
+				if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+					prefix = pattern[:i]
+				}
+
+				for i := sort.SearchStrings(names, prefix); i < len(names) && strings.HasPrefix(names[i], prefix); i++ {
+					if ok, _ := filepath.Match(pattern, names[i]); ok {
+						addCandidate(names[i], primary, rule.Hidden)
+					}
+				}
+			}
+		}
+	}
+
+	for sidecar, primaries := range candidates {
+		sort.Strings(primaries)
+
+		for _, primary := range primaries {
+			if _, isSidecar := candidates[primary]; primary != `` && isSidecar {
+				continue
+			}
+
+			set.primaries[sidecar] = primary
+			set.attachments[primary] = append(set.attachments[primary], sidecar)
+			set.hidden[sidecar] = hidden[sidecar][primary]
+			break
+		}
+	}
+
+	for primary := range set.attachments {
+		sort.Strings(set.attachments[primary])
+	}
+
+	return set
+}
+
+// Retrieve the resolved attachments for the given directory, reading it if it hasn't been seen yet.
+func getAttachmentSet(dir string) *attachmentSet {
+	if v, ok := attachmentCache.Load(dir); ok {
+		return v.(*attachmentSet)
+	}
+
+	if files, err := ioutil.ReadDir(dir); err == nil {
+		set := resolveAttachments(dir, files)
+		attachmentCache.Store(dir, set)
+		return set
+	} else {
+		return nil
+	}
+}
+
+// Populate the attachment fields of an entry about to be scanned.  Returns the set that holds the
+// entry's own attachments (if any) so that changes to them can be checked.
+func (self *Group) populateAttachments(entry *Entry, name string, parent string, isDir bool) *attachmentSet {
+	dir, filename := path.Split(name)
+	dir = strings.TrimSuffix(dir, `/`)
+
+	if set := getAttachmentSet(dir); set != nil && !isDir {
+		if primary, ok := set.primaries[filename]; ok {
+			if primary == `` {
+				entry.AttachedTo = parent
+			} else {
+				entry.AttachedTo = NewEntry(self.ID, self.RootPath, path.Join(dir, primary)).ID
+			}
+
+			entry.Hidden = set.hidden[filename]
+		}
+	}
+
+	var set *attachmentSet
+	var sidecars []string
+
+	if isDir {
+		if set = getAttachmentSet(name); set != nil {
+			dir = name
+			sidecars = set.attachments[``]
+		}
+	} else if set = getAttachmentSet(dir); set != nil {
+		sidecars = set.attachments[filename]
+	}
+
+	for _, sidecar := range sidecars {
+		entry.Attachments = append(entry.Attachments, NewEntry(self.ID, self.RootPath, path.Join(dir, sidecar)).ID)
+	}
+
+	return set
+}
+
+// Reports whether the attachments of an entry have been added, removed, or modified since it was
+// last scanned.
+func attachmentsChanged(existing *Entry, entry *Entry, set *attachmentSet) bool {
+	if len(existing.Attachments) != len(entry.Attachments) {
+		return true
+	}
+
+	for i, id := range entry.Attachments {
+		if existing.Attachments[i] != id {
+			return true
+		}
+
+		if set != nil {
+			if _, ok := set.changed.Load(id); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Record that a sidecar was modified so that its primary entry is re-scanned as well.
+func markAttachmentChanged(entry *Entry) {
+	if entry.AttachedTo == `` {
+		return
+	}
+
+	if v, ok := attachmentCache.Load(path.Dir(entry.InitialPath)); ok {
+		v.(*attachmentSet).changed.Store(entry.ID, true)
+	}
+
+	changedEntries.Store(entry.AttachedTo, true)
+}
+
+// Sort a directory listing so that sidecars are scanned before the entries they are attached to.
+func sortSidecarsFirst(files []os.FileInfo, set *attachmentSet) {
+	sort.SliceStable(files, func(i, j int) bool {
+		_, a := set.primaries[files[i].Name()]
+		_, b := set.primaries[files[j].Name()]
+
+		return a && !b
+	})
+}
@@ -0,0 +1,204 @@
+package metabase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/metabase/metadata"
+	"github.com/stretchr/testify/require"
+)
+
+type testFileInfo struct {
+	name  string
+	isDir bool
+}
+
+func (self testFileInfo) Name() string       { return self.name }
+func (self testFileInfo) Size() int64        { return 0 }
+func (self testFileInfo) Mode() os.FileMode  { return 0644 }
+func (self testFileInfo) ModTime() time.Time { return time.Time{} }
+func (self testFileInfo) IsDir() bool        { return self.isDir }
+func (self testFileInfo) Sys() interface{}   { return nil }
+
+func testFiles(names ...string) []os.FileInfo {
+	files := make([]os.FileInfo, len(names))
+
+	for i, name := range names {
+		if name[len(name)-1] == '/' {
+			files[i] = testFileInfo{name: name[:len(name)-1], isDir: true}
+		} else {
+			files[i] = testFileInfo{name: name}
+		}
+	}
+
+	return files
+}
+
+func TestResolveAttachments(t *testing.T) {
+	assert := require.New(t)
+
+	for _, tc := range []struct {
+		files       []string
+		primaries   map[string]string
+		attachments map[string][]string
+		hidden      []string
+	}{
+		{
+			files: []string{`movie.mkv`, `movie.nfo`, `movie.en.srt`, `movie-poster.jpg`, `poster.jpg`, `notes.txt`},
+			primaries: map[string]string{
+				`movie.nfo`:        `movie.mkv`,
+				`movie.en.srt`:     `movie.mkv`,
+				`movie-poster.jpg`: `movie.mkv`,
+				`poster.jpg`:       ``,
+			},
+			attachments: map[string][]string{
+				`movie.mkv`: {`movie-poster.jpg`, `movie.en.srt`, `movie.nfo`},
+				``:          {`poster.jpg`},
+			},
+			hidden: []string{`movie.nfo`, `movie-poster.jpg`, `poster.jpg`},
+		}, {
+			// subtitles are only attached to videos
+			files: []string{`song.mp3`, `song.srt`, `song.nfo`},
+			primaries: map[string]string{
+				`song.nfo`: `song.mp3`,
+			},
+			attachments: map[string][]string{
+				`song.mp3`: {`song.nfo`},
+			},
+			hidden: []string{`song.nfo`},
+		}, {
+			// movie.jpg could be the primary of movie.nfo, but it is a sidecar itself
+			files: []string{`movie.mkv`, `movie.jpg`, `movie.nfo`},
+			primaries: map[string]string{
+				`movie.jpg`: `movie.mkv`,
+				`movie.nfo`: `movie.mkv`,
+			},
+			attachments: map[string][]string{
+				`movie.mkv`: {`movie.jpg`, `movie.nfo`},
+			},
+			hidden: []string{`movie.jpg`, `movie.nfo`},
+		}, {
+			// glob characters in filenames are matched literally, and directories are never sidecars
+			files: []string{`a[1].mkv`, `a[1].srt`, `a1.srt`, `a*.mkv`, `ab.srt`, `a[1].nfo/`},
+			primaries: map[string]string{
+				`a[1].srt`: `a[1].mkv`,
+			},
+			attachments: map[string][]string{
+				`a[1].mkv`: {`a[1].srt`},
+			},
+		}, {
+			// the first of several possible primaries (by name) wins
+			files: []string{`show.mkv`, `show.mp4`, `show.srt`},
+			primaries: map[string]string{
+				`show.srt`: `show.mkv`,
+			},
+			attachments: map[string][]string{
+				`show.mkv`: {`show.srt`},
+			},
+		},
+	} {
+		set := resolveAttachments(`/nonexistent`, testFiles(tc.files...))
+
+		assert.Equal(tc.primaries, set.primaries, "%v", tc.files)
+		assert.Equal(tc.attachments, set.attachments, "%v", tc.files)
+
+		for sidecar := range tc.primaries {
+			assert.Equal(contains(tc.hidden, sidecar), set.hidden[sidecar], "%v: %s", tc.files, sidecar)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestResolveAttachmentsDetectsTypes(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-attachments-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	source := metadata.MimeTypeSource
+	metadata.MimeTypeSource = metadata.MimeSourceAuto
+	defer func() {
+		metadata.MimeTypeSource = source
+	}()
+
+	// a video without an extension is identified by its contents, read from the directory
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, `movie`), append([]byte("\x1a\x45\xdf\xa3"), make([]byte, 60)...), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, `movie.srt`), nil, 0644))
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(err)
+
+	set := resolveAttachments(dir, files)
+	assert.Equal(`movie`, set.primaries[`movie.srt`])
+}
+
+func TestSidecarsFirstAndChanges(t *testing.T) {
+	assert := require.New(t)
+
+	dir := `/nonexistent/sidecars`
+	files := testFiles(`a.mkv`, `a.nfo`, `b.txt`, `poster.jpg`, `a.en.srt`)
+	set := resolveAttachments(dir, files)
+
+	// sidecars are scanned before their primaries, otherwise the listing order is kept
+	sortSidecarsFirst(files, set)
+
+	names := make([]string, len(files))
+
+	for i, file := range files {
+		names[i] = file.Name()
+	}
+
+	assert.Equal([]string{`a.nfo`, `poster.jpg`, `a.en.srt`, `a.mkv`, `b.txt`}, names)
+
+	attachmentCache.Store(dir, set)
+	defer attachmentCache.Delete(dir)
+
+	primary := &Entry{
+		ID:          `primary`,
+		Attachments: []string{`nfo`, `srt`},
+	}
+
+	existing := &Entry{
+		ID:          `primary`,
+		Attachments: []string{`nfo`, `srt`},
+	}
+
+	assert.False(attachmentsChanged(existing, primary, set))
+	assert.True(attachmentsChanged(&Entry{Attachments: []string{`nfo`}}, primary, set))
+	assert.True(attachmentsChanged(&Entry{Attachments: []string{`srt`, `nfo`}}, primary, set))
+
+	// modifying a sidecar marks its primary as changed
+	changedEntries.Delete(`primary`)
+
+	markAttachmentChanged(&Entry{
+		ID:          `srt`,
+		InitialPath: dir + `/a.en.srt`,
+		AttachedTo:  `primary`,
+	})
+
+	_, ok := changedEntries.Load(`primary`)
+	assert.True(ok)
+	assert.True(attachmentsChanged(existing, primary, set))
+
+	// entries that aren't attached to anything change nothing
+	markAttachmentChanged(&Entry{
+		ID:          `b`,
+		InitialPath: dir + `/b.txt`,
+	})
+
+	_, ok = set.changed.Load(`b`)
+	assert.False(ok)
+}
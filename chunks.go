@@ -0,0 +1,322 @@
+package metabase
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+
+	"github.com/ghetzel/pivot/dal"
+)
+
+// Chunk boundaries are determined by a gear-based rolling hash, so the same
+// content will produce the same chunks regardless of where it appears in a file.
+var ChunkMinSize = 512 * 1024
+var ChunkAverageSize = 2 * 1024 * 1024
+var ChunkMaxSize = 8 * 1024 * 1024
+
+var gearTable [256]uint64
+
+func init() {
+	// deterministically generate the gear table (splitmix64) so that every host
+	// computes identical chunk boundaries
+	seed := uint64(0x6d657461626173)
+
+	for i := range gearTable {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gearTable[i] = z ^ (z >> 31)
+	}
+}
+
+var ChunksSchema = &dal.Collection{
+	Name:              `chunks`,
+	IdentityFieldType: dal.StringType,
+	Fields: []dal.Field{
+		{
+			Name:     `entry`,
+			Type:     dal.StringType,
+			Required: true,
+		}, {
+			Name:     `root_group`,
+			Type:     dal.StringType,
+			Required: true,
+		}, {
+			Name: `index`,
+			Type: dal.IntType,
+		}, {
+			Name:      `offset`,
+			Type:      dal.IntType,
+			Validator: dal.ValidatePositiveOrZeroInteger,
+		}, {
+			Name:      `length`,
+			Type:      dal.IntType,
+			Validator: dal.ValidatePositiveOrZeroInteger,
+		}, {
+			Name:     `checksum`,
+			Type:     dal.StringType,
+			Required: true,
+		},
+	},
+}
+
+type Chunk struct {
+	ID        string `json:"id"`
+	EntryID   string `json:"entry"`
+	RootGroup string `json:"root_group"`
+	Index     int    `json:"index"`
+	Offset    int64  `json:"offset"`
+	Length    int64  `json:"length"`
+	Checksum  string `json:"checksum"`
+}
+
+type ChunkStats struct {
+	TotalChunks  int     `json:"total_chunks"`
+	UniqueChunks int     `json:"unique_chunks"`
+	TotalBytes   int64   `json:"total_bytes"`
+	UniqueBytes  int64   `json:"unique_bytes"`
+	DedupRatio   float64 `json:"dedup_ratio"`
+}
+
+// Split the data read from the given reader into content-defined chunks, returning
+// the offset, length, and SHA-1 checksum of each.
+func GenerateChunks(r io.Reader) ([]Chunk, error) {
+	chunks := make([]Chunk, 0)
+	buf := make([]byte, 1048576)
+	hash := sha1.New()
+	shift := uint(64 - bits.Len(uint(ChunkAverageSize-1)))
+
+	var fp uint64
+	var offset int64
+	var length int64
+
+	emit := func() {
+		chunks = append(chunks, Chunk{
+			Index:    len(chunks),
+			Offset:   offset,
+			Length:   length,
+			Checksum: hex.EncodeToString(hash.Sum(nil)),
+		})
+
+		offset += length
+		length = 0
+		fp = 0
+		hash.Reset()
+	}
+
+	for {
+		n, err := r.Read(buf)
+		start := 0
+
+		for i := 0; i < n; i++ {
+			length += 1
+			fp = (fp << 1) + gearTable[buf[i]]
+
+			if length >= int64(ChunkMaxSize) || (length >= int64(ChunkMinSize) && (fp>>shift) == 0) {
+				hash.Write(buf[start : i+1])
+				start = i + 1
+				emit()
+			}
+		}
+
+		hash.Write(buf[start:n])
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if length > 0 {
+		emit()
+	}
+
+	return chunks, nil
+}
+
+// Return the chunks in want whose checksums do not appear anywhere in have.
+func DiffChunks(have []Chunk, want []Chunk) []Chunk {
+	present := make(map[string]bool)
+	missing := make([]Chunk, 0)
+
+	for _, chunk := range have {
+		present[chunk.Checksum] = true
+	}
+
+	for _, chunk := range want {
+		if !present[chunk.Checksum] {
+			missing = append(missing, chunk)
+		}
+	}
+
+	return missing
+}
+
+func (self *Entry) GenerateChunks() ([]Chunk, error) {
+	if self.IsGroup {
+		return nil, fmt.Errorf("Cannot generate chunks on directory")
+	}
+
+	if file, err := os.Open(self.InitialPath); err == nil {
+		defer file.Close()
+
+		if chunks, err := GenerateChunks(file); err == nil {
+			for i, _ := range chunks {
+				chunks[i].ID = fmt.Sprintf("%s-%d", self.ID, chunks[i].Index)
+				chunks[i].EntryID = self.ID
+				chunks[i].RootGroup = self.RootGroup
+			}
+
+			return chunks, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Return the chunks from a remote copy of this item that are not present in the local copy.
+func (self *ManifestItem) MissingChunks(manifest *Manifest, remote []Chunk) ([]Chunk, error) {
+	if absPath, err := filepath.Abs(
+		filepath.Join(manifest.BaseDirectory, self.RelativePath),
+	); err == nil {
+		if _, err := os.Stat(absPath); os.IsNotExist(err) {
+			return remote, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		file := NewEntry(self.Label, manifest.BaseDirectory, absPath)
+
+		if local, err := file.GenerateChunks(); err == nil {
+			return DiffChunks(local, remote), nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Retrieve the stored chunks for the given entry, ordered by position in the file.
+func (self *DB) GetChunks(entryID string) ([]Chunk, error) {
+	if Chunks == nil {
+		return nil, fmt.Errorf("Chunking is not enabled")
+	}
+
+	if f, err := ParseFilter(map[string]interface{}{
+		`entry`: entryID,
+	}); err == nil {
+		var chunks []Chunk
+
+		f.Limit = 0
+		f.Sort = []string{`index`}
+
+		if err := Chunks.Find(f, &chunks); err == nil {
+			return chunks, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Report how much chunk-level duplication exists across the given root groups (or all of them).
+func (self *DB) GetChunkStats(rootGroups ...string) (*ChunkStats, error) {
+	if Chunks == nil {
+		return nil, fmt.Errorf("Chunking is not enabled")
+	}
+
+	query := map[string]interface{}{}
+
+	if len(rootGroups) > 0 {
+		query[`root_group`] = rootGroups
+	}
+
+	if f, err := ParseFilter(query); err == nil {
+		stats := &ChunkStats{}
+		seen := make(map[string]bool)
+
+		f.Limit = 0
+		f.Fields = []string{`id`, `checksum`, `length`}
+
+		if err := Chunks.FindFunc(f, Chunk{}, func(chunkI interface{}, err error) {
+			if err == nil {
+				if chunk, ok := chunkI.(*Chunk); ok {
+					stats.TotalChunks += 1
+					stats.TotalBytes += chunk.Length
+
+					if !seen[chunk.Checksum] {
+						seen[chunk.Checksum] = true
+						stats.UniqueChunks += 1
+						stats.UniqueBytes += chunk.Length
+					}
+				}
+			} else {
+				log.Warningf("Error reading chunk: %v", err)
+			}
+		}); err != nil {
+			return nil, err
+		}
+
+		if stats.UniqueBytes > 0 {
+			stats.DedupRatio = float64(stats.TotalBytes) / float64(stats.UniqueBytes)
+		}
+
+		return stats, nil
+	} else {
+		return nil, err
+	}
+}
+
+func (self *DB) updateEntryChunks(entry *Entry) error {
+	if Chunks == nil || self.ChunkThreshold <= 0 || entry.Size < self.ChunkThreshold {
+		return nil
+	}
+
+	if chunks, err := entry.GenerateChunks(); err == nil {
+		if err := removeEntryChunks(entry.ID); err != nil {
+			return err
+		}
+
+		for _, chunk := range chunks {
+			if err := Chunks.CreateOrUpdate(chunk.ID, chunk); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+func removeEntryChunks(ids ...interface{}) error {
+	if Chunks == nil || len(ids) == 0 {
+		return nil
+	}
+
+	if f, err := ParseFilter(map[string]interface{}{
+		`entry`: ids,
+	}); err == nil {
+		if values, err := Chunks.ListWithFilter([]string{`id`}, f); err == nil {
+			if chunkIds, ok := values[`id`]; ok && len(chunkIds) > 0 {
+				return Chunks.Delete(chunkIds...)
+			}
+
+			return nil
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
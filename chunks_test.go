@@ -0,0 +1,52 @@
+package metabase
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateChunks(t *testing.T) {
+	assert := require.New(t)
+
+	oldMin, oldAvg, oldMax := ChunkMinSize, ChunkAverageSize, ChunkMaxSize
+	ChunkMinSize, ChunkAverageSize, ChunkMaxSize = 2048, 8192, 65536
+
+	defer func() {
+		ChunkMinSize, ChunkAverageSize, ChunkMaxSize = oldMin, oldAvg, oldMax
+	}()
+
+	data := make([]byte, 1048576)
+	rand.New(rand.NewSource(42)).Read(data)
+
+	original, err := GenerateChunks(bytes.NewReader(data))
+	assert.Nil(err)
+	assert.True(len(original) > 1)
+
+	var total int64
+
+	for i, chunk := range original {
+		assert.Equal(i, chunk.Index)
+		assert.Equal(total, chunk.Offset)
+		assert.True(chunk.Length <= int64(ChunkMaxSize))
+		total += chunk.Length
+	}
+
+	assert.Equal(int64(len(data)), total)
+
+	// same content, same chunks
+	again, err := GenerateChunks(bytes.NewReader(data))
+	assert.Nil(err)
+	assert.Equal(original, again)
+	assert.Empty(DiffChunks(original, again))
+
+	// inserting data at the front should only change the first chunk
+	modified, err := GenerateChunks(bytes.NewReader(append([]byte(`prepended data`), data...)))
+	assert.Nil(err)
+
+	missing := DiffChunks(original, modified)
+	assert.Len(missing, 1)
+	assert.Equal(0, missing[0].Index)
+}
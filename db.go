@@ -3,6 +3,7 @@ package metabase
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
@@ -50,29 +51,53 @@ type PostInitializeFunc func(db *DB, backend backends.Backend) error
 type PostScanFunc func()
 
 type DB struct {
-	BaseDirectory      string                 `json:"base_dir"`
-	AutomigrateModels  bool                   `json:"automigrate"`
-	URI                string                 `json:"uri,omitempty"`
-	Indexer            string                 `json:"indexer,omitempty"`
-	MetadataURI        string                 `json:"metadata_uri,omitempty"`
-	MetadataIndexer    string                 `json:"metadata_indexer,omitempty"`
-	AdditionalIndexers []string               `json:"additional_indexers,omitempty"`
-	GlobalExclusions   []string               `json:"global_exclusions,omitempty"`
-	ScanInProgress     bool                   `json:"scan_in_progress"`
-	ExtractFields      []string               `json:"extract_fields,omitempty"`
-	SkipMigrate        bool                   `json:"skip_migrate"`
-	SkipChecksum       bool                   `json:"skip_checksum"`
-	StatsDatabase      string                 `json:"stats_database"`
-	StatsTags          map[string]interface{} `json:"stats_tags"`
-	GroupLister        GroupListFunc          `json:"-"`
-	ScanInterval       string                 `json:"scan_interval"`
-	PreInitialize      PreInitializeFunc      `json:"-"`
-	PostInitialize     PostInitializeFunc     `json:"-"`
-	db                 backends.Backend
-	metadataDb         backends.Backend
-	models             map[string]mapper.Mapper
-	postscanCallbacks  []PostScanFunc
-	scanSchedule       *cron.Cron
+	BaseDirectory        string                     `json:"base_dir"`
+	AutomigrateModels    bool                       `json:"automigrate"`
+	URI                  string                     `json:"uri,omitempty"`
+	Indexer              string                     `json:"indexer,omitempty"`
+	MetadataURI          string                     `json:"metadata_uri,omitempty"`
+	MetadataIndexer      string                     `json:"metadata_indexer,omitempty"`
+	AdditionalIndexers   []string                   `json:"additional_indexers,omitempty"`
+	GlobalExclusions     []string                   `json:"global_exclusions,omitempty"`
+	ScanInProgress       bool                       `json:"scan_in_progress"`
+	ExtractFields        []string                   `json:"extract_fields,omitempty"`
+	SkipMigrate          bool                       `json:"skip_migrate"`
+	SkipChecksum         bool                       `json:"skip_checksum"`
+	ChecksumAlgorithm    string                     `json:"checksum_algorithm,omitempty"`
+	ChunkThreshold       int64                      `json:"chunk_threshold,omitempty"`
+	IdScheme             string                     `json:"id_scheme,omitempty"`
+	WriteXattrs          bool                       `json:"write_xattrs"`
+	XattrFields          []string                   `json:"xattr_fields,omitempty"`
+	MimeTypeSource       string                     `json:"mime_type_source,omitempty"`
+	SystemMimeTypes      bool                       `json:"system_mime_types"`
+	MimeTypeFiles        []string                   `json:"mime_type_files,omitempty"`
+	MimeTypes            map[string]string          `json:"mime_types,omitempty"`
+	GeneralTypes         []metadata.GeneralTypeRule `json:"general_types,omitempty"`
+	PerceptualHash       bool                       `json:"perceptual_hash"`
+	EnabledLoaders       []string                   `json:"enabled_loaders,omitempty"`
+	DisabledLoaders      []string                   `json:"disabled_loaders,omitempty"`
+	CommandLoaders       []metadata.CommandRule     `json:"command_loaders,omitempty"`
+	SidecarLoaders       []metadata.SidecarRule     `json:"sidecar_loaders,omitempty"`
+	ThumbnailDirectory   string                     `json:"thumbnail_dir,omitempty"`
+	ThumbnailVideoOffset string                     `json:"thumbnail_video_offset,omitempty"`
+	FFProbeRaw           bool                       `json:"ffprobe_raw"`
+	LoaderTimeout        string                     `json:"loader_timeout,omitempty"`
+	LoaderTimeouts       map[string]string          `json:"loader_timeouts,omitempty"`
+	LoaderCacheDirectory string                     `json:"loader_cache_dir,omitempty"`
+	LoaderCacheMaxSize   int64                      `json:"loader_cache_max_size,omitempty"`
+	SkipLoaderCache      bool                       `json:"skip_loader_cache"`
+	AttachmentRules      []AttachmentRule           `json:"attachment_rules,omitempty"`
+	StatsDatabase        string                     `json:"stats_database"`
+	StatsTags            map[string]interface{}     `json:"stats_tags"`
+	GroupLister          GroupListFunc              `json:"-"`
+	ScanInterval         string                     `json:"scan_interval"`
+	PreInitialize        PreInitializeFunc          `json:"-"`
+	PostInitialize       PostInitializeFunc         `json:"-"`
+	db                   backends.Backend
+	metadataDb           backends.Backend
+	models               map[string]mapper.Mapper
+	postscanCallbacks    []PostScanFunc
+	scanSchedule         *cron.Cron
 }
 
 var Instance *DB
@@ -186,6 +211,153 @@ func (self *DB) Initialize() error {
 		return err
 	}
 
+	switch self.MimeTypeSource {
+	case ``:
+		metadata.MimeTypeSource = metadata.MimeSourceExtension
+	case metadata.MimeSourceExtension, metadata.MimeSourceDetected, metadata.MimeSourceAuto:
+		metadata.MimeTypeSource = self.MimeTypeSource
+	default:
+		return fmt.Errorf("Unsupported MIME type source %q", self.MimeTypeSource)
+	}
+
+	mimeTypeFiles := make([]string, 0)
+
+	if self.SystemMimeTypes {
+		for _, filename := range metadata.SystemMimeTypeFiles {
+			if _, err := os.Stat(filename); err == nil {
+				mimeTypeFiles = append(mimeTypeFiles, filename)
+			}
+		}
+	}
+
+	for _, filename := range self.MimeTypeFiles {
+		if v, err := pathutil.ExpandUser(filename); err == nil {
+			mimeTypeFiles = append(mimeTypeFiles, v)
+		} else {
+			return err
+		}
+	}
+
+	if err := metadata.ConfigureMimeTypes(mimeTypeFiles, self.MimeTypes); err != nil {
+		return err
+	}
+
+	// configured rules take precedence over the built-in ones
+	if err := metadata.SetGeneralTypeRules(self.GeneralTypes); err != nil {
+		return err
+	}
+
+	if self.PerceptualHash {
+		metadata.EnableLoader(`perceptual_hash`)
+	}
+
+	// each command rule is a loader of its own, writing to the rule's namespace
+	if err := metadata.SetCommandRules(self.CommandLoaders); err != nil {
+		return err
+	}
+
+	for _, rule := range metadata.CommandRules {
+		if err := metadata.SetLoaderDependencies(rule.LoaderName(), nil, []string{rule.Namespace}); err != nil {
+			return err
+		}
+	}
+
+	metadata.FFProbeIncludeRaw = self.FFProbeRaw
+
+	for _, name := range self.EnabledLoaders {
+		if err := metadata.EnableLoader(name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range self.DisabledLoaders {
+		if err := metadata.DisableLoader(name); err != nil {
+			return err
+		}
+	}
+
+	if self.LoaderTimeout != `` {
+		if timeout, err := time.ParseDuration(self.LoaderTimeout); err == nil {
+			metadata.DefaultLoaderTimeout = timeout
+		} else {
+			return fmt.Errorf("Invalid loader timeout: %v", err)
+		}
+	}
+
+	for name, value := range self.LoaderTimeouts {
+		if timeout, err := time.ParseDuration(value); err == nil {
+			if err := metadata.SetLoaderTimeout(name, timeout); err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("Invalid timeout for loader %q: %v", name, err)
+		}
+	}
+
+	if self.SkipLoaderCache {
+		loaderCache = nil
+	} else {
+		if self.LoaderCacheDirectory == `` {
+			self.LoaderCacheDirectory = filepath.Join(self.BaseDirectory, `loadercache`)
+		}
+
+		loaderCache = NewLoaderCache(self.LoaderCacheDirectory, self.LoaderCacheMaxSize)
+	}
+
+	if self.AttachmentRules != nil {
+		if err := ValidateAttachmentRules(self.AttachmentRules); err == nil {
+			AttachmentRules = self.AttachmentRules
+		} else {
+			return err
+		}
+	} else {
+		AttachmentRules = DefaultAttachmentRules
+	}
+
+	metadata.SidecarRules = append([]metadata.SidecarRule{}, metadata.DefaultSidecarRules...)
+
+	for _, rule := range self.SidecarLoaders {
+		if err := rule.Validate(); err == nil {
+			replaced := false
+
+			// rules named after a built-in rule replace it
+			for i, existing := range metadata.SidecarRules {
+				if existing.Name == rule.Name {
+					metadata.SidecarRules[i] = rule
+					replaced = true
+				}
+			}
+
+			if !replaced {
+				metadata.SidecarRules = append(metadata.SidecarRules, rule)
+			}
+		} else {
+			return err
+		}
+	}
+
+	if err := metadata.SetLoaderDependencies(`sidecar`, nil, metadata.SidecarProvides()); err != nil {
+		return err
+	}
+
+	switch self.IdScheme {
+	case ``:
+		FileIdScheme = IdSchemeMurmur64
+	case IdSchemeMurmur64, IdSchemeMurmur128:
+		FileIdScheme = self.IdScheme
+	default:
+		return fmt.Errorf("Unsupported ID scheme %q", self.IdScheme)
+	}
+
+	switch self.ChecksumAlgorithm {
+	case ``:
+		ChecksumAlgorithm = ChecksumSHA1
+	case ChecksumSHA1, ChecksumSHA256:
+		ChecksumAlgorithm = self.ChecksumAlgorithm
+	default:
+		return fmt.Errorf("Unsupported checksum algorithm %q", self.ChecksumAlgorithm)
+	}
+
 	// setup stats
 	if self.StatsDatabase != `` {
 		if err := mobius.Initialize(self.StatsDatabase, self.StatsTags); err != nil {
@@ -229,6 +401,15 @@ func (self *DB) Initialize() error {
 
 	Metadata, _ = self.models[MetadataSchema.Name]
 
+	// content-defined chunking is only tracked if a size threshold is configured
+	if self.ChunkThreshold > 0 {
+		if err := self.RegisterModel(ChunksSchema, self.metadataDb); err != nil {
+			return err
+		}
+
+		Chunks, _ = self.models[ChunksSchema.Name]
+	}
+
 	// give implementers a chance to do things to the underlying backend (e.g.: registering more models)
 	if err := self.PostInitialize(self, self.db); err != nil {
 		return err
@@ -311,6 +492,7 @@ func (self *DB) Scan(deep bool, labels ...string) error {
 	oldcount := backends.BleveBatchFlushCount
 	backends.BleveBatchFlushCount = SearchIndexFlushEveryNRecords
 	parentPathCache = sync.Map{}
+	attachmentCache = sync.Map{}
 
 	log.Debugf("Index record flush count: %d", backends.BleveBatchFlushCount)
 
@@ -518,6 +700,16 @@ func (self *DB) Cleanup(skipFileStats bool, skipRootGroupPrune bool) error {
 	deleteFn := func(ids []interface{}) int {
 		if l := len(ids); l > 0 {
 			if err := Metadata.Delete(ids...); err == nil {
+				if err := removeEntryChunks(ids...); err != nil {
+					log.Warningf("Error cleaning up chunks: %v", err)
+				}
+
+				if err := removeContainedEntries(ids...); err != nil {
+					log.Warningf("Error cleaning up archive members: %v", err)
+				}
+
+				self.removeThumbnails(ids...)
+
 				log.Debugf("Removed %d entries", l)
 				return l
 			} else {
@@ -531,7 +723,7 @@ func (self *DB) Cleanup(skipFileStats bool, skipRootGroupPrune bool) error {
 	cleanupFn := func() int {
 		entriesToDelete := make([]interface{}, 0)
 		allQuery := filter.All()
-		allQuery.Fields = []string{`id`, `name`, `root_group`, `parent`}
+		allQuery.Fields = []string{`id`, `name`, `root_group`, `parent`, `container`}
 
 		if err := Metadata.FindFunc(allQuery, Entry{}, func(entryI interface{}, err error) {
 			var entry *Entry
@@ -546,6 +738,16 @@ func (self *DB) Cleanup(skipFileStats bool, skipRootGroupPrune bool) error {
 			}
 
 			if err == nil {
+				// archive members exist for as long as their container does
+				if entry.IsVirtual() {
+					if !Metadata.Exists(entry.Container) {
+						entriesToDelete = append(entriesToDelete, entry.ID)
+						reportEntryDeletionStats(entry.RootGroup, entry)
+					}
+
+					return
+				}
+
 				// make sure the file actually exists
 				if absPath, err := entry.GetAbsolutePath(); err == nil {
 					if _, err := os.Stat(absPath); os.IsNotExist(err) {
@@ -587,6 +789,10 @@ func (self *DB) Cleanup(skipFileStats bool, skipRootGroupPrune bool) error {
 		}
 	}
 
+	if err := self.pruneThumbnails(); err != nil {
+		log.Warningf("Failed to prune thumbnails: %v", err)
+	}
+
 	if totalRemoved == 0 {
 		log.Debugf("Cleaned up %d entries.", totalRemoved)
 	} else {
@@ -596,6 +802,230 @@ func (self *DB) Cleanup(skipFileStats bool, skipRootGroupPrune bool) error {
 	return nil
 }
 
+// Rewrites all entries whose stored ID does not match the ID generated by the current
+// FileIdScheme, updating parent and container references to match.  Returns the number of entries
+// migrated; nothing is changed if two entries would end up with the same ID.
+func (self *DB) MigrateEntryIds() (int, error) {
+	if self.ScanInProgress {
+		return 0, fmt.Errorf("Cannot migrate IDs while a scan is running")
+	}
+
+	self.ScanInProgress = true
+
+	defer func() {
+		self.ScanInProgress = false
+	}()
+
+	newIds := make(map[string]string)
+	owners := make(map[string]*Entry)
+	allQuery := filter.All()
+	allQuery.Limit = 0
+	allQuery.Fields = []string{`id`, `name`, `root_group`}
+
+	var collision error
+
+	// first pass: work out which IDs are changing, making sure no two entries end up sharing one
+	if err := Metadata.FindFunc(allQuery, Entry{}, func(entryI interface{}, err error) {
+		if err == nil {
+			if entry, ok := entryI.(*Entry); ok {
+				id := entry.ID
+
+				if entry.RelativePath != `` {
+					if newId := FileIdFromName(entry.RootGroup, entry.RelativePath); newId != entry.ID {
+						newIds[entry.ID] = newId
+						id = newId
+					}
+				}
+
+				if existing, ok := owners[id]; ok {
+					if collision == nil {
+						collision = &IdCollisionError{
+							ID:                id,
+							RootGroup:         entry.RootGroup,
+							Name:              entry.RelativePath,
+							ExistingRootGroup: existing.RootGroup,
+							ExistingName:      existing.RelativePath,
+						}
+					}
+				} else {
+					owners[id] = entry
+				}
+			}
+		} else {
+			log.Warningf("Error reading entry during ID migration: %v", err)
+		}
+	}); err != nil {
+		return 0, err
+	} else if collision != nil {
+		return 0, collision
+	}
+
+	if len(newIds) == 0 {
+		return 0, nil
+	}
+
+	log.Noticef("Migrating %d entries to the %s ID scheme", len(newIds), FileIdScheme)
+
+	updated := make([]*Entry, 0)
+	oldIds := make([]interface{}, 0)
+	allQuery.Fields = nil
+
+	// second pass: collect the entries to rewrite under their new IDs (and children to point at
+	// their new parents); nothing is written until the iteration has finished
+	if err := Metadata.FindFunc(allQuery, Entry{}, func(entryI interface{}, err error) {
+		if err != nil {
+			log.Warningf("Error reading entry during ID migration: %v", err)
+			return
+		}
+
+		if entry, ok := entryI.(*Entry); ok {
+			newId, idChanged := newIds[entry.ID]
+			newParent, parentChanged := newIds[entry.Parent]
+			newContainer, containerChanged := newIds[entry.Container]
+			newAttachedTo, attachedToChanged := newIds[entry.AttachedTo]
+			attachmentsChanged := false
+
+			for i, id := range entry.Attachments {
+				if newAttachment, ok := newIds[id]; ok {
+					entry.Attachments[i] = newAttachment
+					attachmentsChanged = true
+				}
+			}
+
+			if !idChanged && !parentChanged && !containerChanged && !attachedToChanged && !attachmentsChanged {
+				return
+			}
+
+			if parentChanged {
+				entry.Parent = newParent
+			}
+
+			if containerChanged {
+				entry.Container = newContainer
+			}
+
+			if attachedToChanged {
+				entry.AttachedTo = newAttachedTo
+			}
+
+			if idChanged {
+				// an old ID may be reused as the new ID of another entry
+				if _, reused := owners[entry.ID]; !reused {
+					oldIds = append(oldIds, entry.ID)
+				}
+
+				entry.ID = newId
+			}
+
+			updated = append(updated, entry)
+		}
+	}); err != nil {
+		return 0, err
+	}
+
+	var migrated int
+
+	for _, entry := range updated {
+		if err := Metadata.CreateOrUpdate(entry.ID, entry); err == nil {
+			migrated += 1
+		} else {
+			return migrated, err
+		}
+	}
+
+	for i := 0; i < len(oldIds); i += 1000 {
+		batch := oldIds[i:]
+
+		if len(batch) > 1000 {
+			batch = batch[:1000]
+		}
+
+		if err := Metadata.Delete(batch...); err != nil {
+			log.Warningf("Error removing old entries during ID migration: %v", err)
+		}
+
+		// chunks will be regenerated under the new ID on the next deep scan
+		if err := removeEntryChunks(batch...); err != nil {
+			log.Warningf("Error removing old chunks during ID migration: %v", err)
+		}
+	}
+
+	parentPathCache = sync.Map{}
+
+	return migrated, nil
+}
+
+// Re-run loaders that previously failed on entries, optionally limited to the named loaders.  Returns
+// the number of entries that no longer have any recorded loader failures.
+func (self *DB) RetryFailedEntries(loaders ...string) (int, error) {
+	if self.ScanInProgress {
+		return 0, fmt.Errorf("Cannot retry entries while a scan is running")
+	}
+
+	self.ScanInProgress = true
+
+	defer func() {
+		self.ScanInProgress = false
+	}()
+
+	failedIds := make([]string, 0)
+	allQuery := filter.All()
+	allQuery.Limit = 0
+
+	if err := Metadata.FindFunc(allQuery, Entry{}, func(entryI interface{}, err error) {
+		if err == nil {
+			// archive members have no file of their own to run loaders against
+			if entry, ok := entryI.(*Entry); ok && !entry.IsVirtual() {
+				for name := range entry.LoaderErrors() {
+					if len(loaders) == 0 || sliceutil.ContainsString(loaders, name) {
+						failedIds = append(failedIds, entry.ID)
+						break
+					}
+				}
+			}
+		} else {
+			log.Warningf("Error reading entry during retry: %v", err)
+		}
+	}); err != nil {
+		return 0, err
+	}
+
+	var resolved int
+
+	for _, id := range failedIds {
+		var entry Entry
+
+		if err := Metadata.Get(id, &entry); err != nil {
+			log.Warningf("Failed to retrieve entry %s: %v", id, err)
+			continue
+		}
+
+		if absPath, err := entry.GetAbsolutePath(); err == nil {
+			entry.InitialPath = absPath
+		} else {
+			log.Warningf("Failed to retry entry %s: %v", id, err)
+			continue
+		}
+
+		if err := entry.RetryFailedLoaders(loaders...); err != nil {
+			log.Warningf("Failed to retry entry %s: %v", id, err)
+			continue
+		}
+
+		if err := Metadata.CreateOrUpdate(entry.ID, &entry); err != nil {
+			return resolved, err
+		}
+
+		if len(entry.LoaderErrors()) == 0 {
+			resolved += 1
+		}
+	}
+
+	log.Noticef("Retried %d entries with loader failures, %d resolved", len(failedIds), resolved)
+
+	return resolved, nil
+}
+
 func (self *DB) PollDirectories() {
 	for {
 		if !self.ScanInProgress {
@@ -3,15 +3,19 @@ package metabase
 import (
 	"bufio"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base32"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"math/big"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghetzel/go-stockutil/maputil"
@@ -24,9 +28,30 @@ import (
 
 const FileFingerprintSize = 16777216
 
+const (
+	IdSchemeMurmur64  = `murmur64`
+	IdSchemeMurmur128 = `murmur128`
+)
+
+const (
+	ChecksumSHA1   = `sha1`
+	ChecksumSHA256 = `sha256`
+)
+
+// The metadata key under which loader failures are recorded, keyed by loader name.
+const LoaderErrorsKey = `_loader_errors`
+
 var MetadataEncoding = base32.NewEncoding(`abcdefghijklmnopqrstuvwxyz234567`)
 var MaxChildEntries = 10000
 
+// The hashing scheme used to derive entry IDs from their root group and name.  Changing this
+// on an existing database requires a call to DB.MigrateEntryIds.
+var FileIdScheme = IdSchemeMurmur64
+
+// The hash used to generate entry checksums.  Checksums stored in "<file>.<algorithm>" sidecar files
+// and in the "user.checksum.<algorithm>" extended attribute are trusted in place of hashing the file.
+var ChecksumAlgorithm = ChecksumSHA1
+
 type Entry struct {
 	ID                string                 `json:"id"`
 	RelativePath      string                 `json:"name"`
@@ -42,12 +67,42 @@ type Entry struct {
 	LastDeepScannedAt int64                  `json:"last_deep_scanned_at,omitempty"`
 	CreatedAt         int64                  `json:"created_at,omitempty"`
 	Metadata          map[string]interface{} `json:"metadata"`
+	Container         string                 `json:"container,omitempty"`
+	AttachedTo        string                 `json:"attached_to,omitempty"`
+	Attachments       []string               `json:"attachments,omitempty"`
+	Hidden            bool                   `json:"hidden,omitempty"`
 	InitialPath       string                 `json:"-"`
 	info              os.FileInfo
 	metadataLoaded    bool
+	contentKey        string
+	contentKeyErr     error
 	ancestorIDs       []string
 }
 
+type IdCollisionError struct {
+	ID                string
+	RootGroup         string
+	Name              string
+	ExistingRootGroup string
+	ExistingName      string
+}
+
+func (self *IdCollisionError) Error() string {
+	return fmt.Sprintf(
+		"ID collision: %s:%s and %s:%s both hash to %q",
+		self.RootGroup,
+		self.Name,
+		self.ExistingRootGroup,
+		self.ExistingName,
+		self.ID,
+	)
+}
+
+func IsIdCollision(err error) bool {
+	_, ok := err.(*IdCollisionError)
+	return ok
+}
+
 type WalkFunc func(path string, file *Entry, err error) error // {}
 
 func NewEntry(rootGroup string, root string, name string) *Entry {
@@ -89,36 +144,238 @@ func (self *Entry) LoadMetadata(pass int) error {
 		return err
 	}
 
-	for _, loader := range metadata.GetLoadersForFile(self.InitialPath, pass) {
-		if data, err := loader.LoadMetadata(self.InitialPath); err == nil {
-			// unwrap dot-separated keys into a deeply nested map for iteration
-			if diffused, err := maputil.DiffuseMap(data, `.`); err == nil {
-				// recursively walk through all nested keys of the map, testing that leaf values
-				// are not empty before committing them to Metadata
-				if err := maputil.Walk(diffused, func(value interface{}, path []string, isLeaf bool) error {
-					if isLeaf {
-						if !typeutil.IsEmpty(value) {
-							maputil.DeepSet(self.Metadata, path, value)
-						}
-					}
+	// loaders within a level don't depend on each other's output, so they run concurrently
+	for _, level := range metadata.GetLoaderLevelsForFile(self.InitialPath, pass) {
+		if err := self.runLoaders(level, pass); err != nil {
+			return err
+		}
+	}
+
+	self.metadataLoaded = true
+
+	return nil
+}
+
+// Re-run the loaders that previously failed on this entry (optionally only the named loaders).
+// Failures for loaders that are disabled or no longer apply to the file are cleared.
+func (self *Entry) RetryFailedLoaders(only ...string) error {
+	if stat, err := os.Stat(self.InitialPath); err == nil {
+		self.info = stat
+	} else {
+		return err
+	}
 
-					return nil
-				}); err != nil {
-					log.Warningf("%T on %q: %v", loader, self.InitialPath, err)
+	names := make([]string, 0)
+
+	for name := range self.LoaderErrors() {
+		if len(only) == 0 || sliceutil.ContainsString(only, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		if registration, ok := metadata.GetLoaderRegistration(name); ok && registration.Enabled {
+			if loader := metadata.CanHandleFile(&metadata.NamedLoader{
+				Loader: registration.Loader,
+				Name:   registration.Name,
+			}, self.InitialPath); loader != nil {
+				if err := self.runLoaders([]metadata.Loader{loader}, registration.Pass); err != nil {
+					return err
 				}
-			} else {
-				return err
+
+				continue
 			}
-		} else {
-			log.Warningf("%T on %q: %v", loader, self.InitialPath, err)
 		}
+
+		self.clearLoaderError(name)
 	}
 
-	self.metadataLoaded = true
+	return nil
+}
+
+// Return the failures recorded by loaders on this entry, keyed by loader name.
+func (self *Entry) LoaderErrors() map[string]interface{} {
+	if errs, ok := self.Metadata[LoaderErrorsKey].(map[string]interface{}); ok {
+		return errs
+	}
+
+	return nil
+}
+
+// Run the given loaders concurrently against this entry, then merge their metadata into the entry
+// in order.  Loader failures are recorded on the entry rather than returned.
+func (self *Entry) runLoaders(loaders []metadata.Loader, pass int) error {
+	type loaderResult struct {
+		data map[string]interface{}
+		err  error
+	}
+
+	var existing map[string]interface{}
+	var wg sync.WaitGroup
+	results := make([]loaderResult, len(loaders))
+
+	for _, loader := range loaders {
+		// loaders that read existing metadata share a copy so they can't see partial results
+		if _, ok := metadata.UnwrapLoader(loader).(metadata.DependentLoader); ok && existing == nil {
+			existing = copyMetadata(self.Metadata)
+		}
+
+		// generate the content key up front rather than racing to do so in each loader
+		if _, ok := metadata.UnwrapLoader(loader).(metadata.CacheableLoader); ok && loaderCache != nil {
+			self.ContentKey()
+		}
+	}
+
+	for i, loader := range loaders {
+		wg.Add(1)
+
+		go func(i int, loader metadata.Loader) {
+			defer wg.Done()
+			results[i].data, results[i].err = self.runCachedLoader(loader, existing)
+		}(i, loader)
+	}
+
+	wg.Wait()
+
+	for i, loader := range loaders {
+		if err := self.mergeLoaderResult(loader, pass, results[i].data, results[i].err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *Entry) mergeLoaderResult(loader metadata.Loader, pass int, data map[string]interface{}, err error) error {
+	name := self.normalizeLoaderName(loader)
+
+	if err == nil {
+		self.clearLoaderError(name)
+
+		if replacing, ok := metadata.UnwrapLoader(loader).(metadata.ReplacingLoader); ok {
+			for _, key := range replacing.Replaces() {
+				deleteMetadataKey(self.Metadata, strings.Split(key, `.`))
+			}
+		}
+
+		// unwrap dot-separated keys into a deeply nested map for iteration
+		if diffused, err := maputil.DiffuseMap(data, `.`); err == nil {
+			// recursively walk through all nested keys of the map, testing that leaf values
+			// are not empty before committing them to Metadata
+			if err := maputil.Walk(diffused, func(value interface{}, path []string, isLeaf bool) error {
+				if isLeaf {
+					if !typeutil.IsEmpty(value) {
+						maputil.DeepSet(self.Metadata, path, value)
+					}
+				}
+
+				return nil
+			}); err != nil {
+				log.Warningf("%s on %q: %v", name, self.InitialPath, err)
+			}
+		} else {
+			return err
+		}
+	} else {
+		log.Warningf("%s on %q: %v", name, self.InitialPath, err)
+		self.setLoaderError(name, pass, err)
+	}
 
 	return nil
 }
 
+// Remove the value at the given path of nested metadata, along with any maps left empty.
+func deleteMetadataKey(data map[string]interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	} else if len(path) == 1 {
+		delete(data, path[0])
+		return
+	}
+
+	if child, ok := data[path[0]].(map[string]interface{}); ok {
+		deleteMetadataKey(child, path[1:])
+
+		if len(child) == 0 {
+			delete(data, path[0])
+		}
+	}
+}
+
+func copyMetadata(input map[string]interface{}) map[string]interface{} {
+	output := make(map[string]interface{}, len(input))
+
+	for key, value := range input {
+		output[key] = copyMetadataValue(value)
+	}
+
+	return output
+}
+
+func copyMetadataValue(value interface{}) interface{} {
+	switch value.(type) {
+	case map[string]interface{}:
+		return copyMetadata(value.(map[string]interface{}))
+	case []interface{}:
+		items := make([]interface{}, len(value.([]interface{})))
+
+		for i, item := range value.([]interface{}) {
+			items[i] = copyMetadataValue(item)
+		}
+
+		return items
+	default:
+		return value
+	}
+}
+
+func (self *Entry) setLoaderError(name string, pass int, err error) {
+	if self.Metadata == nil {
+		self.Metadata = make(map[string]interface{})
+	}
+
+	errs := self.LoaderErrors()
+
+	if errs == nil {
+		errs = make(map[string]interface{})
+		self.Metadata[LoaderErrorsKey] = errs
+	}
+
+	if registration, ok := metadata.GetLoaderRegistration(name); ok {
+		pass = registration.Pass
+	}
+
+	record := map[string]interface{}{
+		`error`:   err.Error(),
+		`panic`:   false,
+		`timeout`: false,
+		`at`:      time.Now(),
+		`pass`:    pass,
+	}
+
+	if loaderErr, ok := err.(*metadata.LoaderError); ok {
+		record[`error`] = loaderErr.Err.Error()
+		record[`panic`] = loaderErr.Panic
+		record[`timeout`] = loaderErr.Timeout
+
+		if loaderErr.Panic {
+			log.Debugf("%s panicked on %q: %s", name, self.InitialPath, loaderErr.Stack)
+		}
+	}
+
+	errs[name] = record
+}
+
+func (self *Entry) clearLoaderError(name string) {
+	if errs := self.LoaderErrors(); errs != nil {
+		delete(errs, name)
+
+		if len(errs) == 0 {
+			delete(self.Metadata, LoaderErrorsKey)
+		}
+	}
+}
+
 func (self *Entry) String() string {
 	if data, err := json.MarshalIndent(self, ``, `  `); err == nil {
 		return string(data[:])
@@ -127,7 +384,25 @@ func (self *Entry) String() string {
 	}
 }
 
+// Return the entries directly beneath this one, excluding hidden attachments.
 func (self *Entry) Children(filterString ...string) ([]*Entry, error) {
+	if children, err := self.AllChildren(filterString...); err == nil {
+		visible := make([]*Entry, 0, len(children))
+
+		for _, child := range children {
+			if !child.Hidden {
+				visible = append(visible, child)
+			}
+		}
+
+		return visible, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Return the entries directly beneath this one, including hidden attachments.
+func (self *Entry) AllChildren(filterString ...string) ([]*Entry, error) {
 	filterString = append(filterString, fmt.Sprintf("parent/%s", self.ID))
 
 	if f, err := ParseFilter(sliceutil.CompactString(filterString)); err == nil {
@@ -158,16 +433,24 @@ func (self *Entry) GenerateChecksum(forceRecalculate bool) (string, error) {
 		return ``, fmt.Errorf("Cannot generate checksum on directory")
 	}
 
+	hash, sumLength := newChecksumHash(ChecksumAlgorithm)
+
 	if !forceRecalculate {
-		if ckFile, err := os.Open(fmt.Sprintf("%s.sha1", self.InitialPath)); err == nil {
+		// a checksum cached in the file's extended attributes is trusted if the file hasn't changed since
+		if sum, ok := metadata.GetXattrChecksum(self.InitialPath, ChecksumAlgorithm); ok && stringutil.IsHexadecimal(sum, sumLength) {
+			return sum, nil
+		}
+
+		if ckFile, err := os.Open(fmt.Sprintf("%s.%s", self.InitialPath, ChecksumAlgorithm)); err == nil {
+			defer ckFile.Close()
 			scanner := bufio.NewScanner(ckFile)
 
 			for scanner.Scan() {
 				if scanner.Err() == nil {
 					parts := strings.SplitN(scanner.Text(), ` `, 3)
 
-					// looks for all the world like a SHA-1 sum....
-					if len(parts) == 3 && stringutil.IsHexadecimal(parts[0], 40) {
+					// looks for all the world like a checksum....
+					if len(parts) == 3 && stringutil.IsHexadecimal(parts[0], sumLength) {
 						if path.Base(parts[2]) == path.Base(self.InitialPath) {
 							return parts[0], nil
 						}
@@ -178,7 +461,7 @@ func (self *Entry) GenerateChecksum(forceRecalculate bool) (string, error) {
 	}
 
 	if fsFile, err := os.Open(self.InitialPath); err == nil {
-		hash := sha1.New()
+		defer fsFile.Close()
 
 		if _, err := io.Copy(hash, fsFile); err != nil {
 			return ``, err
@@ -191,6 +474,44 @@ func (self *Entry) GenerateChecksum(forceRecalculate bool) (string, error) {
 	}
 }
 
+// Return a new hash for the given checksum algorithm and the length of its hex-encoded sums.
+func newChecksumHash(algorithm string) (hash.Hash, int) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), 64
+	default:
+		return sha1.New(), 40
+	}
+}
+
+// Store this entry's checksum and the given metadata fields in the file's extended attributes.
+// Attributes that already hold the same value are not rewritten.
+func (self *Entry) WriteXattrs(fields []string) error {
+	if self.IsGroup {
+		return nil
+	}
+
+	attrs := make(map[string]string)
+
+	if self.Checksum != `` {
+		if checksumAttrs, err := metadata.XattrChecksumAttributes(self.InitialPath, ChecksumAlgorithm, self.Checksum); err == nil {
+			for key, value := range checksumAttrs {
+				attrs[key] = value
+			}
+		} else {
+			return err
+		}
+	}
+
+	for _, field := range fields {
+		if value := self.Get(field); !typeutil.IsEmpty(value) {
+			attrs[metadata.XattrMetadataPrefix+field] = metadata.EncodeXattrValue(value)
+		}
+	}
+
+	return metadata.UpdateXattrs(self.InitialPath, attrs)
+}
+
 func (self *Entry) GetAbsolutePath() (string, error) {
 	if rootDirectory, ok := rootGroupToPath[self.RootGroup]; ok {
 		return path.Join(rootDirectory, self.RelativePath), nil
@@ -270,17 +591,13 @@ func (self *Entry) GetManifest(fields []string, filterString string) (*Manifest,
 }
 
 func (self *Entry) normalizeLoaderName(loader metadata.Loader) string {
-	name := fmt.Sprintf("%T", loader)
-	name = strings.TrimPrefix(name, `metadata.`)
-	name = strings.TrimSuffix(name, `Loader`)
-
-	return stringutil.Underscore(name)
+	return metadata.LoaderName(loader)
 }
 
 func (self *Entry) Walk(walkFn WalkFunc, filterStrings ...string) error {
-	if self.IsGroup {
+	if self.IsGroup || self.ChildCount > 0 {
 		if err := walkFn(self.RelativePath, self, nil); err == nil {
-			if children, err := self.Children(filterStrings...); err == nil {
+			if children, err := self.AllChildren(filterStrings...); err == nil {
 				for _, child := range children {
 					if err := child.Walk(walkFn, filterStrings...); err != nil {
 						return err
@@ -301,11 +618,24 @@ func (self *Entry) Walk(walkFn WalkFunc, filterStrings ...string) error {
 
 func FileIdFromName(rootGroup string, name string) string {
 	uid := fmt.Sprintf("%s:%s", rootGroup, name)
-	hash64 := murmur3.Sum64([]byte(uid[:]))
-	return strings.TrimRight(
-		MetadataEncoding.EncodeToString(big.NewInt(int64(hash64)).Bytes()),
-		`=`,
-	)
+
+	switch FileIdScheme {
+	case IdSchemeMurmur128:
+		h1, h2 := murmur3.Sum128([]byte(uid[:]))
+		sum := make([]byte, 16)
+		binary.BigEndian.PutUint64(sum[0:8], h1)
+		binary.BigEndian.PutUint64(sum[8:16], h2)
+
+		return strings.TrimRight(MetadataEncoding.EncodeToString(sum), `=`)
+
+	default:
+		hash64 := murmur3.Sum64([]byte(uid[:]))
+
+		return strings.TrimRight(
+			MetadataEncoding.EncodeToString(big.NewInt(int64(hash64)).Bytes()),
+			`=`,
+		)
+	}
 }
 
 func NormalizeFileName(root string, name string) string {
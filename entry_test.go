@@ -0,0 +1,77 @@
+package metabase
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileIdFromName(t *testing.T) {
+	assert := require.New(t)
+
+	defer func() {
+		FileIdScheme = IdSchemeMurmur64
+	}()
+
+	FileIdScheme = IdSchemeMurmur64
+	short := FileIdFromName(`music`, `/artist/album/01.flac`)
+
+	assert.NotEmpty(short)
+	assert.Equal(short, FileIdFromName(`music`, `/artist/album/01.flac`))
+	assert.NotEqual(short, FileIdFromName(`music`, `/artist/album/02.flac`))
+	assert.NotEqual(short, FileIdFromName(`video`, `/artist/album/01.flac`))
+
+	FileIdScheme = IdSchemeMurmur128
+	long := FileIdFromName(`music`, `/artist/album/01.flac`)
+
+	assert.Len(long, 26)
+	assert.True(len(long) > len(short))
+	assert.Equal(long, FileIdFromName(`music`, `/artist/album/01.flac`))
+	assert.NotEqual(long, FileIdFromName(`music`, `/artist/album/02.flac`))
+}
+
+func TestIdCollisionError(t *testing.T) {
+	assert := require.New(t)
+
+	err := error(&IdCollisionError{
+		ID:                `abc`,
+		RootGroup:         `music`,
+		Name:              `/one.mp3`,
+		ExistingRootGroup: `music`,
+		ExistingName:      `/two.mp3`,
+	})
+
+	assert.True(IsIdCollision(err))
+	assert.False(IsIdCollision(SkipEntry))
+	assert.Contains(err.Error(), `/one.mp3`)
+	assert.Contains(err.Error(), `/two.mp3`)
+}
+
+func TestDeleteMetadataKey(t *testing.T) {
+	assert := require.New(t)
+
+	data := map[string]interface{}{
+		`media`: map[string]interface{}{
+			`subtitles`: []string{`a.srt`},
+			`title`:     `Title`,
+		},
+		`video`: map[string]interface{}{
+			`subtitle_languages`: []string{`en`},
+		},
+		`size`: 1,
+	}
+
+	deleteMetadataKey(data, []string{`media`, `subtitles`})
+	deleteMetadataKey(data, []string{`video`, `subtitle_languages`})
+	deleteMetadataKey(data, []string{`size`, `missing`})
+	deleteMetadataKey(data, []string{`missing`, `key`})
+	deleteMetadataKey(data, nil)
+
+	// maps left empty are removed too
+	assert.Equal(map[string]interface{}{
+		`media`: map[string]interface{}{
+			`title`: `Title`,
+		},
+		`size`: 1,
+	}, data)
+}
@@ -20,22 +20,25 @@ import (
 )
 
 type Group struct {
-	ID                   string                 `json:"id"`
-	Path                 string                 `json:"path"`
-	Parent               string                 `json:"parent"`
-	RootPath             string                 `json:"-"`
-	FilePattern          string                 `json:"file_pattern,omitempty"`
-	NoRecurseDirectories bool                   `json:"no_recurse"`
-	FollowSymlinks       bool                   `json:"follow_symlinks"`
-	FileMinimumSize      int                    `json:"min_file_size,omitempty"`
-	DeepScan             bool                   `json:"deep_scan"`
-	SkipChecksum         bool                   `json:"skip_checksum"`
-	CurrentPass          int                    `json:"-"`
-	PassesDone           int                    `json:"-"`
-	TargetSubgroups      []string               `json:"-"`
-	FileCount            int                    `json:"file_count"`
-	ModifiedFileCount    int                    `json:"modified_file_count"`
-	Properties           map[string]interface{} `json:"properties,omitempty"`
+	ID                   string                    `json:"id"`
+	Path                 string                    `json:"path"`
+	Parent               string                    `json:"parent"`
+	RootPath             string                    `json:"-"`
+	FilePattern          string                    `json:"file_pattern,omitempty"`
+	NoRecurseDirectories bool                      `json:"no_recurse"`
+	FollowSymlinks       bool                      `json:"follow_symlinks"`
+	FileMinimumSize      int                       `json:"min_file_size,omitempty"`
+	DeepScan             bool                      `json:"deep_scan"`
+	SkipChecksum         bool                      `json:"skip_checksum"`
+	IndexArchives        bool                      `json:"index_archives"`
+	Presets              []string                  `json:"presets,omitempty"`
+	ExtractPatterns      []metadata.ExtractPattern `json:"extract_patterns,omitempty"`
+	CurrentPass          int                       `json:"-"`
+	PassesDone           int                       `json:"-"`
+	TargetSubgroups      []string                  `json:"-"`
+	FileCount            int                       `json:"file_count"`
+	ModifiedFileCount    int                       `json:"modified_file_count"`
+	Properties           map[string]interface{}    `json:"properties,omitempty"`
 	compiledIgnoreList   *util.GitIgnore
 	parentGroup          *Group
 	db                   *DB
@@ -80,6 +83,21 @@ func (self *Group) Initialize() error {
 
 	PopulateGroup(self)
 
+	// subdirectories share the profile registered by their root group
+	if self.parentGroup == nil {
+		profile := &metadata.GroupProfile{
+			Presets: self.Presets,
+		}
+
+		for i := range self.ExtractPatterns {
+			profile.ExtractPatterns = append(profile.ExtractPatterns, &self.ExtractPatterns[i])
+		}
+
+		if err := metadata.SetGroupProfile(self.RootPath, profile); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -193,6 +211,10 @@ func (self *Group) Scan(subgroups []string) error {
 	self.ModifiedFileCount = 0
 
 	if fileStats, err := ioutil.ReadDir(self.Path); err == nil {
+		set := resolveAttachments(self.Path, fileStats)
+		attachmentCache.Store(self.Path, set)
+		sortSidecarsFirst(fileStats, set)
+
 		for _, file := range fileStats {
 			if err := self.ScanPath(path.Join(self.Path, file.Name())); err == SkipEntry {
 				continue
@@ -273,6 +295,7 @@ func (self *Group) ScanPath(absPath string) error {
 					subdirectory.FilePattern = self.FilePattern
 					subdirectory.FollowSymlinks = self.FollowSymlinks
 					subdirectory.ID = self.ID
+					subdirectory.IndexArchives = self.IndexArchives
 					subdirectory.NoRecurseDirectories = self.NoRecurseDirectories
 					subdirectory.Parent = dirEntry.ID
 					subdirectory.parentGroup = self
@@ -323,6 +346,9 @@ func (self *Group) ScanPath(absPath string) error {
 						if _, err := self.scanEntry(absPath, parent, true); err == nil {
 							// cleanup entries for whom we are the parent
 							parentsCleanup = append(parentsCleanup, subdirectory.Parent)
+						} else if IsIdCollision(err) {
+							log.Errorf("PASS %d: [%s] %v", self.CurrentPass, self.ID, err)
+							return SkipEntry
 						} else {
 							return err
 						}
@@ -341,6 +367,9 @@ func (self *Group) ScanPath(absPath string) error {
 			// scan the entry as a sharable asset
 			if _, err := self.scanEntry(absPath, parent, false); err == nil {
 				self.FileCount += 1
+			} else if IsIdCollision(err) {
+				log.Errorf("PASS %d: [%s] %v", self.CurrentPass, self.ID, err)
+				return SkipEntry
 			} else {
 				return err
 			}
@@ -465,6 +494,7 @@ func (self *Group) scanEntry(name string, parent string, isDir bool) (*Entry, er
 
 	// get entry implementation
 	entry := NewEntry(self.ID, self.RootPath, name)
+	attachments := self.populateAttachments(entry, name, parent, isDir)
 
 	// skip the entry if it's in the global exclusions list (case sensitive exact match)
 	if sliceutil.ContainsString(Instance.GlobalExclusions, path.Base(name)) {
@@ -489,18 +519,36 @@ func (self *Group) scanEntry(name string, parent string, isDir bool) (*Entry, er
 		var existingFile Entry
 
 		if err := Metadata.Get(entry.ID, &existingFile); err == nil {
+			// make sure we're not about to overwrite a different file that happens to hash to the same ID
+			if existingFile.RelativePath != `` {
+				if existingFile.RelativePath != entry.RelativePath || existingFile.RootGroup != entry.RootGroup {
+					mobius.Increment(`metabase.db.entry.id_collisions`, map[string]interface{}{
+						`root_group`: self.ID,
+					})
+
+					return nil, &IdCollisionError{
+						ID:                entry.ID,
+						RootGroup:         entry.RootGroup,
+						Name:              entry.RelativePath,
+						ExistingRootGroup: existingFile.RootGroup,
+						ExistingName:      existingFile.RelativePath,
+					}
+				}
+			}
+
 			if !self.DeepScan && existingFile.LastDeepScannedAt > 0 {
 				// trigger a deep scan if the data is considered stale
 				if MaxTimeBetweenDeepScans == 0 || time.Since(time.Unix(0, entry.LastDeepScannedAt)) < MaxTimeBetweenDeepScans {
 					absModTimeDiff := math.Abs(float64(entry.LastModifiedAt) - float64(existingFile.LastModifiedAt))
 
-					if absModTimeDiff < 1e9 && self.hasNotChanged(entry.ID) {
+					if absModTimeDiff < 1e9 && self.hasNotChanged(entry.ID) && !attachmentsChanged(&existingFile, entry, attachments) {
 						return &existingFile, nil
 					}
 				}
 			}
 
 			entry.Metadata = existingFile.Metadata
+			entry.ChildCount = existingFile.ChildCount
 		}
 	} else if os.IsNotExist(err) {
 		return nil, err
@@ -518,6 +566,8 @@ func (self *Group) scanEntry(name string, parent string, isDir bool) (*Entry, er
 		changedEntries.Store(id, true)
 	}
 
+	markAttachmentChanged(entry)
+
 	entry.Parent = parent
 	entry.RootGroup = self.ID
 	entry.IsGroup = isDir
@@ -561,6 +611,10 @@ func (self *Group) scanEntry(name string, parent string, isDir bool) (*Entry, er
 				} else {
 					return nil, err
 				}
+
+				if err := self.db.updateEntryChunks(entry); err != nil {
+					return nil, err
+				}
 			}
 		}
 
@@ -572,6 +626,22 @@ func (self *Group) scanEntry(name string, parent string, isDir bool) (*Entry, er
 		}
 	}
 
+	// expose archive members as virtual child entries (if enabled)
+	if entry.Type == `archive` && (self.CurrentPass == 0 || metadata.IsFinalizePass(self.CurrentPass)) {
+		if self.IndexArchives {
+			if err := self.indexArchive(entry, name); err != nil {
+				log.Warningf("PASS %d: [%s] Failed to index archive %s: %v", self.CurrentPass, self.ID, name, err)
+			}
+		} else if entry.ChildCount > 0 {
+			if err := removeContainedEntries(entry.ID); err == nil {
+				entry.ChildCount = 0
+				delete(entry.Metadata, ArchiveIndexKey)
+			} else {
+				log.Warningf("PASS %d: [%s] Failed to remove archive members of %s: %v", self.CurrentPass, self.ID, name, err)
+			}
+		}
+	}
+
 	// persist the entry record
 	if err := Metadata.CreateOrUpdate(entry.ID, entry); err != nil {
 		return nil, err
@@ -582,6 +652,15 @@ func (self *Group) scanEntry(name string, parent string, isDir bool) (*Entry, er
 		`directory`:  isDir,
 	})
 
+	// write the checksum and selected metadata back to the file (if enabled)
+	if self.db.WriteXattrs && !entry.IsGroup {
+		if self.CurrentPass == 0 || metadata.IsFinalizePass(self.CurrentPass) {
+			if err := entry.WriteXattrs(self.db.XattrFields); err != nil && err != metadata.ErrXattrUnsupported {
+				log.Warningf("PASS %d: [%s] Failed to write extended attributes to %s: %v", self.CurrentPass, self.ID, name, err)
+			}
+		}
+	}
+
 	return entry, nil
 }
 
@@ -644,6 +723,16 @@ func (self *Group) cleanupMissingEntries(query interface{}, force bool) error {
 					}
 				}
 
+				// archive members exist for as long as their container does
+				if entry.IsVirtual() {
+					if !Metadata.Exists(entry.Container) {
+						entriesToDelete = append(entriesToDelete, entry.ID)
+						reportEntryDeletionStats(self.ID, &entry)
+					}
+
+					continue
+				}
+
 				if absPath, err := entry.GetAbsolutePath(); err == nil {
 					if _, err := os.Stat(absPath); os.IsNotExist(err) {
 						entriesToDelete = append(entriesToDelete, entry.ID)
@@ -684,7 +773,15 @@ func (self *Group) cleanupMissingEntriesUnderParents(parents []string, force boo
 
 func (self *Group) cleanup(entries ...interface{}) error {
 	if err := Metadata.Delete(entries...); err == nil {
-		return nil
+		if self.db != nil {
+			self.db.removeThumbnails(entries...)
+		}
+
+		if err := removeContainedEntries(entries...); err != nil {
+			return err
+		}
+
+		return removeEntryChunks(entries...)
 	} else {
 		return err
 	}
@@ -0,0 +1,303 @@
+package metabase
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/metabase/metadata"
+)
+
+// The default maximum size (in bytes) of the loader result cache.
+var DefaultLoaderCacheMaxSize int64 = 268435456
+
+// When the cache exceeds its maximum size, the least recently used results are removed until
+// it is below this fraction of the maximum.
+var LoaderCacheEvictTarget = 0.9
+
+// Caches the output of loaders that implement metadata.CacheableLoader, keyed on the loader's
+// name and version and the contents of the file it ran against.
+type LoaderCache struct {
+	Directory string
+	MaxSize   int64
+	size      int64
+	sized     bool
+	lock      sync.Mutex
+}
+
+var loaderCache *LoaderCache
+
+func init() {
+	// results are stored with gob (rather than JSON) so that cached values keep their types (e.g.:
+	// time.Time, int); these are the types that loaders return inside interface{} values beyond the
+	// basic ones gob already knows about
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register([]map[string]interface{}{})
+	gob.Register(map[string]string{})
+	gob.Register(time.Time{})
+	gob.Register(time.Duration(0))
+}
+
+func NewLoaderCache(directory string, maxSize int64) *LoaderCache {
+	if maxSize <= 0 {
+		maxSize = DefaultLoaderCacheMaxSize
+	}
+
+	return &LoaderCache{
+		Directory: directory,
+		MaxSize:   maxSize,
+	}
+}
+
+func (self *LoaderCache) path(key string) string {
+	return filepath.Join(self.Directory, thumbnailShard(key), key+`.gob`)
+}
+
+// Return the cache key for the output of the named loader (see metadata.LoaderName) on a file with
+// the given content key.
+func (self *LoaderCache) Key(name string, loader metadata.CacheableLoader, contentKey string) string {
+	hash := sha1.New()
+
+	fmt.Fprintf(hash, "%s\x00%s\x00%s", name, loader.LoaderVersion(), contentKey)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Retrieve a cached loader result.
+func (self *LoaderCache) Get(key string) (map[string]interface{}, bool) {
+	filename := self.path(key)
+
+	if data, err := ioutil.ReadFile(filename); err == nil {
+		var result map[string]interface{}
+
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&result); err == nil {
+			// track when the result was last used for eviction purposes
+			now := time.Now()
+			os.Chtimes(filename, now, now)
+
+			return result, true
+		}
+	}
+
+	return nil, false
+}
+
+// Store a loader result in the cache, evicting older results if the cache has grown too large.
+// Results containing types that can't be encoded (see init) are not cached.
+func (self *LoaderCache) Set(key string, result map[string]interface{}) error {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(result); err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	filename := self.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	var replaced int64
+
+	if stat, err := os.Stat(filename); err == nil {
+		replaced = stat.Size()
+	}
+
+	// write to a temporary file first so that readers never see partial results
+	if tmp, err := ioutil.TempFile(filepath.Dir(filename), `.tmp-`); err == nil {
+		_, err := tmp.Write(data)
+		tmp.Close()
+
+		if err == nil {
+			err = os.Rename(tmp.Name(), filename)
+		}
+
+		if err != nil {
+			os.Remove(tmp.Name())
+			return err
+		}
+	} else {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if !self.sized {
+		if size, err := self.currentSize(); err == nil {
+			self.size = size
+			self.sized = true
+		} else {
+			return err
+		}
+	} else {
+		self.size += int64(len(data)) - replaced
+	}
+
+	if self.size > self.MaxSize {
+		return self.evict()
+	}
+
+	return nil
+}
+
+func (self *LoaderCache) currentSize() (int64, error) {
+	var size int64
+
+	err := filepath.Walk(self.Directory, func(name string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+
+		return err
+	})
+
+	return size, err
+}
+
+// Remove the least recently used results until the cache is below its eviction target.
+func (self *LoaderCache) evict() error {
+	files := make([]os.FileInfo, 0)
+	paths := make(map[os.FileInfo]string)
+	var size int64
+
+	if err := filepath.Walk(self.Directory, func(name string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, info)
+			paths[info] = name
+			size += info.Size()
+		}
+
+		return err
+	}); err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	target := int64(float64(self.MaxSize) * LoaderCacheEvictTarget)
+	var removed int
+
+	for _, info := range files {
+		if size <= target {
+			break
+		}
+
+		if err := os.Remove(paths[info]); err == nil {
+			size -= info.Size()
+			removed += 1
+		}
+	}
+
+	self.size = size
+	log.Debugf("Loader cache: evicted %d results", removed)
+
+	return nil
+}
+
+// Run the given loader against this entry's file, using the loader cache if the loader supports it.
+// The output of loaders that depend on other loaders is never cached.
+func (self *Entry) runCachedLoader(loader metadata.Loader, existing map[string]interface{}) (map[string]interface{}, error) {
+	_, dependent := metadata.UnwrapLoader(loader).(metadata.DependentLoader)
+
+	if cacheable, ok := metadata.UnwrapLoader(loader).(metadata.CacheableLoader); ok && !dependent && loaderCache != nil {
+		if contentKey, err := self.ContentKey(); err == nil {
+			key := loaderCache.Key(metadata.LoaderName(loader), cacheable, contentKey)
+
+			if data, ok := loaderCache.Get(key); ok {
+				return data, nil
+			}
+
+			if data, err := metadata.RunLoader(loader, self.InitialPath, existing); err == nil {
+				if err := loaderCache.Set(key, data); err != nil {
+					log.Warningf("Failed to cache %s result for %q: %v", metadata.LoaderName(loader), self.InitialPath, err)
+				}
+
+				return data, nil
+			} else {
+				return nil, err
+			}
+		} else {
+			log.Warningf("Failed to fingerprint %q: %v", self.InitialPath, err)
+		}
+	}
+
+	return metadata.RunLoader(loader, self.InitialPath, existing)
+}
+
+// Return a key identifying the contents of this entry's file.  A known checksum is used if
+// available, otherwise a fingerprint is generated from the file's size and contents.  Files larger than
+// FileFingerprintSize are only sampled, so their modification time is included as well.
+func (self *Entry) ContentKey() (string, error) {
+	if self.contentKey != `` || self.contentKeyErr != nil {
+		return self.contentKey, self.contentKeyErr
+	}
+
+	if self.Checksum != `` {
+		self.contentKey = self.Checksum
+	} else if sum, ok := metadata.GetXattrChecksum(self.InitialPath, ChecksumAlgorithm); ok {
+		self.contentKey = sum
+	} else if fingerprint, err := generateFingerprint(self.InitialPath); err == nil {
+		self.contentKey = fingerprint
+	} else {
+		self.contentKeyErr = err
+		return ``, err
+	}
+
+	return self.contentKey, nil
+}
+
+func generateFingerprint(name string) (string, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return ``, err
+	}
+
+	defer file.Close()
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		return ``, err
+	}
+
+	hash := sha1.New()
+	binary.Write(hash, binary.BigEndian, stat.Size())
+
+	if stat.Size() <= FileFingerprintSize {
+		if _, err := io.Copy(hash, file); err != nil {
+			return ``, err
+		}
+	} else {
+		// sampling can miss changes in the middle of large files, but those almost always change the mtime
+		binary.Write(hash, binary.BigEndian, stat.ModTime().UnixNano())
+
+		// large files are sampled at the start, end, and two points in between
+		segments := int64(4)
+		segmentSize := int64(FileFingerprintSize) / segments
+		stride := (stat.Size() - segmentSize) / (segments - 1)
+
+		for i := int64(0); i < segments; i++ {
+			if _, err := io.Copy(hash, io.NewSectionReader(file, i*stride, segmentSize)); err != nil {
+				return ``, err
+			}
+		}
+	}
+
+	return `fp:` + hex.EncodeToString(hash.Sum(nil)), nil
+}
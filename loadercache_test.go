@@ -0,0 +1,136 @@
+package metabase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoaderCache(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-loadercache-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cache := NewLoaderCache(dir, 0)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	result := map[string]interface{}{
+		`image`: map[string]interface{}{
+			`width`:      640,
+			`created_at`: created,
+			`keywords`:   []string{`one`, `two`},
+			`ratio`:      1.5,
+			`tags`:       map[string]string{`a`: `b`},
+			`values`:     []interface{}{1, `two`},
+		},
+		`size`: int64(1234),
+	}
+
+	_, ok := cache.Get(`abc`)
+	assert.False(ok)
+
+	// cached results keep the types the loader returned
+	assert.NoError(cache.Set(`abc`, result))
+
+	cached, ok := cache.Get(`abc`)
+	assert.True(ok)
+	assert.Equal(result, cached)
+
+	// replacing a result doesn't count the old one towards the cache size
+	assert.NoError(cache.Set(`abc`, map[string]interface{}{`a`: 1}))
+	assert.NoError(cache.Set(`abc`, map[string]interface{}{`a`: 12345678}))
+
+	size, err := cache.currentSize()
+	assert.NoError(err)
+	assert.Equal(size, cache.size)
+
+	// results that can't be encoded aren't cached
+	assert.Error(cache.Set(`def`, map[string]interface{}{`a`: struct{ X chan int }{}}))
+
+	// the least recently used results are evicted once the cache is full
+	cache.MaxSize = 4 * size
+
+	for _, key := range []string{`k1`, `k2`, `k3`, `k4`, `k5`, `k6`} {
+		assert.NoError(cache.Set(key, map[string]interface{}{`a`: 12345678}))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(cache.size <= cache.MaxSize)
+
+	size, err = cache.currentSize()
+	assert.NoError(err)
+	assert.Equal(size, cache.size)
+
+	_, ok = cache.Get(`abc`)
+	assert.False(ok)
+
+	_, ok = cache.Get(`k6`)
+	assert.True(ok)
+}
+
+func TestContentKey(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-contentkey-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, `file.txt`)
+	assert.NoError(ioutil.WriteFile(name, []byte(`hello`), 0644))
+
+	first, err := generateFingerprint(name)
+	assert.NoError(err)
+
+	second, err := generateFingerprint(name)
+	assert.NoError(err)
+	assert.Equal(first, second)
+
+	// small files are hashed in full, so identical copies share a key regardless of modification time
+	copied := filepath.Join(dir, `copy.txt`)
+	assert.NoError(ioutil.WriteFile(copied, []byte(`hello`), 0644))
+
+	modTime := time.Now().Add(-time.Hour)
+	assert.NoError(os.Chtimes(copied, modTime, modTime))
+
+	second, err = generateFingerprint(copied)
+	assert.NoError(err)
+	assert.Equal(first, second)
+
+	assert.NoError(ioutil.WriteFile(copied, []byte(`world`), 0644))
+
+	second, err = generateFingerprint(copied)
+	assert.NoError(err)
+	assert.NotEqual(first, second)
+
+	// large files are only sampled, so a different modification time changes the key
+	large := filepath.Join(dir, `large.bin`)
+	assert.NoError(ioutil.WriteFile(large, nil, 0644))
+	assert.NoError(os.Truncate(large, FileFingerprintSize+1))
+
+	first, err = generateFingerprint(large)
+	assert.NoError(err)
+
+	assert.NoError(os.Chtimes(large, modTime, modTime))
+
+	second, err = generateFingerprint(large)
+	assert.NoError(err)
+	assert.NotEqual(first, second)
+
+	entry := &Entry{
+		InitialPath: name,
+		Checksum:    `0123456789abcdef`,
+	}
+
+	key, err := entry.ContentKey()
+	assert.NoError(err)
+	assert.Equal(`0123456789abcdef`, key)
+
+	_, err = generateFingerprint(filepath.Join(dir, `missing.txt`))
+	assert.Error(err)
+}
@@ -0,0 +1,307 @@
+package metadata
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The maximum number of members that will be listed from a single archive.
+var ArchiveMaxMembers = 100000
+
+type ArchiveMember struct {
+	Name           string
+	Size           int64
+	CompressedSize int64
+	ModTime        time.Time
+	Mode           os.FileMode
+	IsDir          bool
+	Encrypted      bool
+	CRC32          uint32
+}
+
+type ArchiveListing struct {
+	Format    string
+	Members   []ArchiveMember
+	Encrypted bool
+	Truncated bool
+
+	// Some archives (e.g.: rar, or 7z with compressed headers) are only identified; their members
+	// are not listed.
+	Listable bool
+}
+
+// Returns the total number of files, directories, and uncompressed bytes in the listing.
+func (self *ArchiveListing) Totals() (files int, directories int, size int64) {
+	for _, member := range self.Members {
+		if member.IsDir {
+			directories += 1
+		} else {
+			files += 1
+			size += member.Size
+		}
+	}
+
+	return
+}
+
+type ArchiveLoader struct {
+	Loader
+}
+
+func (self *ArchiveLoader) CanHandle(name string) Loader {
+	if GetGeneralFileType(name) == `archive` {
+		return &ArchiveLoader{}
+	}
+
+	return nil
+}
+
+func (self *ArchiveLoader) LoaderVersion() string {
+	return `1`
+}
+
+func (self *ArchiveLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	if listing, err := ListArchive(name); err == nil {
+		archive := map[string]interface{}{
+			`format`:    listing.Format,
+			`encrypted`: listing.Encrypted,
+		}
+
+		if listing.Listable {
+			files, directories, size := listing.Totals()
+
+			archive[`member_count`] = files
+			archive[`directory_count`] = directories
+			archive[`uncompressed_size`] = size
+			archive[`truncated`] = listing.Truncated
+
+			// expressed as uncompressed:compressed (e.g.: 4.0 means the archive is a quarter of its contents' size)
+			if stat, err := os.Stat(name); err == nil && stat.Size() > 0 && size > 0 {
+				archive[`compression_ratio`] = float64(size) / float64(stat.Size())
+			}
+		}
+
+		return map[string]interface{}{
+			`archive`: archive,
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Identify the format of the named archive and list its members.
+func ListArchive(name string) (*ArchiveListing, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	header = header[:n]
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return listZip(name)
+
+	case bytes.HasPrefix(header, []byte("\x1f\x8b")):
+		if gz, err := gzip.NewReader(bufio.NewReader(file)); err == nil {
+			defer gz.Close()
+
+			if listing, err := listTar(gz, `tar.gz`); err == nil {
+				return listing, nil
+			}
+
+			// not a tarball: a single compressed file whose size is stored (mod 2^32) in the trailer
+			return listGzipSingle(file, name)
+		} else {
+			return nil, err
+		}
+
+	case bytes.HasPrefix(header, []byte("BZh")):
+		if listing, err := listTar(bzip2.NewReader(bufio.NewReader(file)), `tar.bz2`); err == nil {
+			return listing, nil
+		}
+
+		return &ArchiveListing{
+			Format: `bz2`,
+		}, nil
+
+	case len(header) >= 262 && string(header[257:262]) == `ustar`:
+		return listTar(file, `tar`)
+
+	case bytes.HasPrefix(header, []byte("7z\xbc\xaf\x27\x1c")):
+		return list7z(file)
+
+	case bytes.HasPrefix(header, []byte("Rar!\x1a\x07")):
+		return &ArchiveListing{
+			Format:    `rar`,
+			Encrypted: rarHeadersEncrypted(header),
+		}, nil
+
+	case bytes.HasPrefix(header, []byte("\xfd7zXZ\x00")):
+		return &ArchiveListing{
+			Format: `xz`,
+		}, nil
+
+	case bytes.HasPrefix(header, []byte("\x28\xb5\x2f\xfd")):
+		return &ArchiveListing{
+			Format: `zstd`,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized archive format")
+}
+
+func listZip(name string) (*ArchiveListing, error) {
+	if archive, err := zip.OpenReader(name); err == nil {
+		defer archive.Close()
+
+		listing := &ArchiveListing{
+			Format:   `zip`,
+			Listable: true,
+		}
+
+		for _, file := range archive.File {
+			if len(listing.Members) >= ArchiveMaxMembers {
+				listing.Truncated = true
+				break
+			}
+
+			member := ArchiveMember{
+				Name:           file.Name,
+				Size:           int64(file.UncompressedSize64),
+				CompressedSize: int64(file.CompressedSize64),
+				ModTime:        file.Modified,
+				Mode:           file.Mode(),
+				IsDir:          strings.HasSuffix(file.Name, `/`),
+				Encrypted:      (file.Flags&0x1 != 0),
+				CRC32:          file.CRC32,
+			}
+
+			if member.Encrypted {
+				listing.Encrypted = true
+			}
+
+			listing.Members = append(listing.Members, member)
+		}
+
+		return listing, nil
+	} else {
+		return nil, err
+	}
+}
+
+func listTar(r io.Reader, format string) (*ArchiveListing, error) {
+	reader := tar.NewReader(r)
+	listing := &ArchiveListing{
+		Format:   format,
+		Listable: true,
+	}
+
+	for {
+		header, err := reader.Next()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// a failure on the first header means this isn't a tarball at all
+			if len(listing.Members) == 0 {
+				return nil, err
+			}
+
+			listing.Truncated = true
+			break
+		}
+
+		if len(listing.Members) >= ArchiveMaxMembers {
+			listing.Truncated = true
+			break
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+			listing.Members = append(listing.Members, ArchiveMember{
+				Name:    header.Name,
+				Size:    header.Size,
+				ModTime: header.ModTime,
+				Mode:    header.FileInfo().Mode(),
+				IsDir:   (header.Typeflag == tar.TypeDir),
+			})
+		}
+	}
+
+	return listing, nil
+}
+
+func listGzipSingle(file *os.File, name string) (*ArchiveListing, error) {
+	listing := &ArchiveListing{
+		Format:   `gz`,
+		Listable: true,
+	}
+
+	if stat, err := file.Stat(); err == nil && stat.Size() >= 18 {
+		trailer := make([]byte, 4)
+
+		if _, err := file.ReadAt(trailer, stat.Size()-4); err == nil {
+			listing.Members = append(listing.Members, ArchiveMember{
+				Name:           strings.TrimSuffix(path.Base(name), `.gz`),
+				Size:           int64(binary.LittleEndian.Uint32(trailer)),
+				CompressedSize: stat.Size(),
+				ModTime:        stat.ModTime(),
+			})
+		}
+	}
+
+	return listing, nil
+}
+
+// Determine whether a RAR archive's headers (and therefore its file list) are encrypted.
+func rarHeadersEncrypted(header []byte) bool {
+	switch {
+	case bytes.HasPrefix(header, []byte("Rar!\x1a\x07\x00")) && len(header) >= 12:
+		// RAR 4.x: the main archive header follows the marker; flag 0x0080 indicates encrypted headers
+		return header[9] == 0x73 && binary.LittleEndian.Uint16(header[10:12])&0x0080 != 0
+
+	case bytes.HasPrefix(header, []byte("Rar!\x1a\x07\x01\x00")):
+		// RAR 5.x: an archive encryption header (type 4) immediately follows the marker
+		data := header[8:]
+
+		if len(data) < 4 {
+			return false
+		}
+
+		data = data[4:] // header CRC32
+
+		if _, n := binary.Uvarint(data); n > 0 {
+			if headerType, m := binary.Uvarint(data[n:]); m > 0 {
+				return headerType == 4
+			}
+		}
+	}
+
+	return false
+}
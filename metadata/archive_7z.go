@@ -0,0 +1,621 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"time"
+	"unicode/utf16"
+)
+
+// The largest 7z header that will be read when listing an archive's members.
+var SevenZipMaxHeaderSize int64 = 16777216
+
+// property IDs used in 7z headers
+const (
+	sevenZipEnd                   = 0x00
+	sevenZipHeader                = 0x01
+	sevenZipArchiveProperties     = 0x02
+	sevenZipAdditionalStreamsInfo = 0x03
+	sevenZipMainStreamsInfo       = 0x04
+	sevenZipFilesInfo             = 0x05
+	sevenZipPackInfo              = 0x06
+	sevenZipUnpackInfo            = 0x07
+	sevenZipSubStreamsInfo        = 0x08
+	sevenZipSize                  = 0x09
+	sevenZipCRC                   = 0x0a
+	sevenZipFolder                = 0x0b
+	sevenZipCodersUnpackSize      = 0x0c
+	sevenZipNumUnpackStream       = 0x0d
+	sevenZipEmptyStream           = 0x0e
+	sevenZipEmptyFile             = 0x0f
+	sevenZipName                  = 0x11
+	sevenZipMTime                 = 0x14
+	sevenZipAttributes            = 0x15
+	sevenZipEncodedHeader         = 0x17
+)
+
+var sevenZipAESCoder = []byte{0x06, 0xf1, 0x07, 0x01}
+
+// the difference between the Windows (1601) and Unix (1970) epochs, in 100ns intervals
+const windowsEpochOffset = 116444736000000000
+
+type sevenZipFolderInfo struct {
+	outStreams int
+	mainStream int
+	unpackSize uint64
+	crcDefined bool
+	encrypted  bool
+	substreams int
+}
+
+type sevenZipStream struct {
+	size      uint64
+	encrypted bool
+}
+
+// List the members of a 7z archive.  Archives whose headers are themselves compressed (the default
+// for 7-Zip) can't be listed without an LZMA decoder, so they are only identified; if the header is
+// encrypted, the listing is marked as such.
+func list7z(file *os.File) (*ArchiveListing, error) {
+	listing := &ArchiveListing{
+		Format: `7z`,
+	}
+
+	stat, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	start := make([]byte, 32)
+
+	if _, err := file.ReadAt(start, 0); err != nil {
+		return listing, nil
+	}
+
+	offset := binary.LittleEndian.Uint64(start[12:20])
+	size := binary.LittleEndian.Uint64(start[20:28])
+
+	if size == 0 {
+		listing.Listable = true
+		return listing, nil
+	}
+
+	// a damaged or truncated archive can still be identified
+	if size > uint64(SevenZipMaxHeaderSize) || offset > uint64(stat.Size()) || 32+offset+size > uint64(stat.Size()) {
+		return listing, nil
+	}
+
+	data := make([]byte, size)
+
+	if _, err := file.ReadAt(data, int64(32+offset)); err != nil {
+		return listing, nil
+	}
+
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(start[28:32]) {
+		return listing, nil
+	}
+
+	reader := &sevenZipReader{
+		data: data,
+	}
+
+	switch reader.number() {
+	case sevenZipHeader:
+		if members := reader.header(); reader.err == nil {
+			for _, member := range members {
+				if len(listing.Members) >= ArchiveMaxMembers {
+					listing.Truncated = true
+					break
+				}
+
+				if member.Encrypted {
+					listing.Encrypted = true
+				}
+
+				listing.Members = append(listing.Members, member)
+			}
+
+			listing.Listable = true
+		}
+
+	case sevenZipEncodedHeader:
+		folders, _ := reader.streamsInfo()
+
+		for _, folder := range folders {
+			if folder.encrypted {
+				listing.Encrypted = true
+			}
+		}
+	}
+
+	return listing, nil
+}
+
+// Reads the variable-length values that make up 7z headers.  The first error encountered is kept
+// and all subsequent reads return zero values.
+type sevenZipReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (self *sevenZipReader) fail() {
+	if self.err == nil {
+		self.err = fmt.Errorf("invalid 7z header at offset %d", self.pos)
+	}
+}
+
+func (self *sevenZipReader) bytes(n uint64) []byte {
+	if self.err != nil {
+		return nil
+	} else if n > uint64(len(self.data)-self.pos) {
+		self.fail()
+		return nil
+	}
+
+	data := self.data[self.pos : self.pos+int(n)]
+	self.pos += int(n)
+
+	return data
+}
+
+func (self *sevenZipReader) byte() byte {
+	if b := self.bytes(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (self *sevenZipReader) uint16() uint16 {
+	if b := self.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+
+	return 0
+}
+
+func (self *sevenZipReader) uint32() uint32 {
+	if b := self.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+func (self *sevenZipReader) uint64() uint64 {
+	if b := self.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+
+	return 0
+}
+
+// Numbers are stored in 1-9 bytes; the number of leading 1 bits in the first byte is the number of
+// bytes that follow it.
+func (self *sevenZipReader) number() uint64 {
+	first := self.byte()
+	mask := byte(0x80)
+	var value uint64
+
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			return value | uint64(first&(mask-1))<<(8*uint(i))
+		}
+
+		value |= uint64(self.byte()) << (8 * uint(i))
+		mask >>= 1
+	}
+
+	return value
+}
+
+// Read a number of items, each of which must take up at least one byte of the remaining header.
+func (self *sevenZipReader) count() int {
+	if n := self.number(); n <= uint64(len(self.data)-self.pos) {
+		return int(n)
+	}
+
+	self.fail()
+	return 0
+}
+
+func (self *sevenZipReader) bits(n int) []bool {
+	bits := make([]bool, 0)
+
+	if data := self.bytes(uint64((n + 7) / 8)); data != nil {
+		for i := 0; i < n; i++ {
+			bits = append(bits, data[i/8]&(0x80>>uint(i%8)) != 0)
+		}
+	}
+
+	return bits
+}
+
+// Read a bit vector that may be replaced by a single flag indicating all bits are set.
+func (self *sevenZipReader) definedBits(n int) []bool {
+	if self.byte() == 0 {
+		return self.bits(n)
+	}
+
+	bits := make([]bool, n)
+
+	for i := range bits {
+		bits[i] = true
+	}
+
+	return bits
+}
+
+func (self *sevenZipReader) skipDigests(n int) {
+	for _, defined := range self.definedBits(n) {
+		if defined {
+			self.uint32()
+		}
+	}
+}
+
+func (self *sevenZipReader) header() []ArchiveMember {
+	var streams []sevenZipStream
+	var members []ArchiveMember
+
+	for self.err == nil {
+		switch self.number() {
+		case sevenZipEnd:
+			return members
+
+		case sevenZipArchiveProperties:
+			for self.err == nil && self.number() != sevenZipEnd {
+				self.bytes(self.number())
+			}
+
+		case sevenZipAdditionalStreamsInfo:
+			self.streamsInfo()
+
+		case sevenZipMainStreamsInfo:
+			_, streams = self.streamsInfo()
+
+		case sevenZipFilesInfo:
+			members = self.filesInfo(streams)
+
+		default:
+			self.fail()
+		}
+	}
+
+	return nil
+}
+
+func (self *sevenZipReader) streamsInfo() ([]*sevenZipFolderInfo, []sevenZipStream) {
+	var folders []*sevenZipFolderInfo
+	var streams []sevenZipStream
+
+	for self.err == nil {
+		switch self.number() {
+		case sevenZipEnd:
+			// without substream information, each folder holds a single stream
+			if streams == nil {
+				for _, folder := range folders {
+					streams = append(streams, sevenZipStream{
+						size:      folder.unpackSize,
+						encrypted: folder.encrypted,
+					})
+				}
+			}
+
+			return folders, streams
+
+		case sevenZipPackInfo:
+			self.packInfo()
+
+		case sevenZipUnpackInfo:
+			folders = self.unpackInfo()
+
+		case sevenZipSubStreamsInfo:
+			streams = self.subStreamsInfo(folders)
+
+		default:
+			self.fail()
+		}
+	}
+
+	return folders, nil
+}
+
+func (self *sevenZipReader) packInfo() {
+	self.number() // position of the packed streams
+	n := self.count()
+
+	for self.err == nil {
+		switch self.number() {
+		case sevenZipEnd:
+			return
+		case sevenZipSize:
+			for i := 0; i < n && self.err == nil; i++ {
+				self.number()
+			}
+		case sevenZipCRC:
+			self.skipDigests(n)
+		default:
+			self.fail()
+		}
+	}
+}
+
+func (self *sevenZipReader) unpackInfo() []*sevenZipFolderInfo {
+	if self.number() != sevenZipFolder {
+		self.fail()
+		return nil
+	}
+
+	folders := make([]*sevenZipFolderInfo, self.count())
+
+	// folder definitions stored elsewhere in the archive aren't supported
+	if self.byte() != 0 {
+		self.fail()
+		return nil
+	}
+
+	for i := range folders {
+		folders[i] = self.folder()
+	}
+
+	if self.number() != sevenZipCodersUnpackSize {
+		self.fail()
+		return nil
+	}
+
+	for _, folder := range folders {
+		for i := 0; i < folder.outStreams && self.err == nil; i++ {
+			if size := self.number(); i == folder.mainStream {
+				folder.unpackSize = size
+			}
+		}
+	}
+
+	for self.err == nil {
+		switch self.number() {
+		case sevenZipEnd:
+			return folders
+		case sevenZipCRC:
+			for i, defined := range self.definedBits(len(folders)) {
+				if defined {
+					self.uint32()
+					folders[i].crcDefined = true
+				}
+			}
+		default:
+			self.fail()
+		}
+	}
+
+	return nil
+}
+
+// A folder is a chain of coders (compression, filters, encryption) whose final, unbound output is the
+// folder's data.
+func (self *sevenZipReader) folder() *sevenZipFolderInfo {
+	folder := &sevenZipFolderInfo{
+		substreams: 1,
+	}
+
+	coders := self.count()
+	totalIn := 0
+
+	for i := 0; i < coders && self.err == nil; i++ {
+		flags := self.byte()
+
+		if bytes.Equal(self.bytes(uint64(flags&0x0f)), sevenZipAESCoder) {
+			folder.encrypted = true
+		}
+
+		in, out := 1, 1
+
+		if flags&0x10 != 0 {
+			in, out = self.count(), self.count()
+		}
+
+		if flags&0x20 != 0 {
+			self.bytes(self.number())
+		}
+
+		totalIn += in
+		folder.outStreams += out
+	}
+
+	bound := make(map[uint64]bool)
+
+	for i := 0; i < folder.outStreams-1 && self.err == nil; i++ {
+		self.number()
+		bound[self.number()] = true
+	}
+
+	if packed := totalIn - (folder.outStreams - 1); packed > 1 {
+		for i := 0; i < packed && self.err == nil; i++ {
+			self.number()
+		}
+	}
+
+	for i := 0; i < folder.outStreams; i++ {
+		if !bound[uint64(i)] {
+			folder.mainStream = i
+			break
+		}
+	}
+
+	return folder
+}
+
+// Folders may hold several files (solid compression); this splits them into per-file streams.
+func (self *sevenZipReader) subStreamsInfo(folders []*sevenZipFolderInfo) []sevenZipStream {
+	streams := make([]sevenZipStream, 0)
+	id := self.number()
+
+	if id == sevenZipNumUnpackStream {
+		for _, folder := range folders {
+			folder.substreams = self.count()
+		}
+
+		id = self.number()
+	}
+
+	hasSizes := (id == sevenZipSize)
+
+	for _, folder := range folders {
+		if folder.substreams == 0 {
+			continue
+		} else if folder.substreams > 1 && !hasSizes {
+			self.fail()
+			return nil
+		}
+
+		var sum uint64
+
+		// the size of the last stream in each folder is whatever remains
+		for i := 1; i < folder.substreams && self.err == nil; i++ {
+			size := self.number()
+			sum += size
+
+			streams = append(streams, sevenZipStream{
+				size:      size,
+				encrypted: folder.encrypted,
+			})
+		}
+
+		if sum > folder.unpackSize {
+			self.fail()
+			return nil
+		}
+
+		streams = append(streams, sevenZipStream{
+			size:      folder.unpackSize - sum,
+			encrypted: folder.encrypted,
+		})
+	}
+
+	if hasSizes {
+		id = self.number()
+	}
+
+	if id == sevenZipCRC {
+		digests := 0
+
+		for _, folder := range folders {
+			if folder.substreams != 1 || !folder.crcDefined {
+				digests += folder.substreams
+			}
+		}
+
+		self.skipDigests(digests)
+		id = self.number()
+	}
+
+	if id != sevenZipEnd {
+		self.fail()
+	}
+
+	return streams
+}
+
+func (self *sevenZipReader) filesInfo(streams []sevenZipStream) []ArchiveMember {
+	members := make([]ArchiveMember, self.count())
+	var emptyStream, emptyFile []bool
+
+	for self.err == nil {
+		kind := self.number()
+
+		if kind == sevenZipEnd {
+			break
+		}
+
+		property := &sevenZipReader{
+			data: self.bytes(self.number()),
+		}
+
+		switch kind {
+		case sevenZipEmptyStream:
+			emptyStream = property.bits(len(members))
+
+		case sevenZipEmptyFile:
+			empty := 0
+
+			for _, v := range emptyStream {
+				if v {
+					empty += 1
+				}
+			}
+
+			emptyFile = property.bits(empty)
+
+		case sevenZipName:
+			// names stored elsewhere in the archive aren't supported
+			if property.byte() == 0 {
+				name := make([]uint16, 0)
+
+				for i := 0; i < len(members) && property.err == nil; {
+					if c := property.uint16(); c != 0 {
+						name = append(name, c)
+					} else if property.err == nil {
+						members[i].Name = string(utf16.Decode(name))
+						name = name[:0]
+						i += 1
+					}
+				}
+			}
+
+		case sevenZipMTime:
+			defined := property.definedBits(len(members))
+
+			if property.byte() == 0 {
+				for i, d := range defined {
+					if d {
+						if ft := property.uint64(); ft > windowsEpochOffset {
+							members[i].ModTime = time.Unix(0, int64(ft-windowsEpochOffset)*100)
+						}
+					}
+				}
+			}
+
+		case sevenZipAttributes:
+			defined := property.definedBits(len(members))
+
+			if property.byte() == 0 {
+				for i, d := range defined {
+					if d && property.uint32()&0x10 != 0 {
+						members[i].IsDir = true
+					}
+				}
+			}
+		}
+
+		if property.err != nil {
+			self.err = property.err
+		}
+	}
+
+	// files with data take their sizes from the streams in order; an empty stream that isn't an
+	// empty file is a directory
+	stream, empty := 0, 0
+
+	for i := range members {
+		if i < len(emptyStream) && emptyStream[i] {
+			if empty >= len(emptyFile) || !emptyFile[empty] {
+				members[i].IsDir = true
+			}
+
+			empty += 1
+		} else if stream < len(streams) {
+			members[i].Size = int64(streams[stream].size)
+			members[i].Encrypted = streams[stream].encrypted
+			stream += 1
+		} else {
+			self.fail()
+		}
+	}
+
+	return members
+}
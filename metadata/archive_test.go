@@ -0,0 +1,177 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+func test7zName(name string) []byte {
+	data := make([]byte, 0)
+
+	for _, c := range utf16.Encode([]rune(name + "\x00")) {
+		data = append(data, byte(c), byte(c>>8))
+	}
+
+	return data
+}
+
+func test7zArchive(packed []byte, header []byte) []byte {
+	start := make([]byte, 20)
+	binary.LittleEndian.PutUint64(start[0:8], uint64(len(packed)))
+	binary.LittleEndian.PutUint64(start[8:16], uint64(len(header)))
+	binary.LittleEndian.PutUint32(start[16:20], crc32.ChecksumIEEE(header))
+
+	return testJoin([]byte("7z\xbc\xaf\x27\x1c\x00\x04"), testUint32LE(crc32.ChecksumIEEE(start)), start, packed, header)
+}
+
+func testListArchive(t *testing.T, data []byte) *ArchiveListing {
+	dir, err := ioutil.TempDir(``, `metabase-archive-`)
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, `test.7z`)
+	require.NoError(t, ioutil.WriteFile(name, data, 0644))
+
+	listing, err := ListArchive(name)
+	require.NoError(t, err)
+
+	return listing
+}
+
+func TestList7z(t *testing.T) {
+	assert := require.New(t)
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	filetime := make([]byte, 8)
+	binary.LittleEndian.PutUint64(filetime, uint64(modTime.UnixNano()/100)+windowsEpochOffset)
+
+	names := testJoin([]byte{0}, test7zName(`a.txt`), test7zName(`b.txt`), test7zName(`dir`), test7zName(`empty`))
+	mtimes := testJoin([]byte{1, 0}, filetime, filetime, filetime, filetime)
+
+	// two files stored (with the copy coder) in a single folder, a directory, and an empty file
+	header := testJoin(
+		[]byte{sevenZipHeader, sevenZipMainStreamsInfo},
+		[]byte{sevenZipPackInfo, 0, 1, sevenZipSize, 11, sevenZipEnd},
+		[]byte{sevenZipUnpackInfo, sevenZipFolder, 1, 0, 1, 0x01, 0x00, sevenZipCodersUnpackSize, 11, sevenZipEnd},
+		[]byte{sevenZipSubStreamsInfo, sevenZipNumUnpackStream, 2, sevenZipSize, 5, sevenZipEnd},
+		[]byte{sevenZipEnd},
+		[]byte{sevenZipFilesInfo, 4},
+		[]byte{sevenZipEmptyStream, 1, 0x30},
+		[]byte{sevenZipEmptyFile, 1, 0x40},
+		[]byte{sevenZipName, byte(len(names))}, names,
+		[]byte{sevenZipMTime, byte(len(mtimes))}, mtimes,
+		[]byte{sevenZipEnd, sevenZipEnd},
+	)
+
+	archive := test7zArchive([]byte("helloworld!"), header)
+	listing := testListArchive(t, archive)
+
+	assert.Equal(`7z`, listing.Format)
+	assert.True(listing.Listable)
+	assert.False(listing.Encrypted)
+	assert.Len(listing.Members, 4)
+
+	for i, expected := range []ArchiveMember{
+		{Name: `a.txt`, Size: 5},
+		{Name: `b.txt`, Size: 6},
+		{Name: `dir`, IsDir: true},
+		{Name: `empty`},
+	} {
+		assert.Equal(expected.Name, listing.Members[i].Name)
+		assert.Equal(expected.Size, listing.Members[i].Size)
+		assert.Equal(expected.IsDir, listing.Members[i].IsDir)
+		assert.True(modTime.Equal(listing.Members[i].ModTime))
+	}
+
+	files, directories, size := listing.Totals()
+	assert.Equal(3, files)
+	assert.Equal(1, directories)
+	assert.Equal(int64(11), size)
+
+	// truncated or corrupted archives are still identified
+	for i := 6; i < len(archive); i++ {
+		listing := testListArchive(t, archive[:i])
+		assert.Equal(`7z`, listing.Format)
+		assert.False(listing.Listable)
+	}
+
+	for i := 1; i < len(header); i++ {
+		assert.NotPanics(func() {
+			listing := testListArchive(t, test7zArchive([]byte("helloworld!"), header[:i]))
+			assert.False(listing.Listable)
+		}, "header truncated at %d", i)
+	}
+
+	// headers compressed with LZMA can't be listed, and AES-encrypted ones can only be flagged as such
+	lzma := testJoin(
+		[]byte{sevenZipEncodedHeader},
+		[]byte{sevenZipPackInfo, 0, 1, sevenZipSize, 16, sevenZipEnd},
+		[]byte{sevenZipUnpackInfo, sevenZipFolder, 1, 0, 1, 0x23, 0x03, 0x01, 0x01, 5, 0x5d, 0, 0, 1, 0, sevenZipCodersUnpackSize, 64, sevenZipEnd},
+		[]byte{sevenZipEnd},
+	)
+
+	listing = testListArchive(t, test7zArchive(make([]byte, 16), lzma))
+	assert.False(listing.Listable)
+	assert.False(listing.Encrypted)
+
+	aes := testJoin(
+		[]byte{sevenZipEncodedHeader},
+		[]byte{sevenZipPackInfo, 0, 1, sevenZipSize, 16, sevenZipEnd},
+		[]byte{sevenZipUnpackInfo, sevenZipFolder, 1, 0, 1, 0x24, 0x06, 0xf1, 0x07, 0x01, 2, 0, 0, sevenZipCodersUnpackSize, 16, sevenZipEnd},
+		[]byte{sevenZipEnd},
+	)
+
+	listing = testListArchive(t, test7zArchive(make([]byte, 16), aes))
+	assert.False(listing.Listable)
+	assert.True(listing.Encrypted)
+
+	// an empty archive has no header at all
+	listing = testListArchive(t, test7zArchive(nil, nil))
+	assert.True(listing.Listable)
+	assert.Empty(listing.Members)
+}
+
+func TestSevenZipNumber(t *testing.T) {
+	assert := require.New(t)
+
+	for expected, data := range map[uint64][]byte{
+		0x00:               {0x00},
+		0x7f:               {0x7f},
+		0x80:               {0x80, 0x80},
+		0x3fff:             {0xbf, 0xff},
+		0x4000:             {0xc0, 0x00, 0x40},
+		0x123456:           {0xd2, 0x56, 0x34},
+		0xffffffffffffffff: {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	} {
+		reader := &sevenZipReader{
+			data: data,
+		}
+
+		assert.Equal(expected, reader.number(), "%x", data)
+		assert.NoError(reader.err)
+		assert.Equal(len(data), reader.pos)
+	}
+
+	reader := &sevenZipReader{
+		data: []byte{0xc0, 0x00},
+	}
+
+	reader.number()
+	assert.Error(reader.err)
+
+	// counts can't exceed the remaining data
+	reader = &sevenZipReader{
+		data: []byte{0x05, 0x00},
+	}
+
+	assert.Equal(0, reader.count())
+	assert.Error(reader.err)
+}
@@ -1,49 +1,143 @@
+//go:build !taglib
+// +build !taglib
+
 package metadata
 
 import (
-	"time"
+	"os"
+	"regexp"
+	"strings"
 
-	"github.com/wtolson/go-taglib"
+	"github.com/dhowden/tag"
 )
 
+var rxNonAlpha = regexp.MustCompile(`[^a-z]`)
+
+// maps normalized tag names to the keys they are stored under in media.musicbrainz
+var musicbrainzTags = map[string]string{
+	`musicbrainztrackid`:        `recording_id`,
+	`musicbrainzrecordingid`:    `recording_id`,
+	`musicbrainzreleasetrackid`: `track_id`,
+	`musicbrainzalbumid`:        `album_id`,
+	`musicbrainzartistid`:       `artist_id`,
+	`musicbrainzalbumartistid`:  `album_artist_id`,
+	`musicbrainzreleasegroupid`: `release_group_id`,
+	`musicbrainzworkid`:         `work_id`,
+}
+
+// AudioLoader reads ID3v1/v2, Vorbis comment, and MP4 tags in pure Go.  Build with the
+// "taglib" tag to use libtag instead.
 type AudioLoader struct {
 	Loader
-	metadata *taglib.File
-	data     map[string]interface{}
 }
 
-func (self AudioLoader) CanHandle(name string) Loader {
-	if f, err := taglib.Read(name); err == nil {
-		return &AudioLoader{
-			metadata: f,
-		}
+func (self *AudioLoader) CanHandle(name string) Loader {
+	if GetGeneralFileType(name) == `audio` {
+		return &AudioLoader{}
 	}
 
 	return nil
 }
 
-func (self AudioLoader) LoadMetadata(name string) (map[string]interface{}, error) {
-	if self.metadata != nil {
-		defer self.metadata.Close()
-
-		self.data = map[string]interface{}{
-			`media`: map[string]interface{}{
-				`artist`:     self.metadata.Artist(),
-				`album`:      self.metadata.Album(),
-				`genre`:      self.metadata.Genre(),
-				`title`:      self.metadata.Title(),
-				`track`:      self.metadata.Track(),
-				`year`:       self.metadata.Year(),
-				`comment`:    self.metadata.Comment(),
-				`duration`:   (self.metadata.Length() / time.Millisecond),
-				`bitrate`:    self.metadata.Bitrate(),
-				`channels`:   self.metadata.Channels(),
-				`samplerate`: self.metadata.Samplerate(),
-			},
+func (self *AudioLoader) LoaderVersion() string {
+	return `tag-1`
+}
+
+func (self *AudioLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	if file, err := os.Open(name); err == nil {
+		defer file.Close()
+
+		media := make(map[string]interface{})
+		var tagErr error
+
+		if m, err := tag.ReadFrom(file); err == nil {
+			track, trackTotal := m.Track()
+			disc, discTotal := m.Disc()
+
+			media[`artist`] = m.Artist()
+			media[`album`] = m.Album()
+			media[`album_artist`] = m.AlbumArtist()
+			media[`composer`] = m.Composer()
+			media[`genre`] = m.Genre()
+			media[`title`] = m.Title()
+			media[`track`] = track
+			media[`track_total`] = trackTotal
+			media[`disc`] = disc
+			media[`disc_total`] = discTotal
+			media[`year`] = m.Year()
+			media[`comment`] = m.Comment()
+			media[`tag_format`] = string(m.Format())
+
+			if picture := m.Picture(); picture != nil && len(picture.Data) > 0 {
+				media[`cover_art`] = true
+				media[`cover_art_type`] = picture.MIMEType
+			}
+
+			if ids := musicbrainzIds(m.Raw()); len(ids) > 0 {
+				media[`musicbrainz`] = ids
+			}
+		} else if err != tag.ErrNoTagsFound {
+			tagErr = err
+		}
+
+		if props, err := ReadAudioProperties(file); err == nil {
+			media[`duration`] = props.Duration.Nanoseconds() / 1e6
+			media[`bitrate`] = props.Bitrate
+			media[`channels`] = props.Channels
+			media[`samplerate`] = props.SampleRate
+		} else if tagErr != nil {
+			return nil, tagErr
+		} else if len(media) == 0 {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			`media`: media,
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+func musicbrainzIds(raw map[string]interface{}) map[string]interface{} {
+	ids := make(map[string]interface{})
+
+	for key, rawValue := range raw {
+		var value string
+
+		switch v := rawValue.(type) {
+		case *tag.Comm:
+			// ID3v2 TXXX frames carry the tag name in the description
+			key = v.Description
+			value = v.Text
+		case *tag.UFID:
+			if v.Provider == `http://musicbrainz.org` {
+				ids[`recording_id`] = string(v.Identifier)
+			}
+
+			continue
+		case string:
+			value = v
+		case []byte:
+			value = string(v)
+		default:
+			continue
+		}
+
+		// normalizes "MusicBrainz Album Id", "MUSICBRAINZ_ALBUMID", and
+		// "----:com.apple.iTunes:MusicBrainz Album Id" to the same name
+		if i := strings.LastIndex(key, `:`); i >= 0 {
+			key = key[i+1:]
 		}
 
-		self.metadata = nil
+		key = rxNonAlpha.ReplaceAllString(strings.ToLower(key), ``)
+
+		if field, ok := musicbrainzTags[key]; ok {
+			if value = strings.TrimSpace(value); value != `` {
+				ids[field] = value
+			}
+		}
 	}
 
-	return self.data, nil
+	return ids
 }
@@ -0,0 +1,514 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+type AudioProperties struct {
+	Duration   time.Duration
+	Bitrate    int // kbps
+	Channels   int
+	SampleRate int
+}
+
+// kbps, indexed by [version][layer][bitrate index], where version 0 is MPEG-1 and 1 is MPEG-2/2.5
+var mpegBitrates = [2][3][16]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	}, {
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+var mpegSampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG-1
+	2: {22050, 24000, 16000}, // MPEG-2
+	0: {11025, 12000, 8000},  // MPEG-2.5
+}
+
+// Read the duration, bitrate, channel count, and sample rate of an MP3, FLAC, Ogg (Vorbis or Opus),
+// MP4/M4A, or WAV stream.  Where the format doesn't store these directly they are estimated.
+func ReadAudioProperties(r io.ReadSeeker) (*AudioProperties, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := skipId3v2(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 12)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var props *AudioProperties
+
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		props, err = readFlacProperties(r)
+	case bytes.HasPrefix(header, []byte("OggS")):
+		props, err = readOggProperties(r, size)
+	case bytes.HasPrefix(header, []byte("RIFF")) && string(header[8:12]) == `WAVE`:
+		props, err = readWavProperties(r)
+	case string(header[4:8]) == `ftyp`:
+		props, err = readMp4Properties(r, size)
+	default:
+		props, err = readMpegProperties(r, start, size)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// estimate the average bitrate from the file size if the format doesn't specify one
+	if props.Bitrate == 0 && props.Duration > 0 {
+		props.Bitrate = int(float64(size-start) * 8 / props.Duration.Seconds() / 1000)
+	}
+
+	return props, nil
+}
+
+// Seek past an ID3v2 tag at the start of the stream (if any), returning the offset of the data following it.
+func skipId3v2(r io.ReadSeeker) (int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 10)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	var offset int64
+
+	if bytes.HasPrefix(header, []byte("ID3")) {
+		// tag size is a 28-bit "syncsafe" integer, excluding the header and optional footer
+		offset = 10 + (int64(header[6]&0x7f) << 21) +
+			(int64(header[7]&0x7f) << 14) +
+			(int64(header[8]&0x7f) << 7) +
+			int64(header[9]&0x7f)
+
+		if header[5]&0x10 != 0 {
+			offset += 10
+		}
+	}
+
+	return r.Seek(offset, io.SeekStart)
+}
+
+func readMpegProperties(r io.ReadSeeker, start int64, size int64) (*AudioProperties, error) {
+	// search the first 64KiB for a valid frame header
+	buf := make([]byte, 65536)
+	n, err := io.ReadFull(r, buf)
+
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+
+		version := (buf[i+1] >> 3) & 0x03
+		layer := (buf[i+1] >> 1) & 0x03
+		bitrateIndex := buf[i+2] >> 4
+		rateIndex := (buf[i+2] >> 2) & 0x03
+		channelMode := buf[i+3] >> 6
+
+		rates, ok := mpegSampleRates[version]
+
+		if !ok || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		vi := 0
+		samplesPerFrame := 1152
+
+		if version != 3 {
+			vi = 1
+		}
+
+		switch layer {
+		case 3: // layer I
+			samplesPerFrame = 384
+		case 1: // layer III
+			if version != 3 {
+				samplesPerFrame = 576
+			}
+		}
+
+		props := &AudioProperties{
+			Bitrate:    mpegBitrates[vi][3-layer][bitrateIndex],
+			SampleRate: rates[rateIndex],
+			Channels:   2,
+		}
+
+		if channelMode == 3 {
+			props.Channels = 1
+		}
+
+		// VBR files carry a Xing/Info or VBRI header in the first frame giving the total frame count
+		sideInfo := 32
+
+		if version == 3 && props.Channels == 1 {
+			sideInfo = 17
+		} else if version != 3 && props.Channels == 1 {
+			sideInfo = 9
+		} else if version != 3 {
+			sideInfo = 17
+		}
+
+		var frames, streamBytes int64
+
+		if x := i + 4 + sideInfo; x+16 <= len(buf) && (string(buf[x:x+4]) == `Xing` || string(buf[x:x+4]) == `Info`) {
+			flags := binary.BigEndian.Uint32(buf[x+4 : x+8])
+			x += 8
+
+			if flags&0x01 != 0 {
+				frames = int64(binary.BigEndian.Uint32(buf[x : x+4]))
+				x += 4
+			}
+
+			if flags&0x02 != 0 && x+4 <= len(buf) {
+				streamBytes = int64(binary.BigEndian.Uint32(buf[x : x+4]))
+			}
+		} else if v := i + 4 + 32; v+18 <= len(buf) && string(buf[v:v+4]) == `VBRI` {
+			streamBytes = int64(binary.BigEndian.Uint32(buf[v+10 : v+14]))
+			frames = int64(binary.BigEndian.Uint32(buf[v+14 : v+18]))
+		}
+
+		if frames > 0 {
+			seconds := float64(frames) * float64(samplesPerFrame) / float64(props.SampleRate)
+			props.Duration = time.Duration(seconds * float64(time.Second))
+
+			if streamBytes > 0 && seconds > 0 {
+				props.Bitrate = int(float64(streamBytes) * 8 / seconds / 1000)
+			} else {
+				props.Bitrate = 0
+			}
+		} else if props.Bitrate > 0 {
+			// constant bitrate: the duration follows from the size of the audio data
+			audioBytes := size - start - int64(i)
+
+			// exclude a trailing ID3v1 tag
+			if audioBytes > 128 {
+				tail := make([]byte, 3)
+
+				if _, err := r.Seek(size-128, io.SeekStart); err == nil {
+					if _, err := io.ReadFull(r, tail); err == nil && string(tail) == `TAG` {
+						audioBytes -= 128
+					}
+				}
+			}
+
+			props.Duration = time.Duration(float64(audioBytes) * 8 / float64(props.Bitrate*1000) * float64(time.Second))
+		}
+
+		return props, nil
+	}
+
+	return nil, fmt.Errorf("no MPEG audio frames found")
+}
+
+func readFlacProperties(r io.ReadSeeker) (*AudioProperties, error) {
+	// "fLaC" + metadata block header; STREAMINFO is always the first block
+	header := make([]byte, 8)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[4]&0x7f != 0 {
+		return nil, fmt.Errorf("missing FLAC STREAMINFO block")
+	}
+
+	info := make([]byte, 34)
+
+	if _, err := io.ReadFull(r, info); err != nil {
+		return nil, err
+	}
+
+	// 20 bits sample rate, 3 bits channels-1, 5 bits bits-per-sample-1, 36 bits total samples
+	packed := binary.BigEndian.Uint64(info[10:18])
+	sampleRate := int(packed >> 44)
+	channels := int((packed>>41)&0x07) + 1
+	totalSamples := packed & 0xfffffffff
+
+	props := &AudioProperties{
+		SampleRate: sampleRate,
+		Channels:   channels,
+	}
+
+	if sampleRate > 0 {
+		props.Duration = time.Duration(float64(totalSamples) / float64(sampleRate) * float64(time.Second))
+	}
+
+	return props, nil
+}
+
+func readOggProperties(r io.ReadSeeker, size int64) (*AudioProperties, error) {
+	// the first page holds the codec identification header
+	page := make([]byte, 27+255+64)
+	n, err := io.ReadFull(r, page)
+
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	page = page[:n]
+
+	// the page header is followed by a segment table whose length is given by its last byte
+	if len(page) < 27 || len(page) < 27+int(page[26]) {
+		return nil, fmt.Errorf("truncated Ogg page")
+	}
+
+	packet := page[27+int(page[26]):]
+	props := &AudioProperties{}
+	var preSkip int64
+	var opus bool
+
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")) && len(packet) >= 16:
+		props.Channels = int(packet[11])
+		props.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+
+		if len(packet) >= 24 {
+			props.Bitrate = int(int32(binary.LittleEndian.Uint32(packet[20:24]))) / 1000
+		}
+
+	case bytes.HasPrefix(packet, []byte("OpusHead")) && len(packet) >= 16:
+		props.Channels = int(packet[9])
+		props.SampleRate = int(binary.LittleEndian.Uint32(packet[12:16]))
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:12]))
+		opus = true
+
+	default:
+		return nil, fmt.Errorf("unsupported Ogg codec")
+	}
+
+	// the granule position of the last page gives the total sample count
+	tailSize := int64(65536)
+
+	if tailSize > size {
+		tailSize = size
+	}
+
+	tail := make([]byte, tailSize)
+
+	if _, err := r.Seek(size-tailSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(r, tail); err != nil {
+		return nil, err
+	}
+
+	if i := bytes.LastIndex(tail, []byte("OggS")); i >= 0 && i+14 <= len(tail) {
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+		rate := int64(props.SampleRate)
+
+		// Opus granule positions are always expressed at 48kHz
+		if opus {
+			rate = 48000
+			granule -= preSkip
+		}
+
+		if granule > 0 && rate > 0 {
+			props.Duration = time.Duration(float64(granule) / float64(rate) * float64(time.Second))
+		}
+	}
+
+	return props, nil
+}
+
+func readWavProperties(r io.ReadSeeker) (*AudioProperties, error) {
+	if _, err := r.Seek(12, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+
+	props := &AudioProperties{}
+	var byteRate int64
+
+	for {
+		chunk := make([]byte, 8)
+
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			break
+		}
+
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch string(chunk[0:4]) {
+		case `fmt `:
+			if length < 16 {
+				return nil, fmt.Errorf("invalid WAV format chunk")
+			}
+
+			format := make([]byte, 16)
+
+			if _, err := io.ReadFull(r, format); err != nil {
+				return nil, err
+			}
+
+			props.Channels = int(binary.LittleEndian.Uint16(format[2:4]))
+			props.SampleRate = int(binary.LittleEndian.Uint32(format[4:8]))
+			byteRate = int64(binary.LittleEndian.Uint32(format[8:12]))
+			props.Bitrate = int(byteRate * 8 / 1000)
+			length -= 16
+
+		case `data`:
+			if byteRate > 0 {
+				props.Duration = time.Duration(float64(length) / float64(byteRate) * float64(time.Second))
+			}
+
+			return props, nil
+		}
+
+		// chunks are padded to an even length
+		if _, err := r.Seek(length+(length%2), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+
+	if props.SampleRate == 0 {
+		return nil, fmt.Errorf("missing WAV format chunk")
+	}
+
+	return props, nil
+}
+
+func readMp4Properties(r io.ReadSeeker, size int64) (*AudioProperties, error) {
+	props := &AudioProperties{}
+	found := false
+
+	// containers that need to be descended into to reach mvhd and the audio sample description
+	containers := map[string]bool{
+		`moov`: true,
+		`trak`: true,
+		`mdia`: true,
+		`minf`: true,
+		`stbl`: true,
+	}
+
+	var walk func(offset int64, end int64, depth int) error
+
+	walk = func(offset int64, end int64, depth int) error {
+		for offset+8 <= end {
+			if _, err := r.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+
+			header := make([]byte, 8)
+
+			if _, err := io.ReadFull(r, header); err != nil {
+				return err
+			}
+
+			length := int64(binary.BigEndian.Uint32(header[0:4]))
+			kind := string(header[4:8])
+			headerLen := int64(8)
+
+			switch length {
+			case 0:
+				length = end - offset
+			case 1:
+				ext := make([]byte, 8)
+
+				if _, err := io.ReadFull(r, ext); err != nil {
+					return err
+				}
+
+				length = int64(binary.BigEndian.Uint64(ext))
+				headerLen = 16
+			}
+
+			if length < headerLen || offset+length > end {
+				return fmt.Errorf("invalid MP4 atom length")
+			}
+
+			switch {
+			case containers[kind]:
+				// the containers we descend into are never nested more than a few levels deep
+				if depth >= 8 {
+					return fmt.Errorf("MP4 atoms nested too deeply")
+				}
+
+				if err := walk(offset+headerLen, offset+length, depth+1); err != nil {
+					return err
+				}
+
+			case kind == `mvhd`:
+				data := make([]byte, 32)
+
+				if _, err := io.ReadFull(r, data); err != nil {
+					return err
+				}
+
+				var timescale, duration uint64
+
+				if data[0] == 1 {
+					timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+					duration = binary.BigEndian.Uint64(data[24:32])
+				} else {
+					timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+					duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+				}
+
+				if timescale > 0 {
+					props.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+					found = true
+				}
+
+			case kind == `stsd` && props.SampleRate == 0:
+				// version/flags, entry count, then the first sample entry
+				data := make([]byte, 8+36)
+
+				if _, err := io.ReadFull(r, data); err == nil {
+					entry := data[8:]
+
+					switch string(entry[4:8]) {
+					case `mp4a`, `alac`, `ac-3`, `ec-3`, `Opus`, `fLaC`:
+						props.Channels = int(binary.BigEndian.Uint16(entry[24:26]))
+						props.SampleRate = int(binary.BigEndian.Uint32(entry[32:36]) >> 16)
+					}
+				}
+			}
+
+			offset += length
+		}
+
+		return nil
+	}
+
+	if err := walk(0, size, 0); err != nil && !found {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("missing MP4 movie header")
+	}
+
+	return props, nil
+}
@@ -0,0 +1,217 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testUint32BE(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func testUint32LE(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func testJoin(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func testMp4Atom(kind string, payload ...[]byte) []byte {
+	data := testJoin(payload...)
+	return testJoin(testUint32BE(uint32(8+len(data))), []byte(kind), data)
+}
+
+func testOggPage(granule uint64, packet []byte) []byte {
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	binary.LittleEndian.PutUint64(header[6:14], granule)
+	header[26] = 1
+
+	return testJoin(header, []byte{byte(len(packet))}, packet)
+}
+
+func readTestAudio(data []byte) (*AudioProperties, error) {
+	return ReadAudioProperties(bytes.NewReader(data))
+}
+
+func TestReadAudioPropertiesMpeg(t *testing.T) {
+	assert := require.New(t)
+
+	// MPEG-1 layer III, 128kbps, 44.1kHz, joint stereo; two seconds of constant bitrate audio
+	cbr := make([]byte, 32000)
+	copy(cbr, "\xFF\xFB\x90\x44")
+
+	props, err := readTestAudio(cbr)
+	assert.NoError(err)
+	assert.Equal(128, props.Bitrate)
+	assert.Equal(44100, props.SampleRate)
+	assert.Equal(2, props.Channels)
+	assert.Equal(2*time.Second, props.Duration)
+
+	// the same audio following an ID3v2 tag and followed by an ID3v1 tag
+	id3 := testJoin([]byte("ID3\x04\x00\x00\x00\x00\x00\x0a"), make([]byte, 10), cbr, []byte("TAG"), make([]byte, 125))
+
+	props, err = readTestAudio(id3)
+	assert.NoError(err)
+	assert.Equal(2*time.Second, props.Duration)
+
+	// mono VBR with a Xing header (following 17 bytes of side info): 100 frames of 1152 samples,
+	// 50000 bytes
+	vbr := make([]byte, 4096)
+	copy(vbr, "\xFF\xFB\x90\xC4")
+	copy(vbr[4+17:], testJoin([]byte("Xing"), testUint32BE(3), testUint32BE(100), testUint32BE(50000)))
+
+	props, err = readTestAudio(vbr)
+	assert.NoError(err)
+	assert.Equal(1, props.Channels)
+	assert.Equal(2612244897*time.Nanosecond, props.Duration.Round(time.Nanosecond))
+	assert.Equal(153, props.Bitrate)
+
+	_, err = readTestAudio(make([]byte, 1024))
+	assert.Error(err)
+
+	// a frame header at the very end of the data
+	props, err = readTestAudio(testJoin(make([]byte, 20), []byte("\xFF\xFB\x90\x44")))
+	assert.NoError(err)
+	assert.Equal(128, props.Bitrate)
+}
+
+func TestReadAudioPropertiesFlac(t *testing.T) {
+	assert := require.New(t)
+
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint64(info[10:18], (44100<<44)|(1<<41)|(15<<36)|441000)
+
+	flac := testJoin([]byte("fLaC\x80\x00\x00\x22"), info)
+
+	props, err := readTestAudio(flac)
+	assert.NoError(err)
+	assert.Equal(44100, props.SampleRate)
+	assert.Equal(2, props.Channels)
+	assert.Equal(10*time.Second, props.Duration)
+
+	for i := 8; i < len(flac); i += 7 {
+		_, err = readTestAudio(flac[:i])
+		assert.Error(err, "truncated at %d", i)
+	}
+
+	// the first metadata block must be STREAMINFO
+	_, err = readTestAudio(testJoin([]byte("fLaC\x84\x00\x00\x22"), info))
+	assert.Error(err)
+}
+
+func TestReadAudioPropertiesOgg(t *testing.T) {
+	assert := require.New(t)
+
+	vorbis := testJoin([]byte("\x01vorbis"), testUint32LE(0), []byte{2}, testUint32LE(44100), testUint32LE(0), testUint32LE(128000), make([]byte, 6))
+	ogg := testJoin(testOggPage(0, vorbis), make([]byte, 100), testOggPage(441000, []byte("audio")))
+
+	props, err := readTestAudio(ogg)
+	assert.NoError(err)
+	assert.Equal(2, props.Channels)
+	assert.Equal(44100, props.SampleRate)
+	assert.Equal(128, props.Bitrate)
+	assert.Equal(10*time.Second, props.Duration)
+
+	opus := testJoin([]byte("OpusHead\x01\x01"), []byte{0x38, 0x01}, testUint32LE(44100), make([]byte, 3))
+	ogg = testJoin(testOggPage(0, opus), testOggPage(5*48000+312, []byte("audio")))
+
+	props, err = readTestAudio(ogg)
+	assert.NoError(err)
+	assert.Equal(1, props.Channels)
+	assert.Equal(5*time.Second, props.Duration)
+
+	// a segment table that runs past the end of the data
+	truncated := make([]byte, 40)
+	copy(truncated, "OggS")
+	truncated[26] = 255
+
+	_, err = readTestAudio(truncated)
+	assert.Error(err)
+
+	_, err = readTestAudio(testOggPage(0, []byte("\x01unknown codec")))
+	assert.Error(err)
+}
+
+func TestReadAudioPropertiesWav(t *testing.T) {
+	assert := require.New(t)
+
+	format := testJoin([]byte{1, 0, 2, 0}, testUint32LE(44100), testUint32LE(176400), []byte{4, 0, 16, 0})
+	wav := testJoin(
+		[]byte("RIFF"), testUint32LE(0), []byte("WAVE"),
+		[]byte("LIST"), testUint32LE(3), []byte("abc\x00"),
+		[]byte("fmt "), testUint32LE(16), format,
+		[]byte("data"), testUint32LE(352800),
+	)
+
+	props, err := readTestAudio(wav)
+	assert.NoError(err)
+	assert.Equal(2, props.Channels)
+	assert.Equal(44100, props.SampleRate)
+	assert.Equal(1411, props.Bitrate)
+	assert.Equal(2*time.Second, props.Duration)
+
+	// a format chunk too short to hold the format description
+	_, err = readTestAudio(testJoin([]byte("RIFF"), testUint32LE(0), []byte("WAVE"), []byte("fmt "), testUint32LE(4), make([]byte, 32)))
+	assert.Error(err)
+
+	_, err = readTestAudio(wav[:30])
+	assert.Error(err)
+}
+
+func TestReadAudioPropertiesMp4(t *testing.T) {
+	assert := require.New(t)
+
+	mvhd := make([]byte, 100)
+	copy(mvhd[12:], testJoin(testUint32BE(1000), testUint32BE(3500)))
+
+	entry := make([]byte, 36)
+	copy(entry, testJoin(testUint32BE(36), []byte("mp4a")))
+	entry[25] = 2
+	copy(entry[32:], testUint32BE(44100<<16))
+
+	mp4 := testJoin(
+		testMp4Atom(`ftyp`, []byte("M4A \x00\x00\x00\x00M4A ")),
+		testMp4Atom(`moov`,
+			testMp4Atom(`mvhd`, mvhd),
+			testMp4Atom(`trak`, testMp4Atom(`mdia`, testMp4Atom(`minf`, testMp4Atom(`stbl`,
+				testMp4Atom(`stsd`, make([]byte, 8), entry),
+			)))),
+		),
+		testMp4Atom(`mdat`, make([]byte, 1000)),
+	)
+
+	props, err := readTestAudio(mp4)
+	assert.NoError(err)
+	assert.Equal(3500*time.Millisecond, props.Duration)
+	assert.Equal(2, props.Channels)
+	assert.Equal(44100, props.SampleRate)
+	assert.NotZero(props.Bitrate)
+
+	// truncated before the movie header
+	_, err = readTestAudio(mp4[:40])
+	assert.Error(err)
+
+	// atoms claiming to extend past their parent
+	_, err = readTestAudio(testJoin(testMp4Atom(`ftyp`, []byte("M4A ")), testUint32BE(1<<30), []byte("moov"), make([]byte, 16)))
+	assert.Error(err)
+
+	// deeply nested containers
+	nested := testMp4Atom(`mvhd`, mvhd)
+
+	for i := 0; i < 32; i++ {
+		nested = testMp4Atom(`moov`, nested)
+	}
+
+	_, err = readTestAudio(testJoin(testMp4Atom(`ftyp`, []byte("M4A ")), nested))
+	assert.Error(err)
+}
@@ -0,0 +1,53 @@
+//go:build taglib
+// +build taglib
+
+package metadata
+
+import (
+	"time"
+
+	"github.com/wtolson/go-taglib"
+)
+
+// AudioLoader backed by libtag; only built with the "taglib" build tag.
+type AudioLoader struct {
+	Loader
+}
+
+// Files are only opened in LoadMetadata, since a cached result (or a timeout) means LoadMetadata may
+// never be called for a loader returned from here.
+func (self *AudioLoader) CanHandle(name string) Loader {
+	if GetGeneralFileType(name) == `audio` {
+		return &AudioLoader{}
+	}
+
+	return nil
+}
+
+func (self *AudioLoader) LoaderVersion() string {
+	return `taglib-1`
+}
+
+func (self *AudioLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	if file, err := taglib.Read(name); err == nil {
+		defer file.Close()
+
+		return map[string]interface{}{
+			`media`: map[string]interface{}{
+				`artist`:     file.Artist(),
+				`album`:      file.Album(),
+				`genre`:      file.Genre(),
+				`title`:      file.Title(),
+				`track`:      file.Track(),
+				`year`:       file.Year(),
+				`comment`:    file.Comment(),
+				`duration`:   (file.Length() / time.Millisecond),
+				`bitrate`:    file.Bitrate(),
+				`channels`:   file.Channels(),
+				`samplerate`: file.Samplerate(),
+			},
+		}, nil
+	} else {
+		return nil, err
+	}
+}
@@ -0,0 +1,348 @@
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"github.com/ghetzel/go-stockutil/typeutil"
+)
+
+const (
+	CommandFormatJSON     = `json`
+	CommandFormatKeyValue = `kv`
+	CommandFormatText     = `text`
+)
+
+var DefaultCommandTimeout = 30 * time.Second
+
+// Command rules are registered as loaders named with this prefix (e.g.: "command:exiftool").
+var CommandLoaderPrefix = `command:`
+
+// Rules describing external commands to run against matching files (see SetCommandRules).
+var CommandRules = make([]CommandRule, 0)
+
+var rxCommandKeyInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// A CommandRule runs an external program against files matching any of its extensions, MIME
+// types (which may contain wildcards, e.g.: "image/*"), or filename glob patterns, and stores
+// the parsed output under Namespace.  The string "{source}" in Command is replaced with the
+// path of the file being loaded.  Command is run directly rather than through a shell, and
+// "{source}" must not be placed inside a shell script (e.g.: `sh -c "tool {source}"`), since
+// filenames would then be interpreted by the shell.
+type CommandRule struct {
+	Name       string   `json:"name"`
+	Extensions []string `json:"extensions,omitempty"`
+	MimeTypes  []string `json:"mime_types,omitempty"`
+	Patterns   []string `json:"patterns,omitempty"`
+	Command    []string `json:"command"`
+	Format     string   `json:"format,omitempty"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Timeout    string   `json:"timeout,omitempty"`
+	timeout    time.Duration
+}
+
+func (self *CommandRule) Validate() error {
+	if self.Name == `` {
+		return fmt.Errorf("Command rule must have a name")
+	}
+
+	if len(self.Command) == 0 || self.Command[0] == `` {
+		return fmt.Errorf("Command rule %q: must specify a command", self.Name)
+	}
+
+	switch self.Format {
+	case ``:
+		self.Format = CommandFormatJSON
+	case CommandFormatJSON, CommandFormatKeyValue, CommandFormatText:
+		break
+	default:
+		return fmt.Errorf("Command rule %q: unsupported format %q", self.Name, self.Format)
+	}
+
+	if self.Namespace == `` {
+		self.Namespace = self.Name
+	}
+
+	if self.Timeout != `` {
+		if timeout, err := time.ParseDuration(self.Timeout); err == nil {
+			self.timeout = timeout
+		} else {
+			return fmt.Errorf("Command rule %q: invalid timeout: %v", self.Name, err)
+		}
+	} else {
+		self.timeout = DefaultCommandTimeout
+	}
+
+	return nil
+}
+
+// Returns whether this rule applies to the named file.
+func (self *CommandRule) Matches(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), `.`))
+
+	for _, e := range self.Extensions {
+		if strings.ToLower(strings.TrimPrefix(e, `.`)) == ext {
+			return true
+		}
+	}
+
+	if len(self.MimeTypes) > 0 {
+		if mediaType := GetMimeType(name); mediaType != `` {
+			for _, pattern := range self.MimeTypes {
+				if ok, err := path.Match(pattern, mediaType); err == nil && ok {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, pattern := range self.Patterns {
+		if ok, err := filepath.Match(pattern, filepath.Base(name)); err == nil && ok {
+			return true
+		} else if ok, err := filepath.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Run the rule's command against the named file and parse its output.
+func (self *CommandRule) Load(name string) (map[string]interface{}, error) {
+	timeout := self.timeout
+
+	if timeout == 0 {
+		timeout = DefaultCommandTimeout
+	}
+
+	if output, err := runCommand(timeout, self.Command[0], self.Command[1:], name); err == nil {
+		var value interface{}
+
+		switch self.Format {
+		case CommandFormatKeyValue:
+			value = parseKeyValueOutput(output)
+
+		case CommandFormatText:
+			value = strings.TrimSpace(string(output))
+
+		default:
+			if data, err := parseJsonOutput(output); err == nil {
+				value = data
+			} else {
+				return nil, err
+			}
+		}
+
+		namespace := self.Namespace
+
+		if namespace == `` {
+			namespace = self.Name
+		}
+
+		return map[string]interface{}{
+			namespace: value,
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// The name of the loader the rule is registered as.
+func (self *CommandRule) LoaderName() string {
+	return CommandLoaderPrefix + self.Name
+}
+
+// Runs a single command rule, so that failures are recorded (and retried) for each rule.
+type CommandLoader struct {
+	Loader
+	rule CommandRule
+}
+
+func NewCommandLoader(rule CommandRule) *CommandLoader {
+	return &CommandLoader{
+		rule: rule,
+	}
+}
+
+func (self *CommandLoader) CanHandle(name string) Loader {
+	if self.rule.Matches(name) {
+		return self
+	}
+
+	return nil
+}
+
+func (self *CommandLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	return self.rule.Load(name)
+}
+
+// Validate the given rules and register a loader for each of them (in pass 2), replacing the loaders
+// of any previously set rules.
+func SetCommandRules(rules []CommandRule) error {
+	rules = append([]CommandRule{}, rules...)
+
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, registration := range ListLoaders() {
+		if strings.HasPrefix(registration.Name, CommandLoaderPrefix) {
+			if err := UnregisterLoader(registration.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	CommandRules = make([]CommandRule, 0)
+
+	for _, rule := range rules {
+		if err := RegisterLoader(rule.LoaderName(), 2, NewCommandLoader(rule)); err == nil {
+			CommandRules = append(CommandRules, rule)
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Execute a command, replacing "{source}" in its arguments with the given filename, and return
+// its standard output.  A timeout of zero means the command may run indefinitely.
+func runCommand(timeout time.Duration, command string, arguments []string, source string) ([]byte, error) {
+	args := make([]string, len(arguments))
+
+	for i, arg := range arguments {
+		args[i] = strings.Replace(arg, `{source}`, source, -1)
+	}
+
+	ctx := context.Background()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), `AV_LOG_FORCE_NOCOLOR=1`)
+
+	output, err := cmd.Output()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %v", command, timeout)
+	}
+
+	return output, err
+}
+
+// Parse JSON output into a map, normalizing keys the same way as key=value output, autotyping
+// values, and dropping empty ones.  A top-level array containing a single object (as emitted by
+// exiftool -json) is unwrapped.
+func parseJsonOutput(output []byte) (map[string]interface{}, error) {
+	var data interface{}
+
+	if err := json.Unmarshal(output, &data); err != nil {
+		return nil, err
+	}
+
+	if items, ok := data.([]interface{}); ok && len(items) == 1 {
+		data = items[0]
+	}
+
+	if input, ok := normalizeCommandKeys(data).(map[string]interface{}); ok {
+		return autotypeMap(input, nil)
+	} else {
+		return nil, fmt.Errorf("expected a JSON object, got %T", data)
+	}
+}
+
+// Parse lines of "key=value" or "key: value" into a map, normalizing the keys.
+func parseKeyValueOutput(output []byte) map[string]interface{} {
+	rv := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.IndexAny(line, `=:`)
+
+		if i <= 0 {
+			continue
+		}
+
+		key := normalizeCommandKey(line[:i])
+
+		if value := strings.TrimSpace(line[i+1:]); key != `` && value != `` {
+			rv[key] = stringutil.Autotype(value)
+		}
+	}
+
+	return rv
+}
+
+func normalizeCommandKey(key string) string {
+	return strings.Trim(rxCommandKeyInvalid.ReplaceAllString(strings.ToLower(key), `_`), `_`)
+}
+
+// Recursively normalize the keys of all objects in the given JSON value.
+func normalizeCommandKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		rv := make(map[string]interface{})
+
+		for key, item := range v {
+			if key = normalizeCommandKey(key); key != `` {
+				rv[key] = normalizeCommandKeys(item)
+			}
+		}
+
+		return rv
+	case []interface{}:
+		rv := make([]interface{}, len(v))
+
+		for i, item := range v {
+			rv[i] = normalizeCommandKeys(item)
+		}
+
+		return rv
+	default:
+		return value
+	}
+}
+
+// Recursively autotype all leaf values of the given map, dropping empty values and any
+// dot-separated paths listed in omit.
+func autotypeMap(input map[string]interface{}, omit []string) (map[string]interface{}, error) {
+	rv := make(map[string]interface{})
+
+	if err := maputil.Walk(input, func(value interface{}, path []string, isLeaf bool) error {
+		if isLeaf {
+			if !sliceutil.Contains(omit, strings.Join(path, `.`)) {
+				if !typeutil.IsEmpty(value) {
+					maputil.DeepSet(rv, path, stringutil.Autotype(value))
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommandOutput(t *testing.T) {
+	assert := require.New(t)
+
+	data, err := parseJsonOutput([]byte(`[{
+		"SourceFile": "/tmp/a.jpg",
+		"File:ImageWidth": "640",
+		"Empty": "",
+		"Nested": {"Camera Make": "Canon"}
+	}]`))
+
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`sourcefile`:      `/tmp/a.jpg`,
+		`file_imagewidth`: 640,
+		`nested`: map[string]interface{}{
+			`camera_make`: `Canon`,
+		},
+	}, data)
+
+	_, err = parseJsonOutput([]byte(`[1, 2]`))
+	assert.Error(err)
+
+	_, err = parseJsonOutput([]byte(`not json`))
+	assert.Error(err)
+
+	assert.Equal(map[string]interface{}{
+		`image_width`: 640,
+		`camera_make`: `Canon`,
+	}, parseKeyValueOutput([]byte("Image Width=640\nCamera-Make: Canon\nno separator\n: no key\nempty=\n")))
+}
+
+func TestRunCommand(t *testing.T) {
+	assert := require.New(t)
+
+	if _, err := os.Stat(`/usr/bin/env`); err != nil {
+		t.Skip("env is not available")
+	}
+
+	// commands inherit the environment (e.g.: PATH) in addition to the variables we set
+	output, err := runCommand(0, `/usr/bin/env`, nil, ``)
+	assert.NoError(err)
+	assert.Contains(string(output), "AV_LOG_FORCE_NOCOLOR=1\n")
+
+	if path := os.Getenv(`PATH`); path != `` {
+		assert.Contains(strings.Split(string(output), "\n"), `PATH=`+path)
+	}
+
+	rule := CommandRule{
+		Name:    `test`,
+		Command: []string{`printf`, `Source Name=%s\n`, `{source}`},
+		Format:  CommandFormatKeyValue,
+	}
+
+	assert.NoError(rule.Validate())
+
+	// filenames are passed as arguments as-is, never interpreted by a shell
+	data, err := rule.Load(`/tmp/a file; $(touch x) 'b'.txt`)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`test`: map[string]interface{}{
+			`source_name`: `/tmp/a file; $(touch x) 'b'.txt`,
+		},
+	}, data)
+
+	_, err = runCommand(50*time.Millisecond, `sleep`, []string{`5`}, ``)
+	assert.Error(err)
+	assert.Contains(err.Error(), `timed out`)
+}
+
+func TestSetCommandRules(t *testing.T) {
+	assert := require.New(t)
+
+	defer SetCommandRules(nil)
+
+	assert.Error(SetCommandRules([]CommandRule{{Name: `bad`}}))
+
+	assert.NoError(SetCommandRules([]CommandRule{
+		{Name: `ok`, Extensions: []string{`txt`}, Command: []string{`printf`, `name=%s`, `{source}`}, Format: CommandFormatKeyValue},
+		{Name: `fail`, Extensions: []string{`txt`}, Command: []string{`false`}},
+		{Name: `other`, Extensions: []string{`jpg`}, Command: []string{`true`}},
+	}))
+
+	// each rule is a loader of its own, so a failing rule doesn't hide the output of the others
+	loaders := GetLoadersForFile(`/tmp/file.txt`, 2)
+	names := testLoaderNames(loaders)
+	assert.Contains(names, `command:ok`)
+	assert.Contains(names, `command:fail`)
+	assert.NotContains(names, `command:other`)
+
+	for _, loader := range loaders {
+		switch LoaderName(loader) {
+		case `command:ok`:
+			data, err := RunLoader(loader, `/tmp/file.txt`, nil)
+			assert.NoError(err)
+			assert.Equal(map[string]interface{}{
+				`ok`: map[string]interface{}{
+					`name`: `/tmp/file.txt`,
+				},
+			}, data)
+
+		case `command:fail`:
+			_, err := RunLoader(loader, `/tmp/file.txt`, nil)
+			assert.Error(err)
+			assert.Equal(`command:fail`, err.(*LoaderError).Loader)
+		}
+	}
+
+	// replacing the rules removes the loaders of the old ones
+	assert.NoError(SetCommandRules([]CommandRule{
+		{Name: `other`, Extensions: []string{`txt`}, Command: []string{`true`}},
+	}))
+
+	assert.Equal(`other`, CommandRules[0].Namespace)
+
+	names = testLoaderNames(GetLoadersForFile(`/tmp/file.txt`, 2))
+	assert.Contains(names, `command:other`)
+	assert.NotContains(names, `command:ok`)
+	assert.NotContains(names, `command:fail`)
+}
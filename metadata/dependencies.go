@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Loaders that read the output of other loaders implement this interface.  Requires returns the
+// metadata keys (or key prefixes) the loader needs, and LoadMetadataWith is called instead of
+// LoadMetadata with the metadata loaded so far (which must not be modified).
+type DependentLoader interface {
+	Loader
+	Requires() []string
+	LoadMetadataWith(name string, existing map[string]interface{}) (map[string]interface{}, error)
+}
+
+// Reports whether two metadata keys refer to overlapping data (e.g.: "media" and "media.title").
+func keysOverlap(a string, b string) bool {
+	return a == b || strings.HasPrefix(a, b+`.`) || strings.HasPrefix(b, a+`.`)
+}
+
+// Reports whether the first loader requires any of the keys the second one provides.
+func loaderDependsOn(a *LoaderRegistration, b *LoaderRegistration) bool {
+	if a == b {
+		return false
+	}
+
+	for _, required := range a.Requires {
+		for _, provided := range b.Provides {
+			if keysOverlap(required, provided) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Work out the effective pass and level of each loader.  A loader runs no earlier than the latest
+// pass of the loaders it depends on, and within a pass its level is one more than the highest level
+// of its dependencies in that pass.  Loaders sharing a pass and level can run concurrently.
+func resolveLoaderOrder(registrations []*LoaderRegistration) ([]int, []int, error) {
+	passes := make([]int, len(registrations))
+	levels := make([]int, len(registrations))
+	states := make([]int, len(registrations))
+
+	var visit func(i int) error
+
+	visit = func(i int) error {
+		switch states[i] {
+		case 1:
+			return fmt.Errorf("Loader %q has a circular dependency", registrations[i].Name)
+		case 2:
+			return nil
+		}
+
+		states[i] = 1
+		passes[i] = registrations[i].Pass
+		dependencies := make([]int, 0)
+
+		for j := range registrations {
+			if loaderDependsOn(registrations[i], registrations[j]) {
+				if err := visit(j); err != nil {
+					return err
+				}
+
+				if passes[j] > passes[i] {
+					passes[i] = passes[j]
+				}
+
+				dependencies = append(dependencies, j)
+			}
+		}
+
+		for _, j := range dependencies {
+			if passes[j] == passes[i] && levels[j]+1 > levels[i] {
+				levels[i] = levels[j] + 1
+			}
+		}
+
+		states[i] = 2
+		return nil
+	}
+
+	for i := range registrations {
+		if err := visit(i); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return passes, levels, nil
+}
+
+func checkLoaderDependencies(registrations []*LoaderRegistration) error {
+	_, _, err := resolveLoaderOrder(registrations)
+	return err
+}
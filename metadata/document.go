@@ -0,0 +1,413 @@
+package metadata
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The largest XML part that will be read out of a document package.
+var DocumentMaxPartSize int64 = 16777216
+
+var documentDateFormats = []string{
+	time.RFC3339Nano,
+	`2006-01-02T15:04:05.999999999`,
+	`2006-01-02T15:04`,
+	`2006-01-02`,
+	`2006-01`,
+	`2006`,
+}
+
+type ooxmlCoreProperties struct {
+	XMLName        xml.Name `xml:"coreProperties"`
+	Title          string   `xml:"title"`
+	Subject        string   `xml:"subject"`
+	Creator        string   `xml:"creator"`
+	Keywords       string   `xml:"keywords"`
+	Description    string   `xml:"description"`
+	Language       string   `xml:"language"`
+	LastModifiedBy string   `xml:"lastModifiedBy"`
+	Revision       string   `xml:"revision"`
+	Category       string   `xml:"category"`
+	Created        string   `xml:"created"`
+	Modified       string   `xml:"modified"`
+}
+
+type ooxmlAppProperties struct {
+	XMLName     xml.Name `xml:"Properties"`
+	Application string   `xml:"Application"`
+	Company     string   `xml:"Company"`
+	Pages       int      `xml:"Pages"`
+	Words       int      `xml:"Words"`
+	Characters  int      `xml:"Characters"`
+	Slides      int      `xml:"Slides"`
+}
+
+type odfStatistics struct {
+	PageCount      int `xml:"page-count,attr"`
+	WordCount      int `xml:"word-count,attr"`
+	CharacterCount int `xml:"character-count,attr"`
+	TableCount     int `xml:"table-count,attr"`
+}
+
+type odfMeta struct {
+	XMLName xml.Name `xml:"document-meta"`
+	Meta    struct {
+		Title          string        `xml:"title"`
+		Subject        string        `xml:"subject"`
+		Description    string        `xml:"description"`
+		Creator        string        `xml:"creator"`
+		InitialCreator string        `xml:"initial-creator"`
+		Keywords       []string      `xml:"keyword"`
+		Language       string        `xml:"language"`
+		CreationDate   string        `xml:"creation-date"`
+		Date           string        `xml:"date"`
+		Generator      string        `xml:"generator"`
+		Statistics     odfStatistics `xml:"document-statistic"`
+	} `xml:"meta"`
+}
+
+type epubContainer struct {
+	XMLName   xml.Name `xml:"container"`
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubMeta struct {
+	Property string `xml:"property,attr"`
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type epubPackage struct {
+	XMLName  xml.Name `xml:"package"`
+	Version  string   `xml:"version,attr"`
+	Metadata struct {
+		Titles      []string   `xml:"title"`
+		Creators    []string   `xml:"creator"`
+		Subjects    []string   `xml:"subject"`
+		Description string     `xml:"description"`
+		Publisher   string     `xml:"publisher"`
+		Languages   []string   `xml:"language"`
+		Dates       []string   `xml:"date"`
+		Identifiers []string   `xml:"identifier"`
+		Meta        []epubMeta `xml:"meta"`
+	} `xml:"metadata"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+type DocumentLoader struct {
+	Loader
+	format string
+}
+
+func (self *DocumentLoader) CanHandle(name string) Loader {
+	if fileType := GetGeneralFileType(name); fileType == `document` || fileType == `ebook` {
+		if format := documentFormat(name); format != `` {
+			return &DocumentLoader{
+				format: format,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self *DocumentLoader) LoaderVersion() string {
+	return `1`
+}
+
+func (self *DocumentLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	var document map[string]interface{}
+	var err error
+
+	switch self.format {
+	case `pdf`:
+		document, err = loadPdfMetadata(name)
+	case `epub`:
+		document, err = loadEpubMetadata(name)
+	case `odt`, `ods`, `odp`, `odg`:
+		document, err = loadOdfMetadata(name)
+	default:
+		document, err = loadOoxmlMetadata(name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	document[`format`] = self.format
+
+	return map[string]interface{}{
+		`document`: document,
+	}, nil
+}
+
+// Return the short name of the document format, or an empty string if the format is not supported.
+func documentFormat(name string) string {
+	switch mediaType := GetMimeType(name); {
+	case mediaType == `application/pdf`:
+		return `pdf`
+	case mediaType == `application/epub+zip`:
+		return `epub`
+	case strings.HasPrefix(mediaType, `application/vnd.oasis.opendocument.`),
+		strings.HasPrefix(mediaType, `application/vnd.openxmlformats-officedocument.`),
+		strings.HasSuffix(mediaType, `.macroenabled.12`):
+		switch ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), `.`)); ext {
+		case `docx`, `docm`, `dotx`, `xlsx`, `xlsm`, `pptx`, `pptm`, `odt`, `ods`, `odp`, `odg`:
+			return ext
+		default:
+			// fall back to the type implied by the MIME type
+			switch {
+			case strings.HasSuffix(mediaType, `.wordprocessingml.document`):
+				return `docx`
+			case strings.HasSuffix(mediaType, `.spreadsheetml.sheet`):
+				return `xlsx`
+			case strings.HasSuffix(mediaType, `.presentationml.presentation`):
+				return `pptx`
+			case strings.HasSuffix(mediaType, `opendocument.text`):
+				return `odt`
+			case strings.HasSuffix(mediaType, `opendocument.spreadsheet`):
+				return `ods`
+			case strings.HasSuffix(mediaType, `opendocument.presentation`):
+				return `odp`
+			}
+		}
+	}
+
+	return ``
+}
+
+func loadOoxmlMetadata(name string) (map[string]interface{}, error) {
+	if archive, err := zip.OpenReader(name); err == nil {
+		defer archive.Close()
+
+		document := make(map[string]interface{})
+		var core ooxmlCoreProperties
+		var app ooxmlAppProperties
+
+		if err := readZipXml(&archive.Reader, `docProps/core.xml`, &core); err == nil {
+			document[`title`] = strings.TrimSpace(core.Title)
+			document[`subject`] = strings.TrimSpace(core.Subject)
+			document[`author`] = strings.TrimSpace(core.Creator)
+			document[`keywords`] = splitKeywords(core.Keywords)
+			document[`description`] = strings.TrimSpace(core.Description)
+			document[`language`] = strings.TrimSpace(core.Language)
+			document[`category`] = strings.TrimSpace(core.Category)
+			document[`last_modified_by`] = strings.TrimSpace(core.LastModifiedBy)
+			document[`revision`] = strings.TrimSpace(core.Revision)
+			document[`created_at`] = parseDocumentDate(core.Created)
+			document[`modified_at`] = parseDocumentDate(core.Modified)
+		} else if err != errZipPartNotFound {
+			return nil, err
+		}
+
+		if err := readZipXml(&archive.Reader, `docProps/app.xml`, &app); err == nil {
+			document[`creator`] = strings.TrimSpace(app.Application)
+			document[`company`] = strings.TrimSpace(app.Company)
+			document[`page_count`] = app.Pages
+			document[`word_count`] = app.Words
+			document[`character_count`] = app.Characters
+			document[`slide_count`] = app.Slides
+		} else if err != errZipPartNotFound {
+			return nil, err
+		}
+
+		return document, nil
+	} else {
+		return nil, err
+	}
+}
+
+func loadOdfMetadata(name string) (map[string]interface{}, error) {
+	if archive, err := zip.OpenReader(name); err == nil {
+		defer archive.Close()
+
+		document := make(map[string]interface{})
+		var odf odfMeta
+
+		if err := readZipXml(&archive.Reader, `meta.xml`, &odf); err == nil {
+			meta := odf.Meta
+			keywords := make([]string, 0)
+
+			for _, keyword := range meta.Keywords {
+				keywords = append(keywords, splitKeywords(keyword)...)
+			}
+
+			author := meta.InitialCreator
+
+			if author == `` {
+				author = meta.Creator
+			}
+
+			document[`title`] = strings.TrimSpace(meta.Title)
+			document[`subject`] = strings.TrimSpace(meta.Subject)
+			document[`author`] = strings.TrimSpace(author)
+			document[`last_modified_by`] = strings.TrimSpace(meta.Creator)
+			document[`keywords`] = keywords
+			document[`description`] = strings.TrimSpace(meta.Description)
+			document[`language`] = strings.TrimSpace(meta.Language)
+			document[`creator`] = strings.TrimSpace(meta.Generator)
+			document[`created_at`] = parseDocumentDate(meta.CreationDate)
+			document[`modified_at`] = parseDocumentDate(meta.Date)
+			document[`page_count`] = meta.Statistics.PageCount
+			document[`word_count`] = meta.Statistics.WordCount
+			document[`character_count`] = meta.Statistics.CharacterCount
+			document[`table_count`] = meta.Statistics.TableCount
+		} else if err != errZipPartNotFound {
+			return nil, err
+		}
+
+		return document, nil
+	} else {
+		return nil, err
+	}
+}
+
+func loadEpubMetadata(name string) (map[string]interface{}, error) {
+	if archive, err := zip.OpenReader(name); err == nil {
+		defer archive.Close()
+
+		var container epubContainer
+		var pkg epubPackage
+
+		// the container points at the OPF package document describing the publication
+		if err := readZipXml(&archive.Reader, `META-INF/container.xml`, &container); err != nil {
+			return nil, err
+		}
+
+		if len(container.Rootfiles) == 0 {
+			return nil, fmt.Errorf("EPUB container does not specify a package document")
+		}
+
+		if err := readZipXml(&archive.Reader, container.Rootfiles[0].FullPath, &pkg); err != nil {
+			return nil, err
+		}
+
+		meta := pkg.Metadata
+		document := map[string]interface{}{
+			`epub_version`:  pkg.Version,
+			`authors`:       trimAll(meta.Creators),
+			`keywords`:      trimAll(meta.Subjects),
+			`description`:   strings.TrimSpace(meta.Description),
+			`publisher`:     strings.TrimSpace(meta.Publisher),
+			`identifiers`:   trimAll(meta.Identifiers),
+			`chapter_count`: len(pkg.Spine),
+		}
+
+		if len(meta.Titles) > 0 {
+			document[`title`] = strings.TrimSpace(meta.Titles[0])
+		}
+
+		if len(meta.Creators) > 0 {
+			document[`author`] = strings.TrimSpace(meta.Creators[0])
+		}
+
+		if len(meta.Languages) > 0 {
+			document[`language`] = strings.TrimSpace(meta.Languages[0])
+		}
+
+		if len(meta.Dates) > 0 {
+			document[`created_at`] = parseDocumentDate(meta.Dates[0])
+		}
+
+		for _, m := range meta.Meta {
+			// EPUB 3 uses <meta property="...">value</meta>; EPUB 2 uses <meta name="..." content="..."/>
+			switch {
+			case m.Property == `dcterms:modified`:
+				document[`modified_at`] = parseDocumentDate(m.Value)
+			case m.Name == `calibre:series`:
+				document[`series`] = strings.TrimSpace(m.Content)
+			case m.Name == `calibre:series_index`:
+				document[`series_index`] = strings.TrimSpace(m.Content)
+			}
+		}
+
+		return document, nil
+	} else {
+		return nil, err
+	}
+}
+
+var errZipPartNotFound = fmt.Errorf("part not found")
+
+func readZipXml(archive *zip.Reader, name string, into interface{}) error {
+	name = path.Clean(name)
+
+	for _, file := range archive.File {
+		if path.Clean(file.Name) == name {
+			if int64(file.UncompressedSize64) > DocumentMaxPartSize {
+				return fmt.Errorf("%s exceeds the maximum part size", name)
+			}
+
+			if rc, err := file.Open(); err == nil {
+				defer rc.Close()
+
+				if data, err := ioutil.ReadAll(io.LimitReader(rc, DocumentMaxPartSize)); err == nil {
+					return xml.Unmarshal(data, into)
+				} else {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+	}
+
+	return errZipPartNotFound
+}
+
+// Parse the date formats used by document metadata (W3C-DTF and PDF dates), returning nil if
+// the value cannot be parsed.
+func parseDocumentDate(value string) interface{} {
+	value = strings.TrimSpace(value)
+
+	if value == `` {
+		return nil
+	}
+
+	if strings.HasPrefix(value, `D:`) {
+		if tm, err := parsePdfDate(value); err == nil {
+			return tm
+		}
+
+		return nil
+	}
+
+	for _, layout := range documentDateFormats {
+		if tm, err := time.Parse(layout, value); err == nil {
+			return tm
+		}
+	}
+
+	return nil
+}
+
+func splitKeywords(value string) []string {
+	return trimAll(strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';'
+	}))
+}
+
+func trimAll(values []string) []string {
+	out := make([]string, 0)
+
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != `` {
+			out = append(out, value)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,774 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// PDFs are scanned in their entirety up to this size; beyond it only the head and tail are read.
+var PDFMaxScanSize int64 = 67108864
+
+// Loaders run concurrently, so limit how many PDFs are held in memory at once (each taking up to
+// PDFMaxScanSize bytes).  Changes take effect only before the first PDF is scanned.
+var PDFMaxConcurrentScans = 2
+
+var pdfScanSlots chan struct{}
+var pdfScanSlotsInit sync.Once
+
+var rxPdfObject = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+var rxPdfInfoRef = regexp.MustCompile(`/Info\s+(\d+)\s+\d+\s+R`)
+var rxPdfRootRef = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R`)
+var rxPdfObjStm = regexp.MustCompile(`/Type\s*/ObjStm\b`)
+var rxPdfVersion = regexp.MustCompile(`^%PDF-(\d+\.\d+)`)
+
+type pdfName string
+
+type pdfRef int
+
+type pdfDocument struct {
+	objects map[int][]byte
+}
+
+func loadPdfMetadata(name string) (map[string]interface{}, error) {
+	pdfScanSlotsInit.Do(func() {
+		if PDFMaxConcurrentScans < 1 {
+			PDFMaxConcurrentScans = 1
+		}
+
+		pdfScanSlots = make(chan struct{}, PDFMaxConcurrentScans)
+	})
+
+	pdfScanSlots <- struct{}{}
+	defer func() {
+		<-pdfScanSlots
+	}()
+
+	data, err := readPdf(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := &pdfDocument{
+		objects: make(map[int][]byte),
+	}
+
+	pdf.indexObjects(data)
+
+	document := make(map[string]interface{})
+
+	if match := rxPdfVersion.FindSubmatch(data); match != nil {
+		document[`pdf_version`] = string(match[1])
+	}
+
+	// encrypted documents have their strings encrypted too, so only structural data is usable
+	encrypted := bytes.Contains(data, []byte(`/Encrypt`))
+	document[`encrypted`] = encrypted
+
+	if matches := rxPdfRootRef.FindAllSubmatch(data, -1); len(matches) > 0 {
+		ref, _ := strconv.Atoi(string(matches[len(matches)-1][1]))
+
+		if catalog, ok := pdf.resolve(pdfRef(ref)).(map[string]interface{}); ok {
+			if pages, ok := pdf.resolve(catalog[`Pages`]).(map[string]interface{}); ok {
+				if count, ok := pdf.resolve(pages[`Count`]).(float64); ok {
+					document[`page_count`] = int(count)
+				}
+			}
+
+			if lang, ok := pdf.resolve(catalog[`Lang`]).(string); ok && !encrypted {
+				document[`language`] = strings.TrimSpace(lang)
+			}
+
+			if ref, ok := catalog[`Metadata`].(pdfRef); ok && !encrypted {
+				if xmp := pdf.stream(int(ref)); xmp != nil {
+					for k, v := range parseXmp(xmp) {
+						document[k] = v
+					}
+				}
+			}
+		}
+	}
+
+	if matches := rxPdfInfoRef.FindAllSubmatch(data, -1); len(matches) > 0 && !encrypted {
+		ref, _ := strconv.Atoi(string(matches[len(matches)-1][1]))
+
+		// the info dictionary takes precedence over XMP
+		if info, ok := pdf.resolve(pdfRef(ref)).(map[string]interface{}); ok {
+			for key, field := range map[string]string{
+				`Title`:    `title`,
+				`Author`:   `author`,
+				`Subject`:  `subject`,
+				`Creator`:  `creator`,
+				`Producer`: `producer`,
+			} {
+				if v, ok := pdf.resolve(info[key]).(string); ok && strings.TrimSpace(v) != `` {
+					document[field] = strings.TrimSpace(v)
+				}
+			}
+
+			if v, ok := pdf.resolve(info[`Keywords`]).(string); ok {
+				if keywords := splitKeywords(v); len(keywords) > 0 {
+					document[`keywords`] = keywords
+				}
+			}
+
+			if v, ok := pdf.resolve(info[`CreationDate`]).(string); ok {
+				if tm := parseDocumentDate(v); tm != nil {
+					document[`created_at`] = tm
+				}
+			}
+
+			if v, ok := pdf.resolve(info[`ModDate`]).(string); ok {
+				if tm := parseDocumentDate(v); tm != nil {
+					document[`modified_at`] = tm
+				}
+			}
+		}
+	}
+
+	return document, nil
+}
+
+func readPdf(name string) ([]byte, error) {
+	if file, err := os.Open(name); err == nil {
+		defer file.Close()
+
+		if stat, err := file.Stat(); err == nil {
+			if stat.Size() <= PDFMaxScanSize {
+				return ioutil.ReadAll(file)
+			}
+
+			// the trailer (and any incremental updates) live at the end of the file
+			half := PDFMaxScanSize / 2
+			data := make([]byte, PDFMaxScanSize)
+
+			if _, err := io.ReadFull(file, data[:half]); err != nil {
+				return nil, err
+			}
+
+			if _, err := file.ReadAt(data[half:], stat.Size()-half); err != nil && err != io.EOF {
+				return nil, err
+			}
+
+			return data, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Locate all objects in the file, including those packed into compressed object streams.  Later
+// definitions replace earlier ones, as they would in an incrementally-updated file.
+func (self *pdfDocument) indexObjects(data []byte) {
+	matches := rxPdfObject.FindAllSubmatchIndex(data, -1)
+
+	for _, match := range matches {
+		num, _ := strconv.Atoi(string(data[match[2]:match[3]]))
+		body := data[match[1]:]
+
+		if end := bytes.Index(body, []byte(`endobj`)); end >= 0 {
+			body = body[:end]
+		}
+
+		self.objects[num] = body
+	}
+
+	streams := make([][]byte, 0)
+
+	for _, body := range self.objects {
+		if rxPdfObjStm.Match(pdfDictPrefix(body)) {
+			streams = append(streams, body)
+		}
+	}
+
+	for _, body := range streams {
+		self.indexObjectStream(body)
+	}
+}
+
+func (self *pdfDocument) indexObjectStream(body []byte) {
+	dict, ok := parsePdfValue(body).(map[string]interface{})
+
+	if !ok {
+		return
+	}
+
+	count, _ := dict[`N`].(float64)
+	first, _ := dict[`First`].(float64)
+	data := pdfStreamData(body, dict)
+
+	if data == nil || first < 0 || first > float64(len(data)) {
+		return
+	}
+
+	// the header is a list of (object number, offset) pairs
+	header := strings.Fields(string(data[:int(first)]))
+
+	for i := 0; i+1 < len(header) && i/2 < int(count); i += 2 {
+		num, err1 := strconv.Atoi(header[i])
+		offset, err2 := strconv.Atoi(header[i+1])
+		start := int(first) + offset
+		end := len(data)
+
+		if err1 != nil || err2 != nil || offset < 0 || start > len(data) {
+			continue
+		}
+
+		if i+3 < len(header) {
+			if next, err := strconv.Atoi(header[i+3]); err == nil && next >= offset && int(first)+next <= len(data) {
+				end = int(first) + next
+			}
+		}
+
+		if _, ok := self.objects[num]; !ok && start <= end {
+			self.objects[num] = data[start:end]
+		}
+	}
+}
+
+// Follow indirect references until a direct value is reached.
+func (self *pdfDocument) resolve(value interface{}) interface{} {
+	for i := 0; i < 8; i++ {
+		if ref, ok := value.(pdfRef); ok {
+			if body, ok := self.objects[int(ref)]; ok {
+				value = parsePdfValue(body)
+			} else {
+				return nil
+			}
+		} else {
+			break
+		}
+	}
+
+	return value
+}
+
+// Return the decoded stream data of the given object.
+func (self *pdfDocument) stream(num int) []byte {
+	if body, ok := self.objects[num]; ok {
+		if dict, ok := parsePdfValue(body).(map[string]interface{}); ok {
+			return pdfStreamData(body, dict)
+		}
+	}
+
+	return nil
+}
+
+func pdfDictPrefix(body []byte) []byte {
+	if i := bytes.Index(body, []byte(`stream`)); i >= 0 {
+		return body[:i]
+	}
+
+	return body
+}
+
+func pdfStreamData(body []byte, dict map[string]interface{}) []byte {
+	start := bytes.Index(body, []byte(`stream`))
+
+	if start < 0 {
+		return nil
+	}
+
+	start += len(`stream`)
+
+	// the stream keyword is followed by CRLF or LF
+	if start < len(body) && body[start] == '\r' {
+		start++
+	}
+
+	if start < len(body) && body[start] == '\n' {
+		start++
+	}
+
+	end := bytes.LastIndex(body, []byte(`endstream`))
+
+	if end < start {
+		end = len(body)
+	}
+
+	data := body[start:end]
+
+	switch filter := dict[`Filter`].(type) {
+	case pdfName:
+		if filter != `FlateDecode` {
+			return nil
+		}
+	case []interface{}:
+		if len(filter) != 1 || filter[0] != pdfName(`FlateDecode`) {
+			return nil
+		}
+	case nil:
+		return data
+	default:
+		return nil
+	}
+
+	if reader, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		defer reader.Close()
+
+		// tolerate truncated streams by keeping whatever was decoded
+		decoded, _ := ioutil.ReadAll(io.LimitReader(reader, DocumentMaxPartSize))
+		return decoded
+	}
+
+	return nil
+}
+
+// Parse a single PDF value (dictionary, array, string, name, number, boolean, or reference)
+// from the start of the given data.
+func parsePdfValue(data []byte) interface{} {
+	parser := &pdfParser{
+		data: data,
+	}
+
+	return parser.value()
+}
+
+type pdfParser struct {
+	data []byte
+	pos  int
+}
+
+func (self *pdfParser) skipWhitespace() {
+	for self.pos < len(self.data) {
+		switch c := self.data[self.pos]; c {
+		case ' ', '\t', '\r', '\n', '\f', 0:
+			self.pos++
+		case '%':
+			for self.pos < len(self.data) && self.data[self.pos] != '\n' && self.data[self.pos] != '\r' {
+				self.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (self *pdfParser) value() interface{} {
+	self.skipWhitespace()
+
+	if self.pos >= len(self.data) {
+		return nil
+	}
+
+	switch c := self.data[self.pos]; {
+	case c == '<' && self.pos+1 < len(self.data) && self.data[self.pos+1] == '<':
+		self.pos += 2
+		dict := make(map[string]interface{})
+
+		for {
+			self.skipWhitespace()
+
+			if self.pos >= len(self.data) {
+				return dict
+			}
+
+			if bytes.HasPrefix(self.data[self.pos:], []byte(`>>`)) {
+				self.pos += 2
+				return dict
+			}
+
+			if key, ok := self.value().(pdfName); ok {
+				dict[string(key)] = self.value()
+			} else {
+				// malformed dictionary
+				return dict
+			}
+		}
+
+	case c == '[':
+		self.pos++
+		array := make([]interface{}, 0)
+
+		for {
+			self.skipWhitespace()
+
+			if self.pos >= len(self.data) {
+				return array
+			}
+
+			if self.data[self.pos] == ']' {
+				self.pos++
+				return array
+			}
+
+			start := self.pos
+			array = append(array, self.value())
+
+			if self.pos == start {
+				return array
+			}
+		}
+
+	case c == '(':
+		return self.literalString()
+
+	case c == '<':
+		return self.hexString()
+
+	case c == '/':
+		self.pos++
+		start := self.pos
+
+		for self.pos < len(self.data) && !isPdfDelimiter(self.data[self.pos]) {
+			self.pos++
+		}
+
+		return pdfName(self.data[start:self.pos])
+
+	default:
+		start := self.pos
+
+		for self.pos < len(self.data) && !isPdfDelimiter(self.data[self.pos]) {
+			self.pos++
+		}
+
+		token := string(self.data[start:self.pos])
+
+		switch token {
+		case `true`:
+			return true
+		case `false`:
+			return false
+		case `null`, ``:
+			if self.pos == start && self.pos < len(self.data) {
+				// skip unexpected delimiters
+				self.pos++
+			}
+
+			return nil
+		}
+
+		if number, err := strconv.ParseFloat(token, 64); err == nil {
+			// check for an indirect reference ("12 0 R")
+			saved := self.pos
+			self.skipWhitespace()
+			genStart := self.pos
+
+			for self.pos < len(self.data) && self.data[self.pos] >= '0' && self.data[self.pos] <= '9' {
+				self.pos++
+			}
+
+			if self.pos > genStart {
+				self.skipWhitespace()
+
+				if self.pos < len(self.data) && self.data[self.pos] == 'R' &&
+					(self.pos+1 == len(self.data) || isPdfDelimiter(self.data[self.pos+1])) {
+					self.pos++
+					return pdfRef(int(number))
+				}
+			}
+
+			self.pos = saved
+			return number
+		}
+
+		return token
+	}
+}
+
+func (self *pdfParser) literalString() string {
+	var out []byte
+	depth := 0
+
+	self.pos++
+
+	for self.pos < len(self.data) {
+		c := self.data[self.pos]
+		self.pos++
+
+		switch c {
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			if depth == 0 {
+				return decodePdfText(out)
+			}
+
+			depth--
+			out = append(out, c)
+		case '\\':
+			if self.pos >= len(self.data) {
+				break
+			}
+
+			e := self.data[self.pos]
+			self.pos++
+
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				// line continuation
+				if self.pos < len(self.data) && self.data[self.pos] == '\n' {
+					self.pos++
+				}
+			case '\n':
+				break
+			default:
+				if e >= '0' && e <= '7' {
+					octal := int(e - '0')
+
+					for i := 0; i < 2 && self.pos < len(self.data) && self.data[self.pos] >= '0' && self.data[self.pos] <= '7'; i++ {
+						octal = octal*8 + int(self.data[self.pos]-'0')
+						self.pos++
+					}
+
+					out = append(out, byte(octal))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return decodePdfText(out)
+}
+
+func (self *pdfParser) hexString() string {
+	var out []byte
+	var digits []byte
+
+	self.pos++
+
+	for self.pos < len(self.data) && self.data[self.pos] != '>' {
+		if c := self.data[self.pos]; isHexDigit(c) {
+			digits = append(digits, c)
+		}
+
+		self.pos++
+	}
+
+	self.pos++
+
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	for i := 0; i < len(digits); i += 2 {
+		if v, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8); err == nil {
+			out = append(out, byte(v))
+		}
+	}
+
+	return decodePdfText(out)
+}
+
+func isPdfDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// PDF text strings are either UTF-16BE (with a byte order mark) or PDFDocEncoding, which is
+// treated as Latin-1 here.
+func decodePdfText(data []byte) string {
+	if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+		units := make([]uint16, 0, len(data)/2)
+
+		for i := 2; i+1 < len(data); i += 2 {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		}
+
+		return string(utf16.Decode(units))
+	}
+
+	if len(data) >= 3 && data[0] == 0xef && data[1] == 0xbb && data[2] == 0xbf {
+		return string(data[3:])
+	}
+
+	runes := make([]rune, len(data))
+
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+
+	return string(runes)
+}
+
+// Parse dates in the form "D:YYYYMMDDHHmmSSOHH'mm'", where everything after the year is optional.
+func parsePdfDate(value string) (time.Time, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), `D:`)
+	digits := value
+
+	if i := strings.IndexAny(value, `Zz+-`); i >= 0 {
+		digits = value[:i]
+	}
+
+	if len(digits) < 4 {
+		return time.Time{}, fmt.Errorf("invalid PDF date %q", value)
+	}
+
+	parts := []int{0, 1, 1, 0, 0, 0}
+	widths := []int{4, 2, 2, 2, 2, 2}
+	pos := 0
+
+	for i, width := range widths {
+		if pos+width > len(digits) {
+			break
+		}
+
+		if v, err := strconv.Atoi(digits[pos : pos+width]); err == nil {
+			parts[i] = v
+		} else {
+			return time.Time{}, fmt.Errorf("invalid PDF date %q", value)
+		}
+
+		pos += width
+	}
+
+	location := time.UTC
+
+	if tz := value[len(digits):]; len(tz) >= 2 && (tz[0] == '+' || tz[0] == '-') {
+		var hourS, minuteS string
+
+		// the hour and minute are separated by apostrophes, though some writers omit them (e.g.:
+		// "+0500") and some omit the leading zero of the hour (e.g.: "+0'" or "-5'00'")
+		if fields := strings.Split(tz[1:], `'`); len(fields) > 1 {
+			hourS, minuteS = fields[0], fields[1]
+		} else if len(tz) > 3 {
+			hourS, minuteS = tz[1:3], tz[3:]
+		} else {
+			hourS = tz[1:]
+		}
+
+		hours, _ := strconv.Atoi(hourS)
+		minutes, _ := strconv.Atoi(minuteS)
+
+		if hours < 0 || hours > 23 || minutes < 0 || minutes > 59 {
+			hours, minutes = 0, 0
+		}
+
+		offset := hours*3600 + minutes*60
+
+		if value[len(digits)] == '-' {
+			offset = -offset
+		}
+
+		location = time.FixedZone(``, offset)
+	}
+
+	return time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, location), nil
+}
+
+// Extract Dublin Core and XMP basic properties from an XMP packet.
+func parseXmp(data []byte) map[string]interface{} {
+	document := make(map[string]interface{})
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	values := make(map[string][]string)
+	var stack []string
+	var text strings.Builder
+
+	record := func(name string, value string) {
+		if value = strings.TrimSpace(value); value != `` {
+			values[name] = append(values[name], value)
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+
+		if err != nil {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+
+			// simple properties may be expressed as attributes of rdf:Description
+			if t.Name.Local == `Description` {
+				for _, attr := range t.Attr {
+					record(attr.Name.Local, attr.Value)
+				}
+			}
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+
+			// values are either direct children of rdf:Description or rdf:li items within a container
+			name := t.Name.Local
+
+			if name == `li` && len(stack) >= 2 {
+				name = stack[len(stack)-2]
+			}
+
+			switch name {
+			case `Alt`, `Seq`, `Bag`, `Description`, `RDF`, `xmpmeta`:
+				break
+			default:
+				record(name, text.String())
+			}
+
+			text.Reset()
+		}
+	}
+
+	first := func(name string) string {
+		if v, ok := values[name]; ok && len(v) > 0 {
+			return v[0]
+		}
+
+		return ``
+	}
+
+	document[`title`] = first(`title`)
+	document[`author`] = first(`creator`)
+	document[`subject`] = first(`description`)
+	document[`language`] = first(`language`)
+	document[`creator`] = first(`CreatorTool`)
+	document[`producer`] = first(`Producer`)
+	document[`created_at`] = parseDocumentDate(first(`CreateDate`))
+	document[`modified_at`] = parseDocumentDate(first(`ModifyDate`))
+
+	if keywords := values[`subject`]; len(keywords) > 0 {
+		document[`keywords`] = keywords
+	} else if keywords := splitKeywords(first(`Keywords`)); len(keywords) > 0 {
+		document[`keywords`] = keywords
+	}
+
+	for k, v := range document {
+		if v == nil || v == `` {
+			delete(document, k)
+		}
+	}
+
+	return document
+}
@@ -0,0 +1,187 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testFlate(data string) []byte {
+	var buf bytes.Buffer
+
+	writer := zlib.NewWriter(&buf)
+	writer.Write([]byte(data))
+	writer.Close()
+
+	return buf.Bytes()
+}
+
+func testPdfObjectStream(num int, dict string, data []byte) string {
+	return fmt.Sprintf("%d 0 obj\n<< %s /Length %d >>\nstream\n%s\nendstream\nendobj\n", num, dict, len(data), data)
+}
+
+func TestParsePdfDate(t *testing.T) {
+	assert := require.New(t)
+
+	for input, expected := range map[string]time.Time{
+		`D:2020`:                  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		`D:202003`:                time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+		`D:20200102030405`:        time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		`D:20200102030405Z`:       time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		`D:20200102030405Z00'00'`: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		`D:20200101120000+0'`:     time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		`D:20200101120000+`:       time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		`D:20200101120000+05'30'`: time.Date(2020, 1, 1, 12, 0, 0, 0, time.FixedZone(``, 19800)),
+		`D:20200101120000+0530`:   time.Date(2020, 1, 1, 12, 0, 0, 0, time.FixedZone(``, 19800)),
+		`D:20200101120000-05'00'`: time.Date(2020, 1, 1, 12, 0, 0, 0, time.FixedZone(``, -18000)),
+		`D:20200101120000-5'00'`:  time.Date(2020, 1, 1, 12, 0, 0, 0, time.FixedZone(``, -18000)),
+		`D:20200101120000-08`:     time.Date(2020, 1, 1, 12, 0, 0, 0, time.FixedZone(``, -28800)),
+		`D:20200101120000+99'99'`: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC),
+		` 20200101 `:              time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		tm, err := parsePdfDate(input)
+		assert.NoError(err, input)
+		assert.True(expected.Equal(tm), "%s: expected %v, got %v", input, expected, tm)
+	}
+
+	for _, input := range []string{``, `D:`, `D:20`, `D:+05'00'`, `D:20x0`, `D:2020ab`} {
+		_, err := parsePdfDate(input)
+		assert.Error(err, input)
+	}
+}
+
+func TestParsePdfValue(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(map[string]interface{}{
+		`Type`:   pdfName(`Catalog`),
+		`Pages`:  pdfRef(2),
+		`Kids`:   []interface{}{pdfRef(3), pdfRef(4)},
+		`Count`:  float64(2),
+		`Title`:  "A (nested) title\n",
+		`Hex`:    `Hi`,
+		`Flag`:   true,
+		`Nested`: map[string]interface{}{`Lang`: `en`},
+	}, parsePdfValue([]byte(`<< /Type /Catalog /Pages 2 0 R /Kids [3 0 R 4 0 R] /Count 2
+		/Title (A \(nested\) title\n) /Hex <4869> /Flag true /Nested << /Lang (en) >> >>`)))
+
+	// UTF-16BE text strings
+	assert.Equal(`Ünïcode`, parsePdfValue([]byte("<FEFF00DC006E00EF0063006F00640065>")))
+
+	// truncated and malformed values must not panic
+	for _, input := range []string{``, `<<`, `<< /Key`, `[1 2`, `(unterminated`, `<FEF`, `<< /A << /B [ (`, `)`, `>>`, `/`} {
+		assert.NotPanics(func() {
+			parsePdfValue([]byte(input))
+		}, input)
+	}
+}
+
+func TestPdfObjectStreams(t *testing.T) {
+	assert := require.New(t)
+
+	objects := "<< /Type /Pages /Count 12 >><< /Title (Packed) >>"
+	header := "10 0 11 28 "
+	data := testFlate(header + objects)
+
+	pdf := &pdfDocument{
+		objects: make(map[int][]byte),
+	}
+
+	pdf.indexObjects([]byte(testPdfObjectStream(5, fmt.Sprintf("/Type /ObjStm /N 2 /First %d /Filter /FlateDecode", len(header)), data)))
+
+	pages, ok := pdf.resolve(pdfRef(10)).(map[string]interface{})
+	assert.True(ok)
+	assert.Equal(float64(12), pages[`Count`])
+
+	info, ok := pdf.resolve(pdfRef(11)).(map[string]interface{})
+	assert.True(ok)
+	assert.Equal(`Packed`, info[`Title`])
+
+	// hostile headers: negative first offsets, negative object offsets, offsets past the end of the
+	// data and out of order offsets
+	for _, dict := range []string{
+		`/Type /ObjStm /N 2 /First -5`,
+		`/Type /ObjStm /N 2 /First 1000000`,
+		`/Type /ObjStm /N -1 /First 0`,
+	} {
+		pdf := &pdfDocument{
+			objects: make(map[int][]byte),
+		}
+
+		assert.NotPanics(func() {
+			pdf.indexObjects([]byte(testPdfObjectStream(5, dict, []byte("10 0 11 28 << /Title (x) >>"))))
+		}, dict)
+	}
+
+	for _, header := range []string{`10 -11 11 2`, `10 5 11 -3`, `10 50 11 2`, `10 9999 11 99999`, `10`, `x y z w`} {
+		pdf := &pdfDocument{
+			objects: make(map[int][]byte),
+		}
+
+		assert.NotPanics(func() {
+			pdf.indexObjects([]byte(testPdfObjectStream(5, fmt.Sprintf("/Type /ObjStm /N 2 /First %d", len(header)+1), []byte(header+" << /Title (x) >>"))))
+		}, header)
+	}
+}
+
+func TestLoadPdfMetadata(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-pdf-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	document := "%PDF-1.5\n" +
+		"1 0 obj\n<< /Type /Catalog /Pages 2 0 R /Lang (en-US) >>\nendobj\n" +
+		"2 0 obj\n<< /Type /Pages /Kids [] /Count 3 >>\nendobj\n" +
+		"3 0 obj\n<< /Title (Old Title) >>\nendobj\n" +
+		"3 0 obj\n<< /Title (Test Document) /Author (Someone) /Keywords (one, two; three)" +
+		" /CreationDate (D:20200101120000+0') /ModDate (D:20200102120000-05'00') >>\nendobj\n" +
+		"trailer\n<< /Root 1 0 R /Info 3 0 R >>\n%%EOF\n"
+
+	name := filepath.Join(dir, `test.pdf`)
+	assert.NoError(ioutil.WriteFile(name, []byte(document), 0644))
+
+	data, err := loadPdfMetadata(name)
+	assert.NoError(err)
+	assert.Equal(`1.5`, data[`pdf_version`])
+	assert.Equal(false, data[`encrypted`])
+	assert.Equal(3, data[`page_count`])
+	assert.Equal(`en-US`, data[`language`])
+	assert.Equal(`Test Document`, data[`title`])
+	assert.Equal(`Someone`, data[`author`])
+	assert.Equal([]string{`one`, `two`, `three`}, data[`keywords`])
+	assert.True(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC).Equal(data[`created_at`].(time.Time)))
+	assert.True(time.Date(2020, 1, 2, 17, 0, 0, 0, time.UTC).Equal(data[`modified_at`].(time.Time)))
+
+	// every truncation of the document (and of one claiming to be encrypted) must load without panicking
+	encrypted := bytes.Replace([]byte(document), []byte(`/Root 1 0 R`), []byte(`/Root 1 0 R /Encrypt 4 0 R`), 1)
+
+	for _, source := range [][]byte{[]byte(document), encrypted} {
+		for i := 0; i < len(source); i++ {
+			assert.NoError(ioutil.WriteFile(name, source[:i], 0644))
+
+			assert.NotPanics(func() {
+				loadPdfMetadata(name)
+			}, "truncated at %d", i)
+		}
+	}
+
+	assert.NoError(ioutil.WriteFile(name, encrypted, 0644))
+
+	data, err = loadPdfMetadata(name)
+	assert.NoError(err)
+	assert.Equal(true, data[`encrypted`])
+	assert.Equal(3, data[`page_count`])
+	assert.Nil(data[`title`])
+
+	_, err = loadPdfMetadata(filepath.Join(dir, `missing.pdf`))
+	assert.Error(err)
+}
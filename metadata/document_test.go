@@ -0,0 +1,192 @@
+package metadata
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Write a ZIP file with the given members (in order, uncompressed like the "mimetype" member of
+// ODF and EPUB files) and return its path.
+func testZipDocument(assert *require.Assertions, dir string, name string, members ...string) string {
+	filename := filepath.Join(dir, name)
+	file, err := os.Create(filename)
+	assert.NoError(err)
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	for i := 0; i+1 < len(members); i += 2 {
+		w, err := archive.CreateHeader(&zip.FileHeader{
+			Name:   members[i],
+			Method: zip.Store,
+		})
+
+		assert.NoError(err)
+		_, err = w.Write([]byte(members[i+1]))
+		assert.NoError(err)
+	}
+
+	assert.NoError(archive.Close())
+	return filename
+}
+
+func testLoadDocument(assert *require.Assertions, name string) map[string]interface{} {
+	loader := (&DocumentLoader{}).CanHandle(name)
+	assert.NotNil(loader, name)
+
+	data, err := loader.LoadMetadata(name)
+	assert.NoError(err, name)
+
+	return data[`document`].(map[string]interface{})
+}
+
+func TestDocumentLoaderOoxml(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-document-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	document := testLoadDocument(assert, testZipDocument(assert, dir, `report.docx`,
+		`[Content_Types].xml`, `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`,
+		`docProps/core.xml`, `<?xml version="1.0" encoding="UTF-8"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/">
+	<dc:title> Quarterly Report </dc:title>
+	<dc:creator>Jane Doe</dc:creator>
+	<cp:keywords>finance; q3, report</cp:keywords>
+	<cp:lastModifiedBy>John Roe</cp:lastModifiedBy>
+	<cp:revision>4</cp:revision>
+	<dcterms:created>2019-07-01T09:30:00Z</dcterms:created>
+	<dcterms:modified>2019-07-02T10:00:00Z</dcterms:modified>
+</cp:coreProperties>`,
+		`docProps/app.xml`, `<?xml version="1.0" encoding="UTF-8"?>
+<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties">
+	<Application>Microsoft Office Word</Application>
+	<Pages>12</Pages>
+	<Words>3456</Words>
+	<Characters>19876</Characters>
+</Properties>`,
+	))
+
+	assert.Equal(`docx`, document[`format`])
+	assert.Equal(`Quarterly Report`, document[`title`])
+	assert.Equal(`Jane Doe`, document[`author`])
+	assert.Equal(`John Roe`, document[`last_modified_by`])
+	assert.Equal([]string{`finance`, `q3`, `report`}, document[`keywords`])
+	assert.Equal(`Microsoft Office Word`, document[`creator`])
+	assert.Equal(12, document[`page_count`])
+	assert.Equal(3456, document[`word_count`])
+	assert.Equal(19876, document[`character_count`])
+	assert.Equal(time.Date(2019, 7, 1, 9, 30, 0, 0, time.UTC), document[`created_at`])
+
+	// documents without properties still load
+	document = testLoadDocument(assert, testZipDocument(assert, dir, `empty.docx`,
+		`[Content_Types].xml`, `<Types/>`,
+	))
+
+	assert.Equal(`docx`, document[`format`])
+	assert.NotContains(document, `title`)
+}
+
+func TestDocumentLoaderOdf(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-document-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	document := testLoadDocument(assert, testZipDocument(assert, dir, `letter.odt`,
+		`mimetype`, `application/vnd.oasis.opendocument.text`,
+		`meta.xml`, `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-meta xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:meta="urn:oasis:names:tc:opendocument:xmlns:meta:1.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+	<office:meta>
+		<dc:title>A Letter</dc:title>
+		<meta:initial-creator>Jane Doe</meta:initial-creator>
+		<dc:creator>John Roe</dc:creator>
+		<meta:keyword>letters</meta:keyword>
+		<meta:keyword>personal, 2019</meta:keyword>
+		<meta:generator>LibreOffice/6.0</meta:generator>
+		<meta:creation-date>2019-03-04T05:06:07</meta:creation-date>
+		<meta:document-statistic meta:page-count="2" meta:word-count="345" meta:character-count="2010" meta:table-count="1"/>
+	</office:meta>
+</office:document-meta>`,
+	))
+
+	assert.Equal(`odt`, document[`format`])
+	assert.Equal(`A Letter`, document[`title`])
+	assert.Equal(`Jane Doe`, document[`author`])
+	assert.Equal(`John Roe`, document[`last_modified_by`])
+	assert.Equal([]string{`letters`, `personal`, `2019`}, document[`keywords`])
+	assert.Equal(`LibreOffice/6.0`, document[`creator`])
+	assert.Equal(2, document[`page_count`])
+	assert.Equal(345, document[`word_count`])
+	assert.Equal(1, document[`table_count`])
+	assert.Equal(time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC), document[`created_at`])
+}
+
+func TestDocumentLoaderEpub(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-document-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	document := testLoadDocument(assert, testZipDocument(assert, dir, `book.epub`,
+		`mimetype`, `application/epub+zip`,
+		`META-INF/container.xml`, `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+	<rootfiles>
+		<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+	</rootfiles>
+</container>`,
+		`OEBPS/content.opf`, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+	<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<dc:title>The Book</dc:title>
+		<dc:title>A Subtitle</dc:title>
+		<dc:creator>Jane Doe</dc:creator>
+		<dc:creator>John Roe</dc:creator>
+		<dc:subject>Fiction</dc:subject>
+		<dc:language>en</dc:language>
+		<dc:identifier>urn:isbn:9780000000000</dc:identifier>
+		<dc:date>2018-05-01</dc:date>
+		<meta property="dcterms:modified">2019-01-02T03:04:05Z</meta>
+		<meta name="calibre:series" content="Books"/>
+		<meta name="calibre:series_index" content="2"/>
+	</metadata>
+	<spine>
+		<itemref idref="ch1"/>
+		<itemref idref="ch2"/>
+		<itemref idref="ch3"/>
+	</spine>
+</package>`,
+	))
+
+	assert.Equal(`epub`, document[`format`])
+	assert.Equal(`3.0`, document[`epub_version`])
+	assert.Equal(`The Book`, document[`title`])
+	assert.Equal(`Jane Doe`, document[`author`])
+	assert.Equal([]string{`Jane Doe`, `John Roe`}, document[`authors`])
+	assert.Equal([]string{`Fiction`}, document[`keywords`])
+	assert.Equal(`en`, document[`language`])
+	assert.Equal(3, document[`chapter_count`])
+	assert.Equal(`Books`, document[`series`])
+	assert.Equal(`2`, document[`series_index`])
+	assert.Equal(time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC), document[`created_at`])
+	assert.Equal(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC), document[`modified_at`])
+
+	// the container must point at a package document
+	name := testZipDocument(assert, dir, `broken.epub`,
+		`mimetype`, `application/epub+zip`,
+		`META-INF/container.xml`, `<container><rootfiles/></container>`,
+	)
+
+	_, err = (&DocumentLoader{}).CanHandle(name).LoadMetadata(name)
+	assert.Error(err)
+}
@@ -3,7 +3,9 @@ package metadata
 import (
 	"mime"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 )
@@ -17,35 +19,8 @@ var FileModeFlags = map[string]os.FileMode{
 }
 
 var initMime sync.Once
-
-func GetGeneralFileType(filename string) string {
-	initMime.Do(func() {
-		SetupMimeTypes()
-	})
-
-	if mediaType := mime.TypeByExtension(filepath.Ext(filename)); mediaType != `` {
-		var major, minor string
-
-		if parts := strings.SplitN(mediaType, `/`, 2); len(parts) == 2 {
-			major = parts[0]
-			minor = parts[1]
-		}
-
-		switch major {
-		case `audio`, `video`, `image`:
-			return major
-		}
-
-		switch minor {
-		case `ecmascript`, `html`, `javascript`, `scriptlet`, `vrml`, `x-c++hdr`, `x-c++src`, `x-chdr`, `x-csrc`,
-			`x-dsrc`, `x-java`, `x-moc`, `x-pascal`, `x-perl`, `x-python`, `x-ruby`, `x-sh`, `x-sql`, `x-tcl`,
-			`x-tex-pk`, `x-tex`, `x-vrml`:
-			return `code`
-		}
-	}
-
-	return `file`
-}
+var userNameCache sync.Map
+var groupNameCache sync.Map
 
 type FileLoader struct {
 	Loader
@@ -78,6 +53,40 @@ func (self *FileLoader) LoadMetadata(name string) (map[string]interface{}, error
 			`modified_at`: stat.ModTime(),
 		}
 
+		// ownership, inode, timestamps and allocation details (where the platform provides them)
+		if details := statDetails(name, stat); details != nil {
+			for k, v := range details {
+				metadata[k] = v
+			}
+
+			if uid, ok := details[`uid`].(int64); ok {
+				if username := lookupUserName(uid); username != `` {
+					metadata[`user`] = username
+				}
+			}
+
+			if gid, ok := details[`gid`].(int64); ok {
+				if groupname := lookupGroupName(gid); groupname != `` {
+					metadata[`group`] = groupname
+				}
+			}
+
+			if blocks, ok := details[`blocks`].(int64); ok && !mode.IsDir() {
+				blockSize := int64(4096)
+
+				if bs, ok := details[`block_size`].(int64); ok && bs > 0 {
+					blockSize = bs
+				}
+
+				// st_blocks is always in units of 512 bytes
+				allocated := blocks * 512
+
+				metadata[`allocated_size`] = allocated
+				metadata[`apparent_size`] = stat.Size()
+				metadata[`sparse`] = (stat.Size()-allocated >= blockSize)
+			}
+		}
+
 		if !mode.IsDir() {
 			mimetype := make(map[string]interface{})
 
@@ -94,6 +103,10 @@ func (self *FileLoader) LoadMetadata(name string) (map[string]interface{}, error
 				}
 			}
 
+			if detected, err := DetectMimeType(name); err == nil {
+				mimetype[`detected`] = detected
+			}
+
 			metadata[`mime`] = mimetype
 			metadata[`size`] = stat.Size()
 
@@ -111,3 +124,33 @@ func (self *FileLoader) LoadMetadata(name string) (map[string]interface{}, error
 		return nil, err
 	}
 }
+
+func lookupUserName(uid int64) string {
+	if v, ok := userNameCache.Load(uid); ok {
+		return v.(string)
+	}
+
+	var name string
+
+	if u, err := user.LookupId(strconv.FormatInt(uid, 10)); err == nil {
+		name = u.Username
+	}
+
+	userNameCache.Store(uid, name)
+	return name
+}
+
+func lookupGroupName(gid int64) string {
+	if v, ok := groupNameCache.Load(gid); ok {
+		return v.(string)
+	}
+
+	var name string
+
+	if g, err := user.LookupGroupId(strconv.FormatInt(gid, 10)); err == nil {
+		name = g.Name
+	}
+
+	groupNameCache.Store(gid, name)
+	return name
+}
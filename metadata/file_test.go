@@ -0,0 +1,86 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLoader(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-file-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	dense := filepath.Join(dir, `dense.txt`)
+	sparse := filepath.Join(dir, `sparse.img`)
+
+	assert.NoError(ioutil.WriteFile(dense, make([]byte, 128*1024), 0640))
+	assert.NoError(ioutil.WriteFile(sparse, nil, 0644))
+	assert.NoError(os.Truncate(sparse, 64*1024*1024))
+
+	loader := &FileLoader{}
+	data, err := loader.LoadMetadata(dense)
+	assert.NoError(err)
+
+	file := data[`file`].(map[string]interface{})
+	assert.Equal(`dense.txt`, file[`name`])
+	assert.Equal(`txt`, file[`extension`])
+	assert.Equal(int64(128*1024), file[`size`])
+	assert.Equal(os.FileMode(0640), file[`permissions`].(map[string]interface{})[`mode`])
+
+	if runtime.GOOS != `linux` && runtime.GOOS != `darwin` {
+		return
+	}
+
+	assert.Equal(int64(os.Getuid()), file[`uid`])
+	assert.Equal(int64(os.Getgid()), file[`gid`])
+	assert.NotZero(file[`inode`])
+	assert.Equal(uint64(1), file[`links`])
+
+	if current, err := user.Current(); err == nil {
+		assert.Equal(current.Username, file[`user`])
+	}
+
+	for _, field := range []string{`accessed_at`, `changed_at`} {
+		at, ok := file[field].(time.Time)
+		assert.True(ok, field)
+		assert.False(at.IsZero(), field)
+		assert.False(at.After(time.Now().Add(time.Minute)), field)
+	}
+
+	// not every filesystem records birth times
+	if created, ok := file[`created_at`].(time.Time); ok {
+		assert.False(created.After(time.Now().Add(time.Minute)))
+	}
+
+	assert.True(file[`block_size`].(int64) > 0)
+	assert.True(file[`allocated_size`].(int64) >= int64(128*1024))
+	assert.Equal(int64(128*1024), file[`apparent_size`])
+	assert.Equal(false, file[`sparse`])
+
+	// a file extended with truncate has no blocks allocated for the hole
+	data, err = loader.LoadMetadata(sparse)
+	assert.NoError(err)
+
+	file = data[`file`].(map[string]interface{})
+	assert.Equal(int64(64*1024*1024), file[`apparent_size`])
+	assert.True(file[`allocated_size`].(int64) < file[`apparent_size`].(int64))
+	assert.Equal(true, file[`sparse`])
+
+	// directories have no allocation details
+	data, err = loader.LoadMetadata(dir)
+	assert.NoError(err)
+
+	file = data[`file`].(map[string]interface{})
+	assert.NotContains(file, `sparse`)
+	assert.NotContains(file, `size`)
+	assert.Equal(true, file[`permissions`].(map[string]interface{})[`directory`])
+}
@@ -0,0 +1,214 @@
+package metadata
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Locations of the mime.types files shipped by common operating systems and web servers.
+var SystemMimeTypeFiles = []string{
+	`/etc/mime.types`,
+	`/etc/apache2/mime.types`,
+	`/etc/apache/mime.types`,
+	`/etc/httpd/conf/mime.types`,
+	`/usr/local/etc/mime.types`,
+}
+
+// Classifies files into general types (e.g.: "video", "document").  A rule matches a file if any
+// of its MIME type globs (e.g.: "audio/*") match the file's MIME type, or if the file has one of
+// its extensions.  Rules are tried in order and files matching none are of type "file".
+type GeneralTypeRule struct {
+	Type       string   `json:"type"`
+	MimeTypes  []string `json:"mime_types,omitempty"`
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+var DefaultGeneralTypeRules = []GeneralTypeRule{
+	{
+		Type:      `audio`,
+		MimeTypes: []string{`audio/*`},
+	}, {
+		Type:      `video`,
+		MimeTypes: []string{`video/*`},
+	}, {
+		Type:      `image`,
+		MimeTypes: []string{`image/*`},
+	}, {
+		Type: `ebook`,
+		MimeTypes: []string{
+			`application/epub+zip`, `application/x-mobipocket-ebook`, `application/vnd.amazon.ebook`,
+			`application/x-fictionbook+xml`,
+		},
+		Extensions: []string{`azw`, `azw3`, `epub`, `fb2`, `kfx`, `mobi`},
+	}, {
+		Type:       `font`,
+		MimeTypes:  []string{`font/*`, `application/font-*`, `application/x-font*`, `application/vnd.ms-fontobject`},
+		Extensions: []string{`eot`, `otf`, `pfa`, `pfb`, `ttc`, `ttf`, `woff`, `woff2`},
+	}, {
+		Type: `disk-image`,
+		MimeTypes: []string{
+			`application/x-iso9660-image`, `application/x-cd-image`, `application/x-apple-diskimage`,
+			`application/x-raw-disk-image`, `application/x-virtualbox-*`, `application/x-qemu-disk`,
+		},
+		Extensions: []string{`dmg`, `img`, `iso`, `qcow2`, `vdi`, `vhd`, `vhdx`, `vmdk`},
+	}, {
+		Type: `executable`,
+		MimeTypes: []string{
+			`application/x-executable`, `application/x-msdownload`, `application/x-dosexec`,
+			`application/x-mach-binary`, `application/x-sharedlib`, `application/x-msi`,
+			`application/vnd.microsoft.portable-executable`,
+		},
+		Extensions: []string{`appimage`, `dll`, `dylib`, `exe`, `msi`, `so`},
+	}, {
+		Type: `code`,
+		MimeTypes: []string{
+			`*/ecmascript`, `*/html`, `*/javascript`, `*/scriptlet`, `*/vrml`, `*/x-c++hdr`, `*/x-c++src`,
+			`*/x-chdr`, `*/x-csrc`, `*/x-dsrc`, `*/x-java`, `*/x-moc`, `*/x-pascal`, `*/x-perl`, `*/x-python`,
+			`*/x-ruby`, `*/x-sh`, `*/x-shellscript`, `*/x-sql`, `*/x-tcl`, `*/x-tex-pk`, `*/x-tex`,
+			`*/x-vrml`,
+		},
+	}, {
+		Type: `document`,
+		MimeTypes: []string{
+			`*/pdf`, `*/rtf`, `*/msword`, `*/vnd.ms-excel`, `*/vnd.ms-powerpoint`,
+			`*/vnd.openxmlformats-officedocument.*`, `*/vnd.oasis.opendocument.*`, `*/*.macroenabled.12`,
+		},
+	}, {
+		Type: `archive`,
+		MimeTypes: []string{
+			`*/zip`, `*/x-tar`, `*/x-gtar`, `*/gzip`, `*/x-bzip2`, `*/x-xz`, `*/x-7z-compressed`, `*/rar`,
+			`*/vnd.rar`, `*/x-rar-compressed`, `*/zstd`,
+		},
+	},
+}
+
+// The rules used to classify files, in order of precedence.
+var GeneralTypeRules = DefaultGeneralTypeRules
+
+// Validate the given rules and use them (in order) ahead of the built-in rules, so that they can
+// override how any file is classified.
+func SetGeneralTypeRules(rules []GeneralTypeRule) error {
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	combined := make([]GeneralTypeRule, 0, len(rules)+len(DefaultGeneralTypeRules))
+	combined = append(combined, rules...)
+	combined = append(combined, DefaultGeneralTypeRules...)
+
+	GeneralTypeRules = combined
+	return nil
+}
+
+func (self *GeneralTypeRule) Validate() error {
+	if self.Type == `` {
+		return fmt.Errorf("General type rule must specify a type")
+	}
+
+	for _, pattern := range self.MimeTypes {
+		if _, err := path.Match(pattern, ``); err != nil {
+			return fmt.Errorf("General type rule %q: invalid MIME type pattern %q: %v", self.Type, pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Returns whether this rule applies to a file with the given MIME type and extension.
+func (self *GeneralTypeRule) Matches(mediaType string, ext string) bool {
+	if mediaType != `` {
+		for _, pattern := range self.MimeTypes {
+			if ok, err := path.Match(strings.ToLower(pattern), mediaType); err == nil && ok {
+				return true
+			}
+		}
+	}
+
+	if ext != `` {
+		for _, e := range self.Extensions {
+			if strings.ToLower(strings.TrimPrefix(e, `.`)) == ext {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func GetGeneralFileType(filename string) string {
+	mediaType := strings.ToLower(GetMimeType(filename))
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), `.`))
+
+	for _, rule := range GeneralTypeRules {
+		if rule.Matches(mediaType, ext) {
+			return rule.Type
+		}
+	}
+
+	return `file`
+}
+
+// Apply MIME type configuration on top of the built-in table: first the given mime.types files
+// (in order), then the given extension-to-type mappings.
+func ConfigureMimeTypes(files []string, extensions map[string]string) error {
+	initMime.Do(func() {
+		SetupMimeTypes()
+	})
+
+	for _, filename := range files {
+		if err := LoadMimeTypesFile(filename); err != nil {
+			return err
+		}
+	}
+
+	for ext, mediaType := range extensions {
+		if !strings.HasPrefix(ext, `.`) {
+			ext = `.` + ext
+		}
+
+		if err := mime.AddExtensionType(strings.ToLower(ext), mediaType); err != nil {
+			return fmt.Errorf("Invalid MIME type mapping %s=%q: %v", ext, mediaType, err)
+		}
+	}
+
+	return nil
+}
+
+// Register the extensions listed in a mime.types file, in which each line consists of a MIME type
+// followed by zero or more extensions.
+func LoadMimeTypesFile(filename string) error {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) <= 1 || strings.HasPrefix(fields[0], `#`) {
+			continue
+		}
+
+		for _, ext := range fields[1:] {
+			if strings.HasPrefix(ext, `#`) {
+				break
+			}
+
+			mime.AddExtensionType(`.`+strings.ToLower(ext), fields[0])
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,145 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetGeneralFileType(t *testing.T) {
+	assert := require.New(t)
+
+	for name, expected := range map[string]string{
+		`song.mp3`:       `audio`,
+		`song.FLAC`:      `audio`,
+		`movie.mkv`:      `video`,
+		`recording.ts`:   `video`,
+		`photo.jpg`:      `image`,
+		`drawing.svg`:    `image`,
+		`book.epub`:      `ebook`,
+		`book.mobi`:      `ebook`,
+		`font.ttf`:       `font`,
+		`font.woff2`:     `font`,
+		`disk.iso`:       `disk-image`,
+		`disk.qcow2`:     `disk-image`,
+		`setup.exe`:      `executable`,
+		`main.c`:         `code`,
+		`script.py`:      `code`,
+		`page.html`:      `code`,
+		`report.pdf`:     `document`,
+		`report.docx`:    `document`,
+		`sheet.ods`:      `document`,
+		`macros.xlsm`:    `document`,
+		`archive.zip`:    `archive`,
+		`archive.tar.gz`: `archive`,
+		`archive.7z`:     `archive`,
+		`notes.txt`:      `file`,
+		`unknown.xyzzy`:  `file`,
+		`noextension`:    `file`,
+	} {
+		assert.Equal(expected, GetGeneralFileType(name), name)
+	}
+
+	// rules are tried in order, and extensions match regardless of case or a leading dot
+	rules := GeneralTypeRules
+	defer func() {
+		GeneralTypeRules = rules
+	}()
+
+	GeneralTypeRules = []GeneralTypeRule{
+		{Type: `first`, Extensions: []string{`m4a`}},
+		{Type: `second`, MimeTypes: []string{`audio/*`}, Extensions: []string{`.M4A`}},
+	}
+
+	assert.Equal(`first`, GetGeneralFileType(`song.m4a`))
+	assert.Equal(`first`, GetGeneralFileType(`SONG.M4A`))
+	assert.Equal(`second`, GetGeneralFileType(`song.mp3`))
+	assert.Equal(`file`, GetGeneralFileType(`movie.mkv`))
+}
+
+func TestSetGeneralTypeRules(t *testing.T) {
+	assert := require.New(t)
+
+	rules := GeneralTypeRules
+	defer func() {
+		GeneralTypeRules = rules
+	}()
+
+	// configured rules override the defaults, which still apply to everything else
+	assert.NoError(SetGeneralTypeRules([]GeneralTypeRule{
+		{Type: `subtitle`, Extensions: []string{`srt`, `vtt`}},
+		{Type: `comic`, MimeTypes: []string{`application/vnd.comicbook*`}, Extensions: []string{`cbz`, `cbr`}},
+		{Type: `playlist`, MimeTypes: []string{`audio/x-mpegurl`, `audio/mpegurl`}},
+	}))
+
+	assert.Equal(`subtitle`, GetGeneralFileType(`movie.en.srt`))
+	assert.Equal(`comic`, GetGeneralFileType(`issue.cbz`))
+	assert.Equal(`playlist`, GetGeneralFileType(`list.m3u`))
+	assert.Equal(`audio`, GetGeneralFileType(`song.mp3`))
+	assert.Equal(`video`, GetGeneralFileType(`movie.mkv`))
+	assert.Equal(`archive`, GetGeneralFileType(`archive.zip`))
+	assert.Len(GeneralTypeRules, 3+len(DefaultGeneralTypeRules))
+
+	// setting the rules again replaces those set previously, and the defaults are never modified
+	assert.NoError(SetGeneralTypeRules(nil))
+	assert.Equal(DefaultGeneralTypeRules, GeneralTypeRules)
+	assert.Equal(`audio`, GetGeneralFileType(`list.m3u`))
+	assert.Equal(`audio`, DefaultGeneralTypeRules[0].Type)
+
+	// invalid rules are rejected and leave the current rules in place
+	assert.Error(SetGeneralTypeRules([]GeneralTypeRule{{Extensions: []string{`srt`}}}))
+	assert.Error(SetGeneralTypeRules([]GeneralTypeRule{{Type: `bad`, MimeTypes: []string{`text/[`}}}))
+	assert.Equal(DefaultGeneralTypeRules, GeneralTypeRules)
+}
+
+func TestLoadMimeTypesFile(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-mimetypes-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, `mime.types`)
+
+	assert.NoError(ioutil.WriteFile(name, []byte(
+		"# This file maps Internet media types to unique file extension(s).\n"+
+			"\n"+
+			"application/x-metabase-one\tmbone\n"+
+			"application/x-metabase-two    mbtwoa MBTWOB\tmbtwoc\n"+
+			"application/x-metabase-three  mbthree # mbcomment\n"+
+			"application/x-metabase-none\n"+
+			"#application/x-metabase-disabled mbdisabled\n",
+	), 0644))
+
+	assert.NoError(LoadMimeTypesFile(name))
+
+	for ext, expected := range map[string]string{
+		`.mbone`:      `application/x-metabase-one`,
+		`.mbtwoa`:     `application/x-metabase-two`,
+		`.mbtwob`:     `application/x-metabase-two`,
+		`.MBTWOB`:     `application/x-metabase-two`,
+		`.mbtwoc`:     `application/x-metabase-two`,
+		`.mbthree`:    `application/x-metabase-three`,
+		`.mbcomment`:  ``,
+		`.mbdisabled`: ``,
+	} {
+		assert.Equal(expected, mime.TypeByExtension(ext), ext)
+	}
+
+	assert.Equal(`application/x-metabase-two`, GetMimeType(`file.mbtwoc`))
+	assert.Error(LoadMimeTypesFile(filepath.Join(dir, `missing.types`)))
+
+	// explicit mappings are applied after (and so override) the files
+	assert.NoError(ConfigureMimeTypes([]string{name}, map[string]string{
+		`mbone`:  `application/x-metabase-override`,
+		`.mbsix`: `application/x-metabase-six`,
+	}))
+
+	assert.Equal(`application/x-metabase-override`, GetMimeType(`file.mbone`))
+	assert.Equal(`application/x-metabase-six`, GetMimeType(`file.MBSIX`))
+	assert.Error(ConfigureMimeTypes(nil, map[string]string{`mbbad`: `not a type`}))
+}
@@ -1,17 +1,323 @@
 package metadata
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/rwcarlsen/goexif/exif"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
+// The largest metadata chunk (e.g.: a PNG eXIf chunk) that will be read from an image.
+var MaxImageChunkSize int64 = 16777216
+
+// The image types that have a registered decoder.  Other images (e.g.: SVG, HEIC, PSD or camera raw
+// files) can't be read, so the image loaders leave them alone rather than recording errors.
+var DecodableImageTypes = []string{
+	`image/bmp`,
+	`image/gif`,
+	`image/jpeg`,
+	`image/png`,
+	`image/tiff`,
+	`image/webp`,
+	`image/x-ms-bmp`,
+}
+
+func isDecodableImage(name string) bool {
+	return sliceutil.ContainsString(DecodableImageTypes, GetMimeType(name))
+}
+
 type ImageLoader struct {
 	Loader
 }
 
-func (self ImageLoader) CanHandle(_ string) bool {
-	return false
+func (self *ImageLoader) CanHandle(name string) Loader {
+	if isDecodableImage(name) {
+		return &ImageLoader{}
+	}
+
+	return nil
+}
+
+func (self *ImageLoader) LoaderVersion() string {
+	return `1`
+}
+
+func (self *ImageLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	if file, err := os.Open(name); err == nil {
+		defer file.Close()
+
+		if config, format, err := image.DecodeConfig(file); err == nil {
+			metadata := map[string]interface{}{
+				`image.width`:       config.Width,
+				`image.height`:      config.Height,
+				`image.format`:      format,
+				`image.color_model`: colorModelName(config.ColorModel),
+			}
+
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+
+			if x, err := decodeExif(file, format); err == nil && x != nil {
+				for k, v := range exifMetadata(x) {
+					metadata[`image.`+k] = v
+				}
+			}
+
+			return metadata, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+func decodeExif(r io.ReadSeeker, format string) (*exif.Exif, error) {
+	switch format {
+	case `jpeg`, `tiff`:
+		return exif.Decode(r)
+	case `png`:
+		// PNG stores raw TIFF-formatted EXIF data in an eXIf chunk
+		if data, err := findPngChunk(r, `eXIf`); err == nil && data != nil {
+			return exif.Decode(bytes.NewReader(data))
+		} else {
+			return nil, err
+		}
+	case `webp`:
+		if data, err := findRiffChunk(r, `EXIF`); err == nil && data != nil {
+			return exif.Decode(bytes.NewReader(bytes.TrimPrefix(data, []byte("Exif\x00\x00"))))
+		} else {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+func exifMetadata(x *exif.Exif) map[string]interface{} {
+	metadata := make(map[string]interface{})
+
+	strval := func(field exif.FieldName) string {
+		if tag, err := x.Get(field); err == nil {
+			if v, err := tag.StringVal(); err == nil {
+				return strings.TrimSpace(strings.TrimRight(v, "\x00"))
+			}
+		}
+
+		return ``
+	}
+
+	ratval := func(field exif.FieldName) (float64, bool) {
+		if tag, err := x.Get(field); err == nil {
+			if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+				return float64(num) / float64(den), true
+			}
+		}
+
+		return 0, false
+	}
+
+	metadata[`camera.make`] = strval(exif.Make)
+	metadata[`camera.model`] = strval(exif.Model)
+	metadata[`lens.make`] = strval(exif.LensMake)
+	metadata[`lens.model`] = strval(exif.LensModel)
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if v, err := tag.Int(0); err == nil {
+			metadata[`orientation`] = v
+		}
+	}
+
+	if seconds, ok := ratval(exif.ExposureTime); ok {
+		metadata[`exposure.time`] = seconds
+
+		// express sub-second exposures the way cameras display them (e.g.: "1/250")
+		if seconds > 0 && seconds < 1 {
+			metadata[`exposure.shutter`] = fmt.Sprintf("1/%d", int(math.Round(1/seconds)))
+		}
+	}
+
+	if fnumber, ok := ratval(exif.FNumber); ok {
+		metadata[`exposure.aperture`] = fnumber
+	}
+
+	if focal, ok := ratval(exif.FocalLength); ok {
+		metadata[`exposure.focal_length`] = focal
+	}
+
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if v, err := tag.Int(0); err == nil {
+			metadata[`exposure.iso`] = v
+		}
+	}
+
+	if tm, err := x.DateTime(); err == nil && !tm.IsZero() {
+		metadata[`captured_at`] = tm
+	}
+
+	if lat, lng, err := x.LatLong(); err == nil {
+		metadata[`gps.latitude`] = lat
+		metadata[`gps.longitude`] = lng
+
+		if altitude, ok := ratval(exif.GPSAltitude); ok {
+			// an altitude reference of 1 means below sea level
+			if tag, err := x.Get(exif.GPSAltitudeRef); err == nil {
+				if ref, err := tag.Int(0); err == nil && ref == 1 {
+					altitude = -altitude
+				}
+			}
+
+			metadata[`gps.altitude`] = altitude
+		}
+	}
+
+	return metadata
+}
+
+func colorModelName(model color.Model) string {
+	if _, ok := model.(color.Palette); ok {
+		return `paletted`
+	}
+
+	switch model {
+	case color.RGBAModel:
+		return `rgba`
+	case color.RGBA64Model:
+		return `rgba64`
+	case color.NRGBAModel:
+		return `nrgba`
+	case color.NRGBA64Model:
+		return `nrgba64`
+	case color.AlphaModel:
+		return `alpha`
+	case color.Alpha16Model:
+		return `alpha16`
+	case color.GrayModel:
+		return `gray`
+	case color.Gray16Model:
+		return `gray16`
+	case color.CMYKModel:
+		return `cmyk`
+	case color.YCbCrModel:
+		return `ycbcr`
+	case color.NYCbCrAModel:
+		return `nycbcra`
+	default:
+		return `unknown`
+	}
 }
 
-func (self ImageLoader) LoadMetadata(name string) (map[string]interface{}, error) {
-	return nil, fmt.Errorf("%T: NI", self)
+// Locate the first chunk of the given type in a PNG file and return its data.
+func findPngChunk(r io.ReadSeeker, chunkType string) ([]byte, error) {
+	header := make([]byte, 8)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	for {
+		chunk := make([]byte, 8)
+
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		length := int64(binary.BigEndian.Uint32(chunk[0:4]))
+
+		switch string(chunk[4:8]) {
+		case chunkType:
+			return readImageChunk(r, length)
+		case `IDAT`, `IEND`:
+			// eXIf must appear before the image data
+			return nil, nil
+		}
+
+		// skip the data and CRC
+		if _, err := r.Seek(length+4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Locate the first chunk of the given type in a RIFF (e.g.: WebP) file and return its data.
+func findRiffChunk(r io.ReadSeeker, chunkType string) ([]byte, error) {
+	header := make([]byte, 12)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	for {
+		chunk := make([]byte, 8)
+
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+
+		length := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		if string(chunk[0:4]) == chunkType {
+			return readImageChunk(r, length)
+		}
+
+		// chunks are padded to an even length
+		if _, err := r.Seek(length+(length%2), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Read a chunk of the given length, refusing lengths beyond MaxImageChunkSize or the end of the
+// file so that malformed files cannot force large allocations.
+func readImageChunk(r io.ReadSeeker, length int64) ([]byte, error) {
+	if length > MaxImageChunkSize {
+		return nil, fmt.Errorf("chunk length %d exceeds the maximum of %d bytes", length, MaxImageChunkSize)
+	}
+
+	if offset, err := r.Seek(0, io.SeekCurrent); err == nil {
+		if end, err := r.Seek(0, io.SeekEnd); err == nil {
+			if length > end-offset {
+				return nil, fmt.Errorf("chunk length %d exceeds the %d bytes remaining", length, end-offset)
+			}
+		} else {
+			return nil, err
+		}
+
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
 }
@@ -0,0 +1,266 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testIfdEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Value []byte
+}
+
+// Build a little-endian TIFF structure holding the given IFD0 and EXIF sub-IFD entries.
+func testExifData(ifd0 []testIfdEntry, exifIfd []testIfdEntry) []byte {
+	le := binary.LittleEndian
+	ifdSize := func(entries []testIfdEntry) int {
+		return 2 + (12 * len(entries)) + 4
+	}
+
+	// the EXIF sub-IFD pointer is appended to IFD0
+	ifd0 = append(ifd0, testIfdEntry{0x8769, 4, 1, make([]byte, 4)})
+
+	exifOffset := 8 + ifdSize(ifd0)
+	dataOffset := exifOffset + ifdSize(exifIfd)
+	le.PutUint32(ifd0[len(ifd0)-1].Value, uint32(exifOffset))
+
+	var out bytes.Buffer
+	var extra bytes.Buffer
+
+	out.WriteString("II*\x00")
+	binary.Write(&out, le, uint32(8))
+
+	for _, entries := range [][]testIfdEntry{ifd0, exifIfd} {
+		binary.Write(&out, le, uint16(len(entries)))
+
+		for _, entry := range entries {
+			binary.Write(&out, le, entry.Tag)
+			binary.Write(&out, le, entry.Type)
+			binary.Write(&out, le, entry.Count)
+
+			if len(entry.Value) <= 4 {
+				value := make([]byte, 4)
+				copy(value, entry.Value)
+				out.Write(value)
+			} else {
+				binary.Write(&out, le, uint32(dataOffset+extra.Len()))
+				extra.Write(entry.Value)
+			}
+		}
+
+		binary.Write(&out, le, uint32(0))
+	}
+
+	out.Write(extra.Bytes())
+	return out.Bytes()
+}
+
+func testAscii(value string) testIfdEntry {
+	return testIfdEntry{Type: 2, Count: uint32(len(value) + 1), Value: append([]byte(value), 0)}
+}
+
+func testRational(num uint32, den uint32) testIfdEntry {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint32(value[0:4], num)
+	binary.LittleEndian.PutUint32(value[4:8], den)
+
+	return testIfdEntry{Type: 5, Count: 1, Value: value}
+}
+
+func testShort(value uint16) testIfdEntry {
+	data := make([]byte, 2)
+	binary.LittleEndian.PutUint16(data, value)
+
+	return testIfdEntry{Type: 3, Count: 1, Value: data}
+}
+
+func withTag(tag uint16, entry testIfdEntry) testIfdEntry {
+	entry.Tag = tag
+	return entry
+}
+
+func testPngChunk(chunkType string, data []byte) []byte {
+	var out bytes.Buffer
+
+	binary.Write(&out, binary.BigEndian, uint32(len(data)))
+	out.WriteString(chunkType)
+	out.Write(data)
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+
+	return out.Bytes()
+}
+
+// Insert the given chunk into an encoded PNG, directly after its IHDR chunk.
+func testInsertPngChunk(data []byte, chunk []byte) []byte {
+	ihdrEnd := 8 + 8 + 13 + 4
+
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func TestImageLoader(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-image-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	exifData := testExifData([]testIfdEntry{
+		withTag(0x010F, testAscii(`Canon`)),
+		withTag(0x0110, testAscii(`EOS 5D`)),
+		withTag(0x0112, testShort(6)),
+	}, []testIfdEntry{
+		withTag(0x829A, testRational(1, 250)),
+		withTag(0x829D, testRational(28, 10)),
+		withTag(0x8827, testShort(400)),
+		withTag(0x9003, testAscii(`2019:06:07 08:09:10`)),
+	})
+
+	var encoded bytes.Buffer
+	assert.NoError(png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 3))))
+
+	name := filepath.Join(dir, `test.png`)
+	assert.NoError(ioutil.WriteFile(name, testInsertPngChunk(encoded.Bytes(), testPngChunk(`eXIf`, exifData)), 0644))
+
+	metadata, err := (&ImageLoader{}).LoadMetadata(name)
+	assert.NoError(err)
+	assert.Equal(4, metadata[`image.width`])
+	assert.Equal(3, metadata[`image.height`])
+	assert.Equal(`png`, metadata[`image.format`])
+	assert.Equal(`gray`, metadata[`image.color_model`])
+	assert.Equal(`Canon`, metadata[`image.camera.make`])
+	assert.Equal(`EOS 5D`, metadata[`image.camera.model`])
+	assert.Equal(6, metadata[`image.orientation`])
+	assert.Equal(`1/250`, metadata[`image.exposure.shutter`])
+	assert.Equal(2.8, metadata[`image.exposure.aperture`])
+	assert.Equal(400, metadata[`image.exposure.iso`])
+	assert.Equal(`2019-06-07 08:09:10`, metadata[`image.captured_at`].(time.Time).Format(`2006-01-02 15:04:05`))
+
+	// images without EXIF data still report their dimensions
+	encoded.Reset()
+	assert.NoError(png.Encode(&encoded, image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})))
+	assert.NoError(ioutil.WriteFile(name, encoded.Bytes(), 0644))
+
+	metadata, err = (&ImageLoader{}).LoadMetadata(name)
+	assert.NoError(err)
+	assert.Equal(`paletted`, metadata[`image.color_model`])
+	assert.NotContains(metadata, `image.camera.make`)
+}
+
+func TestFindPngChunk(t *testing.T) {
+	assert := require.New(t)
+	header := "\x89PNG\r\n\x1A\n"
+
+	png := []byte(header)
+	png = append(png, testPngChunk(`IHDR`, make([]byte, 13))...)
+	png = append(png, testPngChunk(`eXIf`, []byte(`exifdata`))...)
+	png = append(png, testPngChunk(`IDAT`, []byte(`pixels`))...)
+
+	data, err := findPngChunk(bytes.NewReader(png), `eXIf`)
+	assert.NoError(err)
+	assert.Equal([]byte(`exifdata`), data)
+
+	// chunks after the image data are ignored
+	data, err = findPngChunk(bytes.NewReader(png), `tEXt`)
+	assert.NoError(err)
+	assert.Nil(data)
+
+	// truncated inside the chunk
+	_, err = findPngChunk(bytes.NewReader(png[:len(header)+25+12]), `eXIf`)
+	assert.Error(err)
+
+	// truncated before any chunk
+	data, err = findPngChunk(bytes.NewReader(png[:len(header)+4]), `eXIf`)
+	assert.NoError(err)
+	assert.Nil(data)
+
+	// a hostile length is rejected rather than allocated
+	hostile := []byte(header)
+	hostile = append(hostile, "\xFF\xFF\xFF\xF0eXIf"...)
+	hostile = append(hostile, make([]byte, 64)...)
+
+	_, err = findPngChunk(bytes.NewReader(hostile), `eXIf`)
+	assert.Error(err)
+}
+
+func TestFindRiffChunk(t *testing.T) {
+	assert := require.New(t)
+	riffChunk := func(chunkType string, data string) []byte {
+		chunk := []byte(chunkType)
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(data)))
+		chunk = append(append(chunk, size...), data...)
+
+		if len(data)%2 == 1 {
+			chunk = append(chunk, 0)
+		}
+
+		return chunk
+	}
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBP")
+	webp = append(webp, riffChunk(`VP8X`, `odd`)...)
+	webp = append(webp, riffChunk(`EXIF`, "Exif\x00\x00tiff")...)
+
+	data, err := findRiffChunk(bytes.NewReader(webp), `EXIF`)
+	assert.NoError(err)
+	assert.Equal([]byte("Exif\x00\x00tiff"), data)
+
+	data, err = findRiffChunk(bytes.NewReader(webp), `XMP `)
+	assert.NoError(err)
+	assert.Nil(data)
+
+	_, err = findRiffChunk(bytes.NewReader(webp[:len(webp)-4]), `EXIF`)
+	assert.Error(err)
+
+	hostile := append([]byte("RIFF\x00\x00\x00\x00WEBPEXIF\xFF\xFF\xFF\xFF"), make([]byte, 16)...)
+	_, err = findRiffChunk(bytes.NewReader(hostile), `EXIF`)
+	assert.Error(err)
+}
+
+func TestColorModelName(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(`rgba`, colorModelName(color.RGBAModel))
+	assert.Equal(`nrgba64`, colorModelName(color.NRGBA64Model))
+	assert.Equal(`ycbcr`, colorModelName(color.YCbCrModel))
+	assert.Equal(`cmyk`, colorModelName(color.CMYKModel))
+	assert.Equal(`paletted`, colorModelName(color.Palette{color.White}))
+	assert.Equal(`unknown`, colorModelName(color.ModelFunc(func(c color.Color) color.Color { return c })))
+}
+
+func TestImageLoaderCanHandle(t *testing.T) {
+	assert := require.New(t)
+
+	for name, expected := range map[string]bool{
+		`photo.jpg`:  true,
+		`photo.JPEG`: true,
+		`image.png`:  true,
+		`anim.gif`:   true,
+		`image.webp`: true,
+		`scan.tiff`:  true,
+		`old.bmp`:    true,
+		`logo.svg`:   false,
+		`photo.heic`: false,
+		`layers.psd`: false,
+		`icon.ico`:   false,
+		`raw.nef`:    false,
+		`notes.txt`:  false,
+	} {
+		// images without a decoder would only ever produce loader errors
+		assert.Equal(expected, (&ImageLoader{}).CanHandle(name) != nil, name)
+		assert.Equal(expected, (&PerceptualHashLoader{}).CanHandle(name) != nil, name)
+	}
+}
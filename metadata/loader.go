@@ -1,23 +1,82 @@
 package metadata
 
 import (
+	"fmt"
+	"runtime/debug"
 	"sort"
+	"sync"
+	"time"
 )
 
+// How long a loader may run on a single file before it is abandoned; zero means no limit.  Loaders
+// can't be interrupted, so an abandoned loader keeps running in the background (holding any files or
+// memory it is using) until it returns; loaders that may block indefinitely should enforce their own
+// limits, as the command loaders do.
+var DefaultLoaderTimeout = 5 * time.Minute
+
 type Loader interface {
 	CanHandle(string) Loader
 	LoadMetadata(string) (map[string]interface{}, error)
 }
 
+// Loaders whose output depends only on a file's contents can implement this interface to have
+// their results cached.  The version should change whenever the loader's output changes.
+type CacheableLoader interface {
+	Loader
+	LoaderVersion() string
+}
+
+// Loaders whose output replaces everything stored under certain keys (rather than adding to it)
+// implement this interface.  The keys are cleared before the loader's results are merged, so values
+// the loader no longer returns don't linger from earlier scans.
+type ReplacingLoader interface {
+	Loader
+	Replaces() []string
+}
+
+// Describes why a loader failed to return metadata for a file.
+type LoaderError struct {
+	Loader  string
+	Err     error
+	Panic   bool
+	Timeout bool
+	Stack   string
+}
+
+func (self *LoaderError) Error() string {
+	return fmt.Sprintf("%s loader: %v", self.Loader, self.Err)
+}
+
 type LoaderGroup struct {
 	Pass     int
 	Checksum bool
 	Finalize bool
 	Loaders  []Loader
+
+	// the loaders in this pass grouped by dependency level; loaders in the same level may run concurrently
+	Levels [][]Loader
 }
 
 type LoaderSet []LoaderGroup
 
+// A loader along with the name it was registered under.  The loaders in the set built by GetLoaders
+// and those returned by CanHandleFile are named, so registrations sharing a type keep their own
+// timeouts, cache entries and recorded failures.
+type NamedLoader struct {
+	Loader
+	Name string
+}
+
+// Return the loader wrapped by a NamedLoader (or the loader itself if it isn't one), e.g.: to check
+// which of the optional loader interfaces it implements.
+func UnwrapLoader(loader Loader) Loader {
+	if named, ok := loader.(*NamedLoader); ok {
+		return named.Loader
+	}
+
+	return loader
+}
+
 func (self LoaderSet) Passes() (passes []int) {
 	for _, group := range self {
 		if group.Pass > 0 {
@@ -39,30 +98,126 @@ func GetChecksumPass() int {
 	return -1
 }
 
+type loaderSetCache struct {
+	version      int
+	checksumPass int
+	finalizePass int
+	set          LoaderSet
+}
+
+var cachedLoaderSet *loaderSetCache
+var cachedLoaderSetLock sync.Mutex
+
+// Build the set of enabled loaders from the registry, grouped by pass.  The set is rebuilt only
+// when the registry (or ChecksumPass or FinalizePass) changes, so it must not be modified.
 func GetLoaders() LoaderSet {
 	initMime.Do(func() {
 		SetupMimeTypes()
 	})
 
-	return LoaderSet{
-		{
-			Pass: 1,
-			Loaders: []Loader{
-				&FileLoader{},
-				&RegexLoader{},
-				&MediaLoader{},
-				&YTDLLoader{},
-			},
-		}, {
-			Pass:     2,
-			Checksum: true,
-			Finalize: true,
-			Loaders: []Loader{
-				&AudioLoader{},
-				&VideoLoader{},
-			},
-		},
+	loaderRegistryLock.RLock()
+	version := loaderRegistryVersion
+	loaderRegistryLock.RUnlock()
+
+	cachedLoaderSetLock.Lock()
+	defer cachedLoaderSetLock.Unlock()
+
+	if cached := cachedLoaderSet; cached != nil && cached.version == version {
+		if cached.checksumPass == ChecksumPass && cached.finalizePass == FinalizePass {
+			return cached.set
+		}
+	}
+
+	set := buildLoaderSet()
+
+	cachedLoaderSet = &loaderSetCache{
+		version:      version,
+		checksumPass: ChecksumPass,
+		finalizePass: FinalizePass,
+		set:          set,
 	}
+
+	return set
+}
+
+func buildLoaderSet() LoaderSet {
+	registrations := ListLoaders()
+	enabled := make([]*LoaderRegistration, 0)
+	levels := make(map[int][][]Loader)
+	var lastPass int
+
+	for i, registration := range registrations {
+		if registration.Enabled {
+			enabled = append(enabled, &registrations[i])
+		}
+
+		if registration.Pass > lastPass {
+			lastPass = registration.Pass
+		}
+	}
+
+	// dependencies are checked as loaders are registered, so an error here is not expected
+	passes, passLevels, err := resolveLoaderOrder(enabled)
+
+	if err != nil {
+		passes = make([]int, len(enabled))
+		passLevels = make([]int, len(enabled))
+
+		for i, registration := range enabled {
+			passes[i] = registration.Pass
+		}
+	}
+
+	for i, registration := range enabled {
+		pass, level := passes[i], passLevels[i]
+
+		for len(levels[pass]) <= level {
+			levels[pass] = append(levels[pass], nil)
+		}
+
+		levels[pass][level] = append(levels[pass][level], &NamedLoader{
+			Loader: registration.Loader,
+			Name:   registration.Name,
+		})
+
+		if pass > lastPass {
+			lastPass = pass
+		}
+	}
+
+	checksumPass := ChecksumPass
+	finalizePass := FinalizePass
+
+	if checksumPass <= 0 {
+		checksumPass = lastPass
+	}
+
+	if finalizePass <= 0 {
+		finalizePass = lastPass
+	}
+
+	set := make(LoaderSet, 0)
+
+	for pass := 1; pass <= lastPass; pass++ {
+		loaders := make([]Loader, 0)
+
+		for _, level := range levels[pass] {
+			loaders = append(loaders, level...)
+		}
+
+		// passes whose loaders are all disabled are kept if they carry the checksum or finalize step
+		if len(loaders) > 0 || pass == checksumPass || pass == finalizePass {
+			set = append(set, LoaderGroup{
+				Pass:     pass,
+				Checksum: (pass == checksumPass),
+				Finalize: (pass == finalizePass),
+				Loaders:  loaders,
+				Levels:   levels[pass],
+			})
+		}
+	}
+
+	return set
 }
 
 func GetLoaderGroupForPass(pass int) *LoaderGroup {
@@ -81,7 +236,7 @@ func GetLoadersForFile(name string, pass int) []Loader {
 	for _, group := range GetLoaders() {
 		if pass <= 0 || group.Pass == pass {
 			for _, loader := range group.Loaders {
-				if instance := loader.CanHandle(name); instance != nil {
+				if instance := CanHandleFile(loader, name); instance != nil {
 					loaders = append(loaders, instance)
 				}
 			}
@@ -91,6 +246,32 @@ func GetLoadersForFile(name string, pass int) []Loader {
 	return loaders
 }
 
+// Return the loaders that can handle the named file, grouped into the levels they should be run in.
+// Loaders within a level do not depend on one another.
+func GetLoaderLevelsForFile(name string, pass int) [][]Loader {
+	levels := make([][]Loader, 0)
+
+	for _, group := range GetLoaders() {
+		if pass <= 0 || group.Pass == pass {
+			for _, level := range group.Levels {
+				loaders := make([]Loader, 0)
+
+				for _, loader := range level {
+					if instance := CanHandleFile(loader, name); instance != nil {
+						loaders = append(loaders, instance)
+					}
+				}
+
+				if len(loaders) > 0 {
+					levels = append(levels, loaders)
+				}
+			}
+		}
+	}
+
+	return levels
+}
+
 func IsFinalizePass(pass int) bool {
 	for _, group := range GetLoaders() {
 		if group.Pass == pass {
@@ -102,3 +283,122 @@ func IsFinalizePass(pass int) bool {
 
 	return false
 }
+
+// Return how long the named loader may run on a single file.
+func LoaderTimeout(name string) time.Duration {
+	if registration, ok := GetLoaderRegistration(name); ok && registration.Timeout > 0 {
+		return registration.Timeout
+	}
+
+	return DefaultLoaderTimeout
+}
+
+// Ask the given loader whether it can handle the named file, recovering from panics and abandoning
+// the loader if it exceeds its timeout.  Loaders that fail are treated as unable to handle the file.
+// The instance returned carries the name of the given loader.
+func CanHandleFile(loader Loader, name string) Loader {
+	loaderName := LoaderName(loader)
+	timeout := LoaderTimeout(loaderName)
+	done := make(chan Loader, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- nil
+			}
+		}()
+
+		if instance := UnwrapLoader(loader).CanHandle(name); instance != nil {
+			done <- &NamedLoader{
+				Loader: UnwrapLoader(instance),
+				Name:   loaderName,
+			}
+		} else {
+			done <- nil
+		}
+	}()
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case instance := <-done:
+			return instance
+		case <-timer.C:
+			return nil
+		}
+	}
+
+	return <-done
+}
+
+// Run the given loader against the named file, recovering from panics and abandoning the loader if
+// it exceeds its timeout (see DefaultLoaderTimeout).  Loaders implementing DependentLoader are given the existing metadata.
+// All failures are returned as a *LoaderError.
+func RunLoader(loader Loader, name string, existing map[string]interface{}) (map[string]interface{}, error) {
+	type loaderResult struct {
+		data map[string]interface{}
+		err  error
+	}
+
+	loaderName := LoaderName(loader)
+	timeout := LoaderTimeout(loaderName)
+	done := make(chan loaderResult, 1)
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- loaderResult{
+					err: &LoaderError{
+						Loader: loaderName,
+						Err:    fmt.Errorf("panic: %v", r),
+						Panic:  true,
+						Stack:  string(debug.Stack()),
+					},
+				}
+			}
+		}()
+
+		var data map[string]interface{}
+		var err error
+
+		if dependent, ok := UnwrapLoader(loader).(DependentLoader); ok {
+			data, err = dependent.LoadMetadataWith(name, existing)
+		} else {
+			data, err = loader.LoadMetadata(name)
+		}
+
+		done <- loaderResult{data, err}
+	}()
+
+	var result loaderResult
+
+	if timeout > 0 {
+		select {
+		case result = <-done:
+		case <-time.After(timeout):
+			// the loader's goroutine cannot be stopped, but its result will be discarded when it finishes
+			return nil, &LoaderError{
+				Loader:  loaderName,
+				Err:     fmt.Errorf("timed out after %v", timeout),
+				Timeout: true,
+			}
+		}
+	} else {
+		result = <-done
+	}
+
+	if result.err != nil {
+		if _, ok := result.err.(*LoaderError); ok {
+			return nil, result.err
+		}
+
+		return nil, &LoaderError{
+			Loader: loaderName,
+			Err:    result.err,
+		}
+	}
+
+	return result.data, nil
+}
@@ -0,0 +1,81 @@
+package metadata
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testPanicLoader struct {
+	Loader
+}
+
+func (self *testPanicLoader) CanHandle(name string) Loader {
+	if name == `panic` {
+		panic("cannot handle")
+	}
+
+	return self
+}
+
+func (self *testPanicLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	switch name {
+	case `panic`:
+		panic("cannot load")
+	case `error`:
+		return nil, fmt.Errorf("failed")
+	}
+
+	return map[string]interface{}{
+		`name`: name,
+	}, nil
+}
+
+type testSlowLoader struct {
+	Loader
+}
+
+func (self *testSlowLoader) CanHandle(name string) Loader {
+	time.Sleep(time.Second)
+	return self
+}
+
+func (self *testSlowLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	time.Sleep(time.Second)
+	return nil, nil
+}
+
+func TestRunLoaderFailures(t *testing.T) {
+	assert := require.New(t)
+
+	timeout := DefaultLoaderTimeout
+	DefaultLoaderTimeout = 50 * time.Millisecond
+	defer func() {
+		DefaultLoaderTimeout = timeout
+	}()
+
+	loader := &testPanicLoader{}
+
+	assert.Equal(loader, UnwrapLoader(CanHandleFile(loader, `ok`)))
+	assert.Nil(CanHandleFile(loader, `panic`))
+	assert.Nil(CanHandleFile(&testSlowLoader{}, `ok`))
+
+	data, err := RunLoader(loader, `ok`, nil)
+	assert.NoError(err)
+	assert.Equal(`ok`, data[`name`])
+
+	_, err = RunLoader(loader, `error`, nil)
+	assert.IsType(&LoaderError{}, err)
+	assert.False(err.(*LoaderError).Panic)
+
+	_, err = RunLoader(loader, `panic`, nil)
+	assert.IsType(&LoaderError{}, err)
+	assert.True(err.(*LoaderError).Panic)
+	assert.NotEmpty(err.(*LoaderError).Stack)
+
+	_, err = RunLoader(&testSlowLoader{}, `ok`, nil)
+	assert.IsType(&LoaderError{}, err)
+	assert.True(err.(*LoaderError).Timeout)
+}
@@ -1,8 +1,10 @@
 package metadata
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -12,12 +14,76 @@ import (
 	"github.com/ghetzel/go-stockutil/stringutil"
 )
 
+// How many parent directories are searched for a tvshow.nfo describing an episode.
+var MediaShowInfoSearchDepth = 3
+
+// Directory-level NFO files, in order of preference.
+var MediaDirectoryInfoFiles = []string{
+	`tvshow.nfo`,
+	`artist.nfo`,
+	`album.nfo`,
+}
+
 type nfoActor struct {
 	Name  string   `json:"name"            xml:"name"            structs:"name"`
 	Roles []string `json:"roles"           xml:"role"            structs:"roles,omitempty"`
 	Photo string   `json:"photo,omitempty" xml:"thumb,omitempty" structs:"photo,omitempty"`
 }
 
+type nfoUniqueId struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type nfoRating struct {
+	Name    string  `xml:"name,attr"`
+	Max     float64 `xml:"max,attr"`
+	Default bool    `xml:"default,attr"`
+	Value   float64 `xml:"value"`
+	Votes   int64   `xml:"votes"`
+}
+
+// Movie sets are either a plain name, or a name and overview.
+type nfoSet struct {
+	Name     string `xml:"name"`
+	Overview string `xml:"overview"`
+	Value    string `xml:",chardata"`
+}
+
+type nfoVideoStream struct {
+	Codec    string  `json:"codec,omitempty"      xml:"codec"`
+	Aspect   float64 `json:"aspect,omitempty"     xml:"aspect"`
+	Width    int     `json:"width,omitempty"      xml:"width"`
+	Height   int     `json:"height,omitempty"     xml:"height"`
+	Duration int     `json:"duration,omitempty"   xml:"durationinseconds"`
+	Stereo   string  `json:"stereomode,omitempty" xml:"stereomode"`
+	HDR      string  `json:"hdrtype,omitempty"    xml:"hdrtype"`
+}
+
+type nfoAudioStream struct {
+	Codec    string `json:"codec,omitempty"    xml:"codec"`
+	Language string `json:"language,omitempty" xml:"language"`
+	Channels int    `json:"channels,omitempty" xml:"channels"`
+}
+
+type nfoSubtitleStream struct {
+	Language string `json:"language,omitempty" xml:"language"`
+}
+
+type nfoStreamDetails struct {
+	Video    []nfoVideoStream    `xml:"streamdetails>video"`
+	Audio    []nfoAudioStream    `xml:"streamdetails>audio"`
+	Subtitle []nfoSubtitleStream `xml:"streamdetails>subtitle"`
+}
+
+// Elements common to most NFO formats that are converted into a normalized form.
+type nfoCommon struct {
+	UniqueIds []nfoUniqueId     `xml:"uniqueid"         structs:"-"`
+	Ratings   []nfoRating       `xml:"ratings>rating"   structs:"-"`
+	FileInfo  *nfoStreamDetails `xml:"fileinfo"         structs:"-"`
+}
+
 type nfoTvShow struct {
 	XMLName   xml.Name   `xml:"tvshow"`
 	Title     string     `json:"title"               xml:"title"`
@@ -28,6 +94,7 @@ type nfoTvShow struct {
 	Premiered string     `json:"premiered,omitempty" xml:"premiered,omitempty"`
 	Rating    float64    `json:"rating,omitempty"    xml:"rating,omitempty"`
 	Studio    string     `json:"studio,omitempty"    xml:"studio,omitempty"`
+	nfoCommon
 }
 
 type nfoEpisodeDetails struct {
@@ -47,6 +114,7 @@ type nfoEpisodeDetails struct {
 	ShowTitle      string     `json:"showtitle,omitempty"      xml:"showtitle,omitempty"`
 	Thumbnail      string     `json:"thumb,omitempty"          xml:"thumb,omitempty"`
 	Watched        bool       `json:"watched,omitempty"        xml:"watched,omitempty"`
+	nfoCommon
 }
 
 type nfoMovieDetails struct {
@@ -61,6 +129,73 @@ type nfoMovieDetails struct {
 	Premiered     string     `json:"aired,omitempty"         xml:"aired,omitempty"`
 	Tagline       string     `json:"tagline"                 xml:"tagline"`
 	Director      string     `json:"director,omitempty"      xml:"director,omitempty"`
+	Set           *nfoSet    `json:"-"                       xml:"set"                structs:"-"`
+	nfoCommon
+}
+
+type nfoMusicVideo struct {
+	XMLName   xml.Name `xml:"musicvideo"`
+	Title     string   `json:"title"               xml:"title"`
+	Artists   []string `json:"artists,omitempty"   xml:"artist,omitempty"`
+	Album     string   `json:"album,omitempty"     xml:"album,omitempty"`
+	Genres    []string `json:"genres,omitempty"    xml:"genre,omitempty"`
+	Director  string   `json:"director,omitempty"  xml:"director,omitempty"`
+	Studio    string   `json:"studio,omitempty"    xml:"studio,omitempty"`
+	Plot      string   `json:"plot,omitempty"      xml:"plot,omitempty"`
+	Premiered string   `json:"premiered,omitempty" xml:"premiered,omitempty"`
+	Year      int      `json:"year,omitempty"      xml:"year,omitempty"`
+	Track     int      `json:"track,omitempty"     xml:"track,omitempty"`
+	Runtime   int      `json:"runtime,omitempty"   xml:"runtime,omitempty"`
+	nfoCommon
+}
+
+type nfoArtistAlbum struct {
+	Title string `json:"title"          xml:"title"`
+	Year  int    `json:"year,omitempty" xml:"year,omitempty"`
+}
+
+type nfoArtist struct {
+	XMLName        xml.Name         `xml:"artist"`
+	Name           string           `json:"name"                     xml:"name"`
+	SortName       string           `json:"sortname,omitempty"       xml:"sortname,omitempty"`
+	Type           string           `json:"artist_type,omitempty"    xml:"type,omitempty"          structs:"artist_type"`
+	Gender         string           `json:"gender,omitempty"         xml:"gender,omitempty"`
+	Disambiguation string           `json:"disambiguation,omitempty" xml:"disambiguation,omitempty"`
+	Genres         []string         `json:"genres,omitempty"         xml:"genre,omitempty"`
+	Styles         []string         `json:"styles,omitempty"         xml:"style,omitempty"`
+	Moods          []string         `json:"moods,omitempty"          xml:"mood,omitempty"`
+	Born           string           `json:"born,omitempty"           xml:"born,omitempty"`
+	Formed         string           `json:"formed,omitempty"         xml:"formed,omitempty"`
+	Died           string           `json:"died,omitempty"           xml:"died,omitempty"`
+	Disbanded      string           `json:"disbanded,omitempty"      xml:"disbanded,omitempty"`
+	Biography      string           `json:"biography,omitempty"      xml:"biography,omitempty"`
+	Albums         []nfoArtistAlbum `json:"albums,omitempty"         xml:"album,omitempty"`
+	MusicBrainzId  string           `json:"-"                        xml:"musicBrainzArtistID"     structs:"-"`
+}
+
+type nfoAlbumArtist struct {
+	Artist        string `json:"name"                     xml:"artist"`
+	MusicBrainzId string `json:"musicbrainz_id,omitempty" xml:"musicBrainzArtistID"`
+}
+
+type nfoAlbum struct {
+	XMLName          xml.Name         `xml:"album"`
+	Title            string           `json:"title"                 xml:"title"`
+	ArtistDesc       string           `json:"artist,omitempty"      xml:"artistdesc,omitempty"`
+	Artists          []nfoAlbumArtist `json:"artists,omitempty"     xml:"albumArtistCredits,omitempty"`
+	Genres           []string         `json:"genres,omitempty"      xml:"genre,omitempty"`
+	Styles           []string         `json:"styles,omitempty"      xml:"style,omitempty"`
+	Moods            []string         `json:"moods,omitempty"       xml:"mood,omitempty"`
+	Themes           []string         `json:"themes,omitempty"      xml:"theme,omitempty"`
+	Compilation      bool             `json:"compilation,omitempty" xml:"compilation,omitempty"`
+	Review           string           `json:"review,omitempty"      xml:"review,omitempty"`
+	Type             string           `json:"album_type,omitempty"  xml:"type,omitempty"         structs:"album_type"`
+	ReleaseDate      string           `json:"releasedate,omitempty" xml:"releasedate,omitempty"`
+	Label            string           `json:"label,omitempty"       xml:"label,omitempty"`
+	Year             int              `json:"year,omitempty"        xml:"year,omitempty"`
+	Rating           float64          `json:"rating,omitempty"      xml:"rating,omitempty"`
+	MusicBrainzId    string           `json:"-"                     xml:"musicbrainzalbumid"        structs:"-"`
+	MusicBrainzGroup string           `json:"-"                     xml:"musicbrainzreleasegroupid" structs:"-"`
 }
 
 type MediaLoader struct {
@@ -70,11 +205,13 @@ type MediaLoader struct {
 
 func (self *MediaLoader) CanHandle(name string) Loader {
 	if stat, err := os.Stat(name); err == nil && stat.IsDir() {
-		showinfo := path.Join(name, `tvshow.nfo`)
+		for _, filename := range MediaDirectoryInfoFiles {
+			dirinfo := path.Join(name, filename)
 
-		if _, err := os.Stat(showinfo); err == nil {
-			return &MediaLoader{
-				nfoFileName: showinfo,
+			if _, err := os.Stat(dirinfo); err == nil {
+				return &MediaLoader{
+					nfoFileName: dirinfo,
+				}
 			}
 		}
 	}
@@ -102,8 +239,10 @@ func (self *MediaLoader) getNfoPath(name string) string {
 	dir, base := path.Split(name)
 	ext := path.Ext(base)
 
-	if base == `tvshow.nfo` {
-		return name
+	for _, filename := range MediaDirectoryInfoFiles {
+		if base == filename {
+			return name
+		}
 	}
 
 	if ext != `.nfo` {
@@ -113,68 +252,180 @@ func (self *MediaLoader) getNfoPath(name string) string {
 	return ``
 }
 
+// Locate the tvshow.nfo describing the show an episode belongs to, looking first alongside the
+// episode and then in its parent directories (e.g.: Show/Season 01/episode.mkv).
+func (self *MediaLoader) findShowInfoFile(episodeNfo string) string {
+	dir := path.Dir(episodeNfo)
+
+	for i := 0; i <= MediaShowInfoSearchDepth; i++ {
+		if showfile := path.Join(dir, `tvshow.nfo`); showfile != episodeNfo {
+			if _, err := os.Stat(showfile); err == nil {
+				return showfile
+			}
+		}
+
+		if parent := path.Dir(dir); parent != dir {
+			dir = parent
+		} else {
+			break
+		}
+	}
+
+	return ``
+}
+
 func (self *MediaLoader) parseMediaInfoFile(name string) (map[string]interface{}, error) {
 	if file, err := os.Open(name); err == nil {
+		defer file.Close()
+
 		if data, err := ioutil.ReadAll(file); err == nil {
-			rv := make(map[string]interface{})
-
-			// try episodedetails
-			// ----------------------------------------------------------------------------------------
-			ep := nfoEpisodeDetails{}
-			var st *structs.Struct
-
-			if err := xml.Unmarshal(data, &ep); err == nil {
-				if ep.Title != `` {
-					// include the parent tvshow details (if available)
-					if showfile := path.Join(path.Dir(name), `tvshow.nfo`); showfile != name {
-						if tvshow, err := self.parseMediaInfoFile(showfile); err == nil {
-							if info, ok := tvshow[`media`]; ok {
-								rv[`show`] = info
+			var rv map[string]interface{}
+			episodes := make([]map[string]interface{}, 0)
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+
+			// multi-episode files contain several top-level episodedetails elements, so the document
+			// is read element-by-element rather than as a single root
+			for {
+				token, err := decoder.Token()
+
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					// trailing junk (e.g.: a scraper URL after the XML) is common in NFO files
+					if rv != nil || len(episodes) > 0 {
+						break
+					}
+
+					return nil, fmt.Errorf("Unrecognized MediaInfo file format at %q: %v", name, err)
+				}
+
+				start, ok := token.(xml.StartElement)
+
+				if !ok {
+					continue
+				}
+
+				switch start.Name.Local {
+				case `episodedetails`:
+					ep := nfoEpisodeDetails{}
+
+					if err := decoder.DecodeElement(&ep, &start); err == nil && ep.Title != `` {
+						episode := nfoToMap(ep)
+						applyNfoCommon(episode, ep.nfoCommon)
+						episodes = append(episodes, episode)
+					}
+
+				case `movie`:
+					movie := nfoMovieDetails{}
+
+					if err := decoder.DecodeElement(&movie, &start); err == nil && movie.Title != `` {
+						rv = nfoToMap(movie)
+						rv[`type`] = `movie`
+						applyNfoCommon(rv, movie.nfoCommon)
+
+						if movie.Set != nil {
+							set := make(map[string]interface{})
+
+							if setName := strings.TrimSpace(movie.Set.Name); setName != `` {
+								set[`name`] = setName
+							} else if setName := strings.TrimSpace(movie.Set.Value); setName != `` {
+								set[`name`] = setName
+							}
+
+							if overview := strings.TrimSpace(movie.Set.Overview); overview != `` {
+								set[`overview`] = overview
+							}
+
+							if len(set) > 0 {
+								rv[`set`] = set
 							}
 						}
 					}
 
-					rv[`type`] = `episode`
-					st = structs.New(ep)
-				}
-			}
+				case `tvshow`:
+					show := nfoTvShow{}
+
+					if err := decoder.DecodeElement(&show, &start); err == nil && show.Title != `` {
+						rv = nfoToMap(show)
+						rv[`type`] = `tvshow`
+						applyNfoCommon(rv, show.nfoCommon)
+					}
+
+				case `musicvideo`:
+					video := nfoMusicVideo{}
 
-			// try movie
-			// ----------------------------------------------------------------------------------------
-			movie := nfoMovieDetails{}
+					if err := decoder.DecodeElement(&video, &start); err == nil && video.Title != `` {
+						rv = nfoToMap(video)
+						rv[`type`] = `musicvideo`
+						applyNfoCommon(rv, video.nfoCommon)
+					}
+
+				case `artist`:
+					artist := nfoArtist{}
+
+					if err := decoder.DecodeElement(&artist, &start); err == nil && artist.Name != `` {
+						rv = nfoToMap(artist)
+						rv[`type`] = `artist`
+
+						if id := strings.TrimSpace(artist.MusicBrainzId); id != `` {
+							rv[`musicbrainz`] = map[string]interface{}{
+								`artist_id`: id,
+							}
+						}
+					}
+
+				case `album`:
+					album := nfoAlbum{}
+
+					if err := decoder.DecodeElement(&album, &start); err == nil && album.Title != `` {
+						rv = nfoToMap(album)
+						rv[`type`] = `album`
+						musicbrainz := make(map[string]interface{})
 
-			if err := xml.Unmarshal(data, &movie); err == nil {
-				if movie.Title != `` {
-					rv[`type`] = `movie`
-					st = structs.New(movie)
+						if id := strings.TrimSpace(album.MusicBrainzId); id != `` {
+							musicbrainz[`album_id`] = id
+						}
+
+						if id := strings.TrimSpace(album.MusicBrainzGroup); id != `` {
+							musicbrainz[`release_group_id`] = id
+						}
+
+						if len(musicbrainz) > 0 {
+							rv[`musicbrainz`] = musicbrainz
+						}
+					}
+
+				default:
+					decoder.Skip()
 				}
 			}
 
-			// try tvshow
-			// ----------------------------------------------------------------------------------------
-			show := nfoTvShow{}
+			if len(episodes) > 0 {
+				rv = make(map[string]interface{})
 
-			if err := xml.Unmarshal(data, &show); err == nil {
-				if show.Title != `` {
-					rv[`type`] = `tvshow`
-					st = structs.New(show)
+				for k, v := range episodes[0] {
+					rv[k] = v
 				}
-			}
 
-			if st != nil {
-				for _, field := range st.Fields() {
-					if !field.IsZero() {
-						key := stringutil.Underscore(field.Name())
+				rv[`type`] = `episode`
 
-						switch key {
-						case `xml_name`:
-							continue
-						default:
-							rv[key] = field.Value()
+				// multi-episode files expose each episode alongside the first
+				if len(episodes) > 1 {
+					rv[`episodes`] = episodes
+					rv[`episode_count`] = len(episodes)
+				}
+
+				// include the parent tvshow details (if available)
+				if showfile := self.findShowInfoFile(name); showfile != `` {
+					if tvshow, err := self.parseMediaInfoFile(showfile); err == nil {
+						if info, ok := tvshow[`media`]; ok {
+							rv[`show`] = info
 						}
 					}
 				}
+			}
 
+			if rv != nil {
 				return map[string]interface{}{
 					`media`: rv,
 				}, nil
@@ -188,3 +439,102 @@ func (self *MediaLoader) parseMediaInfoFile(name string) (map[string]interface{}
 		return nil, err
 	}
 }
+
+// Convert the non-zero fields of an NFO struct into a map keyed on the underscored field names.
+func nfoToMap(nfo interface{}) map[string]interface{} {
+	rv := make(map[string]interface{})
+
+	for _, field := range structs.New(nfo).Fields() {
+		if !field.IsExported() || field.IsEmbedded() || field.IsZero() || field.Name() == `XMLName` {
+			continue
+		}
+
+		key := stringutil.Underscore(field.Name())
+
+		if tag := strings.Split(field.Tag(`structs`), `,`)[0]; tag != `` {
+			key = tag
+		}
+
+		rv[key] = field.Value()
+	}
+
+	return rv
+}
+
+// Add the normalized unique IDs, ratings and stream details to the given NFO data.
+func applyNfoCommon(rv map[string]interface{}, common nfoCommon) {
+	ids := make(map[string]interface{})
+
+	for _, uid := range common.UniqueIds {
+		idType := strings.ToLower(strings.TrimSpace(uid.Type))
+
+		if idType == `` {
+			idType = `unknown`
+		}
+
+		if value := strings.TrimSpace(uid.Value); value != `` {
+			ids[idType] = value
+
+			if uid.Default {
+				rv[`default_id`] = idType
+			}
+		}
+	}
+
+	if len(ids) > 0 {
+		rv[`ids`] = ids
+	}
+
+	ratings := make(map[string]interface{})
+
+	for _, rating := range common.Ratings {
+		source := strings.ToLower(strings.TrimSpace(rating.Name))
+
+		if source == `` {
+			source = `default`
+		}
+
+		entry := map[string]interface{}{
+			`value`: rating.Value,
+		}
+
+		if rating.Max > 0 {
+			entry[`max`] = rating.Max
+		}
+
+		if rating.Votes > 0 {
+			entry[`votes`] = rating.Votes
+		}
+
+		ratings[source] = entry
+
+		// the default rating stands in for the single rating element when it's missing
+		if _, ok := rv[`rating`]; !ok && rating.Default && rating.Value > 0 {
+			rv[`rating`] = rating.Value
+		}
+	}
+
+	if len(ratings) > 0 {
+		rv[`ratings`] = ratings
+	}
+
+	if info := common.FileInfo; info != nil {
+		streams := make(map[string]interface{})
+
+		if len(info.Video) > 0 {
+			streams[`video`] = info.Video
+		}
+
+		if len(info.Audio) > 0 {
+			streams[`audio`] = info.Audio
+		}
+
+		if len(info.Subtitle) > 0 {
+			streams[`subtitle`] = info.Subtitle
+		}
+
+		if len(streams) > 0 {
+			rv[`streams`] = streams
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testLoadNfo(assert *require.Assertions, name string, nfo string) map[string]interface{} {
+	assert.NoError(os.MkdirAll(filepath.Dir(name), 0755))
+	assert.NoError(ioutil.WriteFile(name, []byte(nfo), 0644))
+
+	data, err := (&MediaLoader{}).parseMediaInfoFile(name)
+	assert.NoError(err, name)
+
+	return data[`media`].(map[string]interface{})
+}
+
+func TestMediaLoaderMovie(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-media-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	movie := testLoadNfo(assert, filepath.Join(dir, `movie.nfo`), `<?xml version="1.0" encoding="UTF-8" standalone="yes" ?>
+<movie>
+	<title>The Movie</title>
+	<originaltitle>Le Film</originaltitle>
+	<tagline>It's a movie.</tagline>
+	<genre>Drama</genre>
+	<genre>Comedy</genre>
+	<mpaa>PG</mpaa>
+	<director>Jane Doe</director>
+	<actor>
+		<name>John Roe</name>
+		<role>Himself</role>
+		<thumb>http://example.com/john.jpg</thumb>
+	</actor>
+	<uniqueid type="imdb" default="true">tt0000001</uniqueid>
+	<uniqueid type="TMDB">123</uniqueid>
+	<uniqueid> </uniqueid>
+	<ratings>
+		<rating name="imdb" max="10" default="true">
+			<value>7.5</value>
+			<votes>1000</votes>
+		</rating>
+		<rating name="themoviedb">
+			<value>6.8</value>
+		</rating>
+	</ratings>
+	<set>
+		<name> The Collection </name>
+		<overview>Several movies.</overview>
+	</set>
+	<fileinfo>
+		<streamdetails>
+			<video>
+				<codec>h264</codec>
+				<aspect>1.78</aspect>
+				<width>1920</width>
+				<height>1080</height>
+				<durationinseconds>5400</durationinseconds>
+				<hdrtype>hdr10</hdrtype>
+			</video>
+			<audio>
+				<codec>ac3</codec>
+				<language>eng</language>
+				<channels>6</channels>
+			</audio>
+			<audio>
+				<codec>aac</codec>
+				<language>fre</language>
+				<channels>2</channels>
+			</audio>
+			<subtitle>
+				<language>eng</language>
+			</subtitle>
+		</streamdetails>
+	</fileinfo>
+</movie>
+http://www.themoviedb.org/movie/123`)
+
+	assert.Equal(`movie`, movie[`type`])
+	assert.Equal(`The Movie`, movie[`title`])
+	assert.Equal(`Le Film`, movie[`original_title`])
+	assert.Equal(`It's a movie.`, movie[`tagline`])
+	assert.Equal([]string{`Drama`, `Comedy`}, movie[`genres`])
+	assert.Equal(`PG`, movie[`mpaa`])
+	assert.Equal(`Jane Doe`, movie[`director`])
+	assert.Equal([]nfoActor{{
+		Name:  `John Roe`,
+		Roles: []string{`Himself`},
+		Photo: `http://example.com/john.jpg`,
+	}}, movie[`actors`])
+	assert.NotContains(movie, `xmlname`)
+
+	assert.Equal(map[string]interface{}{
+		`imdb`: `tt0000001`,
+		`tmdb`: `123`,
+	}, movie[`ids`])
+	assert.Equal(`imdb`, movie[`default_id`])
+
+	assert.Equal(map[string]interface{}{
+		`imdb`: map[string]interface{}{
+			`value`: 7.5,
+			`max`:   float64(10),
+			`votes`: int64(1000),
+		},
+		`themoviedb`: map[string]interface{}{
+			`value`: 6.8,
+		},
+	}, movie[`ratings`])
+	assert.Equal(7.5, movie[`rating`])
+
+	assert.Equal(map[string]interface{}{
+		`name`:     `The Collection`,
+		`overview`: `Several movies.`,
+	}, movie[`set`])
+
+	assert.Equal(map[string]interface{}{
+		`video`: []nfoVideoStream{{
+			Codec:    `h264`,
+			Aspect:   1.78,
+			Width:    1920,
+			Height:   1080,
+			Duration: 5400,
+			HDR:      `hdr10`,
+		}},
+		`audio`: []nfoAudioStream{
+			{Codec: `ac3`, Language: `eng`, Channels: 6},
+			{Codec: `aac`, Language: `fre`, Channels: 2},
+		},
+		`subtitle`: []nfoSubtitleStream{
+			{Language: `eng`},
+		},
+	}, movie[`streams`])
+
+	// older NFOs give the set as a plain name
+	movie = testLoadNfo(assert, filepath.Join(dir, `other.nfo`), `<movie><title>Another</title><set>Old Collection</set></movie>`)
+
+	assert.Equal(map[string]interface{}{
+		`name`: `Old Collection`,
+	}, movie[`set`])
+	assert.NotContains(movie, `ids`)
+	assert.NotContains(movie, `ratings`)
+	assert.NotContains(movie, `streams`)
+
+	// files that aren't NFOs are rejected
+	name := filepath.Join(dir, `junk.nfo`)
+	assert.NoError(ioutil.WriteFile(name, []byte(`<html><body>not an nfo</body></html>`), 0644))
+
+	_, err = (&MediaLoader{}).parseMediaInfoFile(name)
+	assert.Error(err)
+}
+
+func TestMediaLoaderTvShow(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-media-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	showDir := filepath.Join(dir, `The Show`)
+
+	show := testLoadNfo(assert, filepath.Join(showDir, `tvshow.nfo`), `<tvshow>
+	<title>The Show</title>
+	<genre>Sci-Fi</genre>
+	<premiered>2001-02-03</premiered>
+	<studio>Network</studio>
+	<uniqueid type="tvdb" default="true">555</uniqueid>
+</tvshow>`)
+
+	assert.Equal(`tvshow`, show[`type`])
+	assert.Equal(`The Show`, show[`title`])
+	assert.Equal([]string{`Sci-Fi`}, show[`genres`])
+	assert.Equal(`2001-02-03`, show[`premiered`])
+	assert.Equal(`Network`, show[`studio`])
+	assert.Equal(map[string]interface{}{`tvdb`: `555`}, show[`ids`])
+
+	// directories are described by their tvshow.nfo
+	loader := (&MediaLoader{}).CanHandle(showDir)
+	assert.NotNil(loader)
+
+	data, err := loader.LoadMetadata(showDir)
+	assert.NoError(err)
+	assert.Equal(show, data[`media`])
+
+	// episodes include the details of the show from a parent directory
+	episode := testLoadNfo(assert, filepath.Join(showDir, `Season 01`, `S01E01.nfo`), `<episodedetails>
+	<title>Pilot</title>
+	<showtitle>The Show</showtitle>
+	<season>1</season>
+	<episode>1</episode>
+	<aired>2001-02-03</aired>
+	<uniqueid type="tvdb">1001</uniqueid>
+</episodedetails>`)
+
+	assert.Equal(`episode`, episode[`type`])
+	assert.Equal(`Pilot`, episode[`title`])
+	assert.Equal(`The Show`, episode[`show_title`])
+	assert.Equal(1, episode[`season`])
+	assert.Equal(1, episode[`episode`])
+	assert.Equal(`2001-02-03`, episode[`aired`])
+	assert.Equal(map[string]interface{}{`tvdb`: `1001`}, episode[`ids`])
+	assert.Equal(show, episode[`show`])
+	assert.NotContains(episode, `episodes`)
+
+	// video files are described by the NFO of the same name
+	video := filepath.Join(showDir, `Season 01`, `S01E01.mkv`)
+	assert.NoError(ioutil.WriteFile(video, nil, 0644))
+
+	loader = (&MediaLoader{}).CanHandle(video)
+	assert.NotNil(loader)
+
+	data, err = loader.LoadMetadata(video)
+	assert.NoError(err)
+	assert.Equal(episode, data[`media`])
+
+	assert.Nil((&MediaLoader{}).CanHandle(filepath.Join(showDir, `Season 01`, `S01E02.mkv`)))
+}
+
+func TestMediaLoaderMultiEpisode(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-media-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	episode := testLoadNfo(assert, filepath.Join(dir, `S01E01-E02.nfo`), `<?xml version="1.0" encoding="UTF-8"?>
+<episodedetails>
+	<title>Part One</title>
+	<season>1</season>
+	<episode>1</episode>
+</episodedetails>
+<episodedetails>
+	<title>Part Two</title>
+	<season>1</season>
+	<episode>2</episode>
+</episodedetails>
+<episodedetails>
+	<season>1</season>
+	<episode>3</episode>
+</episodedetails>`)
+
+	assert.Equal(`episode`, episode[`type`])
+	assert.Equal(`Part One`, episode[`title`])
+	assert.Equal(1, episode[`episode`])
+	assert.Equal(2, episode[`episode_count`])
+	assert.NotContains(episode, `show`)
+
+	episodes := episode[`episodes`].([]map[string]interface{})
+	assert.Len(episodes, 2)
+	assert.Equal(`Part One`, episodes[0][`title`])
+	assert.Equal(`Part Two`, episodes[1][`title`])
+	assert.Equal(2, episodes[1][`episode`])
+}
+
+func TestMediaLoaderShowSearchDepth(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-media-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	testLoadNfo(assert, filepath.Join(dir, `show`, `tvshow.nfo`), `<tvshow><title>The Show</title></tvshow>`)
+	nfo := `<episodedetails><title>Episode</title><season>1</season><episode>1</episode></episodedetails>`
+
+	for _, tc := range []struct {
+		path  []string
+		found bool
+	}{
+		{[]string{`show`, `episode.nfo`}, true},
+		{[]string{`show`, `a`, `episode.nfo`}, true},
+		{[]string{`show`, `a`, `b`, `c`, `episode.nfo`}, true},
+		{[]string{`show`, `a`, `b`, `c`, `d`, `episode.nfo`}, false},
+		{[]string{`other`, `episode.nfo`}, false},
+	} {
+		episode := testLoadNfo(assert, filepath.Join(append([]string{dir}, tc.path...)...), nfo)
+
+		if tc.found {
+			assert.Equal(`The Show`, episode[`show`].(map[string]interface{})[`title`], "%v", tc.path)
+		} else {
+			assert.NotContains(episode, `show`, "%v", tc.path)
+		}
+	}
+}
+
+func TestMediaLoaderMusic(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-media-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	video := testLoadNfo(assert, filepath.Join(dir, `video.nfo`), `<musicvideo>
+	<title>The Song</title>
+	<artist>The Band</artist>
+	<artist>Guest</artist>
+	<album>The Album</album>
+	<year>1999</year>
+	<track>4</track>
+	<ratings><rating name="imdb" default="true"><value>8</value></rating></ratings>
+</musicvideo>`)
+
+	assert.Equal(`musicvideo`, video[`type`])
+	assert.Equal(`The Song`, video[`title`])
+	assert.Equal([]string{`The Band`, `Guest`}, video[`artists`])
+	assert.Equal(`The Album`, video[`album`])
+	assert.Equal(1999, video[`year`])
+	assert.Equal(4, video[`track`])
+	assert.Equal(float64(8), video[`rating`])
+
+	artistDir := filepath.Join(dir, `The Band`)
+
+	artist := testLoadNfo(assert, filepath.Join(artistDir, `artist.nfo`), `<artist>
+	<name>The Band</name>
+	<sortname>Band, The</sortname>
+	<type>Group</type>
+	<genre>Rock</genre>
+	<formed>1990</formed>
+	<musicBrainzArtistID> 0000-artist </musicBrainzArtistID>
+	<album><title>The Album</title><year>1999</year></album>
+</artist>`)
+
+	assert.Equal(`artist`, artist[`type`])
+	assert.Equal(`The Band`, artist[`name`])
+	assert.Equal(`Band, The`, artist[`sort_name`])
+	assert.Equal(`Group`, artist[`artist_type`])
+	assert.Equal([]string{`Rock`}, artist[`genres`])
+	assert.Equal(`1990`, artist[`formed`])
+	assert.Equal([]nfoArtistAlbum{{Title: `The Album`, Year: 1999}}, artist[`albums`])
+	assert.Equal(map[string]interface{}{`artist_id`: `0000-artist`}, artist[`musicbrainz`])
+
+	album := testLoadNfo(assert, filepath.Join(artistDir, `The Album`, `album.nfo`), `<album>
+	<title>The Album</title>
+	<artistdesc>The Band</artistdesc>
+	<albumArtistCredits>
+		<artist>The Band</artist>
+		<musicBrainzArtistID>0000-artist</musicBrainzArtistID>
+	</albumArtistCredits>
+	<type>Album</type>
+	<releasedate>1999-05-01</releasedate>
+	<year>1999</year>
+	<compilation>false</compilation>
+	<musicbrainzalbumid>0000-album</musicbrainzalbumid>
+	<musicbrainzreleasegroupid>0000-group</musicbrainzreleasegroupid>
+</album>`)
+
+	assert.Equal(`album`, album[`type`])
+	assert.Equal(`The Album`, album[`title`])
+	assert.Equal(`The Band`, album[`artist_desc`])
+	assert.Equal([]nfoAlbumArtist{{Artist: `The Band`, MusicBrainzId: `0000-artist`}}, album[`artists`])
+	assert.Equal(`Album`, album[`album_type`])
+	assert.Equal(`1999-05-01`, album[`release_date`])
+	assert.Equal(1999, album[`year`])
+	assert.NotContains(album, `compilation`)
+	assert.Equal(map[string]interface{}{
+		`album_id`:         `0000-album`,
+		`release_group_id`: `0000-group`,
+	}, album[`musicbrainz`])
+
+	// an artist directory is described by its artist.nfo
+	loader := (&MediaLoader{}).CanHandle(artistDir)
+	assert.NotNil(loader)
+
+	data, err := loader.LoadMetadata(artistDir)
+	assert.NoError(err)
+	assert.Equal(artist, data[`media`])
+}
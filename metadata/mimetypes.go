@@ -476,4 +476,19 @@ func SetupMimeTypes() {
 	mime.AddExtensionType(`.xz`, `application/x-xz`)
 	mime.AddExtensionType(`.zip`, `application/zip`)
 	mime.AddExtensionType(`.zmt`, `chemical/x-mopac-input`)
+
+	// formats missing from the list above
+	mime.AddExtensionType(`.7z`, `application/x-7z-compressed`)
+	mime.AddExtensionType(`.bz2`, `application/x-bzip2`)
+	mime.AddExtensionType(`.docm`, `application/vnd.ms-word.document.macroEnabled.12`)
+	mime.AddExtensionType(`.docx`, `application/vnd.openxmlformats-officedocument.wordprocessingml.document`)
+	mime.AddExtensionType(`.dotx`, `application/vnd.openxmlformats-officedocument.wordprocessingml.template`)
+	mime.AddExtensionType(`.epub`, `application/epub+zip`)
+	mime.AddExtensionType(`.odg`, `application/vnd.oasis.opendocument.graphics`)
+	mime.AddExtensionType(`.pptx`, `application/vnd.openxmlformats-officedocument.presentationml.presentation`)
+	mime.AddExtensionType(`.tbz2`, `application/x-bzip2`)
+	mime.AddExtensionType(`.tgz`, `application/gzip`)
+	mime.AddExtensionType(`.xlsm`, `application/vnd.ms-excel.sheet.macroEnabled.12`)
+	mime.AddExtensionType(`.xlsx`, `application/vnd.openxmlformats-officedocument.spreadsheetml.sheet`)
+	mime.AddExtensionType(`.zst`, `application/zstd`)
 }
@@ -0,0 +1,242 @@
+package metadata
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+)
+
+var PerceptualHashTypes = []string{`ahash`, `dhash`, `phash`}
+
+type PerceptualHashLoader struct {
+	Loader
+}
+
+func (self *PerceptualHashLoader) CanHandle(name string) Loader {
+	if isDecodableImage(name) {
+		return &PerceptualHashLoader{}
+	}
+
+	return nil
+}
+
+func (self *PerceptualHashLoader) LoaderVersion() string {
+	return `2`
+}
+
+func (self *PerceptualHashLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	if file, err := os.Open(name); err == nil {
+		defer file.Close()
+
+		if img, _, err := image.Decode(file); err == nil {
+			return map[string]interface{}{
+				`image.hash.ahash`: formatHash(AverageHash(img)),
+				`image.hash.dhash`: formatHash(DifferenceHash(img)),
+				`image.hash.phash`: formatHash(PerceptualHash(img)),
+			}, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Compute a 64-bit hash where each bit represents whether a pixel in an 8x8 grayscale
+// reduction of the image is brighter than the mean.
+func AverageHash(img image.Image) uint64 {
+	pixels := grayscaleResize(img, 8, 8)
+
+	var sum float64
+	var hash uint64
+
+	for _, row := range pixels {
+		for _, v := range row {
+			sum += v
+		}
+	}
+
+	mean := sum / 64
+
+	for y, row := range pixels {
+		for x, v := range row {
+			if v > mean {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+
+	return hash
+}
+
+// Compute a 64-bit hash where each bit represents whether a pixel in a 9x8 grayscale
+// reduction of the image is brighter than its right-hand neighbor.
+func DifferenceHash(img image.Image) uint64 {
+	pixels := grayscaleResize(img, 9, 8)
+
+	var hash uint64
+
+	for y, row := range pixels {
+		for x := 0; x < 8; x++ {
+			if row[x] > row[x+1] {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+
+	return hash
+}
+
+// Compute a 64-bit hash from the low-frequency DCT coefficients of a 32x32 grayscale
+// reduction of the image, where each bit represents whether a coefficient is above the median.
+func PerceptualHash(img image.Image) uint64 {
+	const size = 32
+	const low = 8
+
+	pixels := grayscaleResize(img, size, size)
+	coeffs := make([][]float64, low)
+
+	for v := 0; v < low; v++ {
+		coeffs[v] = make([]float64, low)
+
+		for u := 0; u < low; u++ {
+			var sum float64
+
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += pixels[y][x] *
+						math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*size)) *
+						math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*size))
+				}
+			}
+
+			coeffs[v][u] = sum
+		}
+	}
+
+	// the median excludes the DC coefficient, which only represents overall brightness
+	values := make([]float64, 0, low*low-1)
+
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			if u != 0 || v != 0 {
+				values = append(values, coeffs[v][u])
+			}
+		}
+	}
+
+	sort.Float64s(values)
+	median := (values[len(values)/2-1] + values[len(values)/2]) / 2
+
+	var hash uint64
+
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			if coeffs[v][u] > median {
+				hash |= 1 << uint(v*low+u)
+			}
+		}
+	}
+
+	return hash
+}
+
+// Parse a hash as stored in entry metadata.
+func ParseHash(hash string) (uint64, error) {
+	return strconv.ParseUint(hash, 16, 64)
+}
+
+// Return the number of bits that differ between two hashes.
+func HammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// Reduce the image to the given dimensions in grayscale, averaging the source pixels that fall
+// within each destination pixel.  Large images are sampled on a grid of at most
+// grayscaleSamples x grayscaleSamples pixels per destination pixel.
+func grayscaleResize(img image.Image, width int, height int) [][]float64 {
+	const grayscaleSamples = 8
+
+	bounds := img.Bounds()
+	sums := make([][]float64, height)
+	counts := make([][]float64, height)
+
+	for y := 0; y < height; y++ {
+		sums[y] = make([]float64, width)
+		counts[y] = make([]float64, width)
+	}
+
+	srcW := bounds.Dx()
+	srcH := bounds.Dy()
+
+	if srcW == 0 || srcH == 0 {
+		return sums
+	}
+
+	stepX := srcW / (width * grayscaleSamples)
+	stepY := srcH / (height * grayscaleSamples)
+
+	if stepX < 1 {
+		stepX = 1
+	}
+
+	if stepY < 1 {
+		stepY = 1
+	}
+
+	for sy := 0; sy < srcH; sy += stepY {
+		dy := sy * height / srcH
+
+		for sx := 0; sx < srcW; sx += stepX {
+			dx := sx * width / srcW
+
+			sums[dy][dx] += lumaAt(img, bounds.Min.X+sx, bounds.Min.Y+sy)
+			counts[dy][dx] += 1
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if counts[y][x] > 0 {
+				sums[y][x] /= counts[y][x]
+			} else {
+				// source is smaller than the destination; sample the nearest pixel
+				sums[y][x] = lumaAt(img, bounds.Min.X+(x*srcW/width), bounds.Min.Y+(y*srcH/height))
+			}
+		}
+	}
+
+	return sums
+}
+
+func lumaAt(img image.Image, x int, y int) float64 {
+	var r, g, b uint32
+
+	// read the pixel data of common image types directly, avoiding img.At's allocations
+	switch i := img.(type) {
+	case *image.YCbCr:
+		return float64(i.Y[i.YOffset(x, y)])
+	case *image.Gray:
+		return float64(i.Pix[i.PixOffset(x, y)])
+	case *image.RGBA:
+		p := i.Pix[i.PixOffset(x, y):]
+		r, g, b = uint32(p[0])*257, uint32(p[1])*257, uint32(p[2])*257
+	case *image.NRGBA:
+		// colors are not premultiplied, so scale by alpha the same way RGBA() does
+		p := i.Pix[i.PixOffset(x, y):]
+		a := uint32(p[3]) * 257
+		r, g, b = uint32(p[0])*257*a/0xffff, uint32(p[1])*257*a/0xffff, uint32(p[2])*257*a/0xffff
+	default:
+		r, g, b, _ = img.At(x, y).RGBA()
+	}
+
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+}
@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A horizontal gradient with a bright square in the upper-left corner.
+func testHashImage(width int, height int, brightness int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := (x * 200 / width) + brightness
+
+			if x < width/4 && y < height/4 {
+				v = 255
+			}
+
+			if v > 255 {
+				v = 255
+			}
+
+			img.Set(x, y, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+		}
+	}
+
+	return img
+}
+
+func TestHammingDistance(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(0, HammingDistance(0xdeadbeef, 0xdeadbeef))
+	assert.Equal(1, HammingDistance(0, 1))
+	assert.Equal(64, HammingDistance(0, 0xffffffffffffffff))
+
+	hash, err := ParseHash(formatHash(0x00c0ffee00000001))
+	assert.NoError(err)
+	assert.Equal(uint64(0x00c0ffee00000001), hash)
+	assert.Equal(`00000000000000ff`, formatHash(0xff))
+
+	_, err = ParseHash(`not-a-hash`)
+	assert.Error(err)
+}
+
+func TestPerceptualHashes(t *testing.T) {
+	assert := require.New(t)
+
+	original := testHashImage(640, 480, 0)
+	resized := testHashImage(160, 120, 0)
+	brighter := testHashImage(640, 480, 20)
+	different := testHashImage(640, 480, 0)
+
+	// mirror the image horizontally
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			different.Set(x, y, original.At(639-x, y))
+		}
+	}
+
+	for name, fn := range map[string]func(image.Image) uint64{
+		`ahash`: AverageHash,
+		`dhash`: DifferenceHash,
+		`phash`: PerceptualHash,
+	} {
+		hash := fn(original)
+
+		assert.NotZero(hash, name)
+		assert.Equal(hash, fn(original), name)
+		assert.True(HammingDistance(hash, fn(resized)) <= 4, name)
+		assert.True(HammingDistance(hash, fn(brighter)) <= 8, name)
+		assert.True(HammingDistance(hash, fn(different)) > 16, name)
+	}
+}
+
+func TestGrayscaleResize(t *testing.T) {
+	assert := require.New(t)
+
+	gray := image.NewGray(image.Rect(0, 0, 1000, 1000))
+
+	for i := range gray.Pix {
+		gray.Pix[i] = 100
+	}
+
+	for _, row := range grayscaleResize(gray, 8, 8) {
+		for _, v := range row {
+			assert.Equal(100.0, v)
+		}
+	}
+
+	// sources smaller than the destination are sampled rather than left empty
+	tiny := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	tiny.Set(0, 0, color.NRGBA{255, 255, 255, 255})
+
+	pixels := grayscaleResize(tiny, 4, 4)
+	assert.InDelta(255.0, pixels[0][0], 0.01)
+	assert.InDelta(255.0, pixels[1][1], 0.01)
+	assert.Equal(0.0, pixels[3][3])
+
+	// images with non-zero origins (e.g.: subimages)
+	sub := testHashImage(64, 64, 0).SubImage(image.Rect(32, 32, 64, 64))
+	assert.Len(grayscaleResize(sub, 8, 8), 8)
+}
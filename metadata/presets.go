@@ -0,0 +1,385 @@
+package metadata
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+)
+
+// A named set of rules for extracting media.* fields from filenames following a common naming
+// scheme.  Parse receives the filename without its directory or extension and returns dot-separated
+// metadata keys, or nil if the name does not follow the scheme.
+type FilenamePreset struct {
+	Name  string
+	Types []string
+	Parse func(base string) map[string]interface{}
+}
+
+var FilenamePresets = map[string]*FilenamePreset{
+	`tv`: {
+		Name:  `tv`,
+		Types: []string{`video`},
+		Parse: parseTvFilename,
+	},
+	`movie`: {
+		Name:  `movie`,
+		Types: []string{`video`},
+		Parse: parseMovieFilename,
+	},
+	`music`: {
+		Name:  `music`,
+		Types: []string{`audio`},
+		Parse: parseMusicFilename,
+	},
+}
+
+// Per-group settings that loaders need to know about, keyed on the group's root path.
+type GroupProfile struct {
+	Presets         []string
+	ExtractPatterns []*ExtractPattern
+	root            string
+}
+
+// Return the given file's path relative to the group root, with a leading slash.
+func (self *GroupProfile) RelativePath(name string) string {
+	return `/` + strings.TrimPrefix(strings.TrimPrefix(filepath.Clean(name), self.root), `/`)
+}
+
+var groupProfiles = make(map[string]*GroupProfile)
+var groupProfilesLock sync.RWMutex
+
+var rxPresetSeasonEpisode = regexp.MustCompile(`(?i)^(.+?)[\s._-]+s(\d{1,2})[\s._-]?e(\d{1,3})((?:[\s._-]*e\d{1,3}|-\d{1,3})*)(?:[\s._-]+(.*))?$`)
+var rxPresetCrossEpisode = regexp.MustCompile(`(?i)^(.+?)[\s._-]+(\d{1,2})x(\d{2,3})(?:[\s._-]+(.*))?$`)
+var rxPresetAnimeEpisode = regexp.MustCompile(`^\[([^\]]+)\]\s*(.+?)\s+-\s+(\d{1,4})(?:v\d)?(?:\s+(.*))?$`)
+var rxPresetYear = regexp.MustCompile(`^[\(\[]?((?:19|20)\d{2})[\)\]]?$`)
+var rxPresetTrailingYear = regexp.MustCompile(`^(.+?)\s+\(?((?:19|20)\d{2})\)?$`)
+var rxPresetResolution = regexp.MustCompile(`^(\d{3,4})([pi])$`)
+var rxPresetCodecDots = regexp.MustCompile(`(?i)\bh\.(26[45])\b`)
+var rxPresetTokenSeparator = regexp.MustCompile(`[\s._]+`)
+var rxPresetNumbers = regexp.MustCompile(`\d+`)
+var rxMusicArtistTrack = regexp.MustCompile(`^(?:(\d{1,2})[-.])?(\d{1,3})[\s._]*[-.][\s._]*(.+?)\s+-\s+(.+)$`)
+var rxMusicTrack = regexp.MustCompile(`^(?:(\d{1,2})[-.])?(\d{1,3})(?:[\s._]*[-.][\s._]*|[\s_]+)(.+)$`)
+var rxMusicArtist = regexp.MustCompile(`^(.+?)\s+-\s+(.+)$`)
+
+var presetSources = map[string]string{
+	`bluray`:  `bluray`,
+	`blu-ray`: `bluray`,
+	`bdrip`:   `bluray`,
+	`brrip`:   `bluray`,
+	`bdremux`: `bluray`,
+	`web-dl`:  `web-dl`,
+	`webdl`:   `web-dl`,
+	`web`:     `web-dl`,
+	`webrip`:  `webrip`,
+	`hdtv`:    `hdtv`,
+	`pdtv`:    `hdtv`,
+	`dvdrip`:  `dvd`,
+	`dvdr`:    `dvd`,
+	`dvd`:     `dvd`,
+	`hdrip`:   `hdrip`,
+}
+
+var presetCodecs = map[string]string{
+	`x264`: `h264`,
+	`h264`: `h264`,
+	`avc`:  `h264`,
+	`x265`: `h265`,
+	`h265`: `h265`,
+	`hevc`: `h265`,
+	`xvid`: `xvid`,
+	`divx`: `xvid`,
+	`av1`:  `av1`,
+}
+
+// tags that mark the end of a title but aren't otherwise recorded
+var presetOtherTags = []string{
+	`10bit`, `8bit`, `aac`, `ac3`, `amzn`, `atmos`, `atvp`, `dd5`, `ddp5`, `dsnp`, `dts`, `dv`,
+	`dubbed`, `extended`, `hdr`, `hdr10`, `hmax`, `internal`, `limited`, `multi`, `nf`, `proper`,
+	`remastered`, `remux`, `repack`, `subbed`, `truehd`, `unrated`,
+}
+
+type PresetLoader struct {
+	Loader
+	presets []string
+}
+
+func (self *PresetLoader) CanHandle(name string) Loader {
+	if profile := GetGroupProfile(name); profile != nil {
+		fileType := GetGeneralFileType(name)
+		presets := make([]string, 0)
+
+		for _, presetName := range profile.Presets {
+			if preset, ok := FilenamePresets[presetName]; ok {
+				if len(preset.Types) == 0 || sliceutil.ContainsString(preset.Types, fileType) {
+					presets = append(presets, presetName)
+				}
+			}
+		}
+
+		if len(presets) > 0 {
+			return &PresetLoader{
+				presets: presets,
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self *PresetLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	return ParseFilename(name, self.presets...)
+}
+
+// Parse the given filename using the named presets, returning the fields extracted by the first
+// preset that recognizes it.
+func ParseFilename(name string, presets ...string) (map[string]interface{}, error) {
+	base := filepath.Base(name)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	for _, presetName := range presets {
+		if preset, ok := FilenamePresets[presetName]; ok {
+			if fields := preset.Parse(base); len(fields) > 0 {
+				return fields, nil
+			}
+		} else {
+			return nil, fmt.Errorf("Unknown filename preset %q", presetName)
+		}
+	}
+
+	return nil, nil
+}
+
+// Set the profile for the group rooted at the given path.  A nil profile removes it.
+func SetGroupProfile(root string, profile *GroupProfile) error {
+	if profile != nil {
+		for _, presetName := range profile.Presets {
+			if _, ok := FilenamePresets[presetName]; !ok {
+				return fmt.Errorf("Unknown filename preset %q", presetName)
+			}
+		}
+
+		for _, pattern := range profile.ExtractPatterns {
+			if err := pattern.Compile(); err != nil {
+				return err
+			}
+		}
+	}
+
+	groupProfilesLock.Lock()
+	defer groupProfilesLock.Unlock()
+
+	root = filepath.Clean(root)
+
+	if profile == nil {
+		delete(groupProfiles, root)
+	} else {
+		profile.root = root
+		groupProfiles[root] = profile
+	}
+
+	return nil
+}
+
+// Return the profile of the most specific group containing the given file, if any.
+func GetGroupProfile(name string) *GroupProfile {
+	groupProfilesLock.RLock()
+	defer groupProfilesLock.RUnlock()
+
+	name = filepath.Clean(name)
+	roots := make([]string, 0)
+
+	for root := range groupProfiles {
+		if name == root || strings.HasPrefix(name, strings.TrimSuffix(root, `/`)+`/`) {
+			roots = append(roots, root)
+		}
+	}
+
+	if len(roots) == 0 {
+		return nil
+	}
+
+	sort.Slice(roots, func(i, j int) bool {
+		return len(roots[i]) > len(roots[j])
+	})
+
+	return groupProfiles[roots[0]]
+}
+
+func parseTvFilename(base string) map[string]interface{} {
+	fields := make(map[string]interface{})
+	var show, rest string
+
+	if match := rxPresetSeasonEpisode.FindStringSubmatch(base); match != nil {
+		show = match[1]
+		fields[`media.season`] = atoi(match[2])
+		fields[`media.episode`] = atoi(match[3])
+		rest = match[5]
+
+		// multi-episode files (e.g.: "S01E01E02", "S01E01-E02", "S01E01-02")
+		if more := rxPresetNumbers.FindAllString(match[4], -1); len(more) > 0 {
+			episodes := []int{atoi(match[3])}
+
+			for _, episode := range more {
+				episodes = append(episodes, atoi(episode))
+			}
+
+			fields[`media.episodes`] = episodes
+		}
+	} else if match := rxPresetCrossEpisode.FindStringSubmatch(base); match != nil {
+		show = match[1]
+		fields[`media.season`] = atoi(match[2])
+		fields[`media.episode`] = atoi(match[3])
+		rest = match[4]
+	} else if match := rxPresetAnimeEpisode.FindStringSubmatch(base); match != nil {
+		fields[`media.release_group`] = match[1]
+		show = match[2]
+		fields[`media.episode`] = atoi(match[3])
+		rest = match[4]
+	} else {
+		return nil
+	}
+
+	show = cleanPresetTitle(show)
+
+	if match := rxPresetTrailingYear.FindStringSubmatch(show); match != nil {
+		show = match[1]
+		fields[`media.year`] = atoi(match[2])
+	}
+
+	fields[`media.show`] = show
+
+	if rest != `` {
+		tokens := presetTokens(rest)
+
+		if title := cleanPresetTitle(strings.Join(tokens[:scanReleaseTags(tokens, fields)], ` `)); title != `` {
+			fields[`media.title`] = title
+		}
+	}
+
+	return fields
+}
+
+func parseMovieFilename(base string) map[string]interface{} {
+	fields := make(map[string]interface{})
+	tokens := presetTokens(base)
+	firstTag := scanReleaseTags(tokens, fields)
+	end := firstTag
+
+	// the title ends at the last year before any release tags ("Blade Runner 2049 (2017)")
+	for i := 1; i < firstTag; i++ {
+		if match := rxPresetYear.FindStringSubmatch(tokens[i]); match != nil {
+			end = i
+			fields[`media.year`] = atoi(match[1])
+		}
+	}
+
+	if _, ok := fields[`media.year`]; !ok && firstTag == len(tokens) {
+		return nil
+	}
+
+	if title := cleanPresetTitle(strings.Join(tokens[:end], ` `)); title != `` {
+		fields[`media.title`] = title
+		return fields
+	}
+
+	return nil
+}
+
+func parseMusicFilename(base string) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	if match := rxMusicArtistTrack.FindStringSubmatch(base); match != nil {
+		if match[1] != `` {
+			fields[`media.disc`] = atoi(match[1])
+		}
+
+		fields[`media.track`] = atoi(match[2])
+		fields[`media.artist`] = strings.TrimSpace(match[3])
+		fields[`media.title`] = strings.TrimSpace(match[4])
+	} else if match := rxMusicTrack.FindStringSubmatch(base); match != nil {
+		if match[1] != `` {
+			fields[`media.disc`] = atoi(match[1])
+		}
+
+		fields[`media.track`] = atoi(match[2])
+		fields[`media.title`] = strings.TrimSpace(match[3])
+	} else if match := rxMusicArtist.FindStringSubmatch(base); match != nil {
+		fields[`media.artist`] = strings.TrimSpace(match[1])
+		fields[`media.title`] = strings.TrimSpace(match[2])
+	} else {
+		return nil
+	}
+
+	return fields
+}
+
+func presetTokens(value string) []string {
+	value = rxPresetCodecDots.ReplaceAllString(value, `h$1`)
+	return sliceutil.CompactString(rxPresetTokenSeparator.Split(value, -1))
+}
+
+// Record the resolution, source, codec and release group found in the given tokens, returning the
+// index of the first token that was recognized as a release tag.
+func scanReleaseTags(tokens []string, fields map[string]interface{}) int {
+	firstTag := len(tokens)
+
+	for i, token := range tokens {
+		tag := strings.ToLower(strings.Trim(token, `()[]`))
+
+		// the release group is appended to the last tag (e.g.: "x264-GROUP")
+		if i == len(tokens)-1 && !isReleaseTag(tag) {
+			if dash := strings.LastIndex(tag, `-`); dash > 0 && isReleaseTag(tag[:dash]) {
+				fields[`media.release_group`] = strings.Trim(token, `()[]`)[dash+1:]
+				tag = tag[:dash]
+			}
+		}
+
+		if !isReleaseTag(tag) {
+			continue
+		}
+
+		if i < firstTag {
+			firstTag = i
+		}
+
+		if match := rxPresetResolution.FindStringSubmatch(tag); match != nil {
+			fields[`media.resolution`] = match[0]
+		} else if tag == `4k` || tag == `uhd` {
+			fields[`media.resolution`] = `2160p`
+		} else if source, ok := presetSources[tag]; ok {
+			fields[`media.source`] = source
+		} else if codec, ok := presetCodecs[tag]; ok {
+			fields[`media.codec`] = codec
+		}
+	}
+
+	return firstTag
+}
+
+func isReleaseTag(tag string) bool {
+	if rxPresetResolution.MatchString(tag) || tag == `4k` || tag == `uhd` {
+		return true
+	} else if _, ok := presetSources[tag]; ok {
+		return true
+	} else if _, ok := presetCodecs[tag]; ok {
+		return true
+	}
+
+	return sliceutil.ContainsString(presetOtherTags, tag)
+}
+
+func cleanPresetTitle(value string) string {
+	value = strings.Join(strings.Fields(rxPresetTokenSeparator.ReplaceAllString(value, ` `)), ` `)
+	return strings.TrimSpace(strings.Trim(value, `-`))
+}
+
+func atoi(value string) int {
+	v, _ := strconv.Atoi(value)
+	return v
+}
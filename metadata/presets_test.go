@@ -0,0 +1,169 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilenameTv(t *testing.T) {
+	assert := require.New(t)
+
+	fields, err := ParseFilename(`/tv/Show.Name.S01E02.1080p.WEB-DL.x264-GRP.mkv`, `tv`)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`media.show`:          `Show Name`,
+		`media.season`:        1,
+		`media.episode`:       2,
+		`media.resolution`:    `1080p`,
+		`media.source`:        `web-dl`,
+		`media.codec`:         `h264`,
+		`media.release_group`: `GRP`,
+	}, fields)
+
+	fields, err = ParseFilename(`Show Name - S03E10 - The Episode Title.mkv`, `tv`)
+	assert.NoError(err)
+	assert.Equal(`Show Name`, fields[`media.show`])
+	assert.Equal(3, fields[`media.season`])
+	assert.Equal(10, fields[`media.episode`])
+	assert.Equal(`The Episode Title`, fields[`media.title`])
+
+	fields, err = ParseFilename(`doctor.who.2005.s10e01.the.pilot.720p.hdtv.h.265-grp.mkv`, `tv`)
+	assert.NoError(err)
+	assert.Equal(`doctor who`, fields[`media.show`])
+	assert.Equal(2005, fields[`media.year`])
+	assert.Equal(`the pilot`, fields[`media.title`])
+	assert.Equal(`720p`, fields[`media.resolution`])
+	assert.Equal(`hdtv`, fields[`media.source`])
+	assert.Equal(`h265`, fields[`media.codec`])
+	assert.Equal(`grp`, fields[`media.release_group`])
+
+	fields, err = ParseFilename(`Show.Name.S01E01E02.mkv`, `tv`)
+	assert.NoError(err)
+	assert.Equal(1, fields[`media.episode`])
+	assert.Equal([]int{1, 2}, fields[`media.episodes`])
+
+	fields, err = ParseFilename(`Show Name S02E05-E06 1080p.mkv`, `tv`)
+	assert.NoError(err)
+	assert.Equal([]int{5, 6}, fields[`media.episodes`])
+	assert.Equal(`1080p`, fields[`media.resolution`])
+
+	fields, err = ParseFilename(`Show_Name_4x07.avi`, `tv`)
+	assert.NoError(err)
+	assert.Equal(`Show Name`, fields[`media.show`])
+	assert.Equal(4, fields[`media.season`])
+	assert.Equal(7, fields[`media.episode`])
+
+	fields, err = ParseFilename(`[SubGroup] Show Name - 13 [1080p].mkv`, `tv`)
+	assert.NoError(err)
+	assert.Equal(`SubGroup`, fields[`media.release_group`])
+	assert.Equal(`Show Name`, fields[`media.show`])
+	assert.Equal(13, fields[`media.episode`])
+	assert.Equal(`1080p`, fields[`media.resolution`])
+
+	fields, err = ParseFilename(`Holiday Video.mp4`, `tv`)
+	assert.NoError(err)
+	assert.Nil(fields)
+}
+
+func TestParseFilenameMovie(t *testing.T) {
+	assert := require.New(t)
+
+	fields, err := ParseFilename(`Movie Title (2019).mkv`, `movie`)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`media.title`: `Movie Title`,
+		`media.year`:  2019,
+	}, fields)
+
+	fields, err = ParseFilename(`Blade.Runner.2049.2017.2160p.UHD.BluRay.x265-GRP.mkv`, `movie`)
+	assert.NoError(err)
+	assert.Equal(`Blade Runner 2049`, fields[`media.title`])
+	assert.Equal(2017, fields[`media.year`])
+	assert.Equal(`2160p`, fields[`media.resolution`])
+	assert.Equal(`bluray`, fields[`media.source`])
+	assert.Equal(`h265`, fields[`media.codec`])
+	assert.Equal(`GRP`, fields[`media.release_group`])
+
+	fields, err = ParseFilename(`2001 A Space Odyssey (1968) [1080p].mp4`, `movie`)
+	assert.NoError(err)
+	assert.Equal(`2001 A Space Odyssey`, fields[`media.title`])
+	assert.Equal(1968, fields[`media.year`])
+	assert.Equal(`1080p`, fields[`media.resolution`])
+
+	fields, err = ParseFilename(`Some.Movie.720p.WEBRip.mkv`, `movie`)
+	assert.NoError(err)
+	assert.Equal(`Some Movie`, fields[`media.title`])
+	assert.Equal(`webrip`, fields[`media.source`])
+	assert.NotContains(fields, `media.year`)
+
+	fields, err = ParseFilename(`vacation.mp4`, `movie`)
+	assert.NoError(err)
+	assert.Nil(fields)
+}
+
+func TestParseFilenameMusic(t *testing.T) {
+	assert := require.New(t)
+
+	fields, err := ParseFilename(`01 - Artist Name - Song Title.flac`, `music`)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`media.track`:  1,
+		`media.artist`: `Artist Name`,
+		`media.title`:  `Song Title`,
+	}, fields)
+
+	fields, err = ParseFilename(`2-05 - Artist - Title.mp3`, `music`)
+	assert.NoError(err)
+	assert.Equal(2, fields[`media.disc`])
+	assert.Equal(5, fields[`media.track`])
+
+	fields, err = ParseFilename(`07. Song Title.mp3`, `music`)
+	assert.NoError(err)
+	assert.Equal(7, fields[`media.track`])
+	assert.Equal(`Song Title`, fields[`media.title`])
+	assert.NotContains(fields, `media.artist`)
+
+	fields, err = ParseFilename(`Artist Name - Song Title.ogg`, `music`)
+	assert.NoError(err)
+	assert.Equal(`Artist Name`, fields[`media.artist`])
+	assert.Equal(`Song Title`, fields[`media.title`])
+}
+
+func TestParseFilenamePresetOrder(t *testing.T) {
+	assert := require.New(t)
+
+	fields, err := ParseFilename(`Show.Name.2010.S01E02.mkv`, `tv`, `movie`)
+	assert.NoError(err)
+	assert.Equal(`Show Name`, fields[`media.show`])
+
+	fields, err = ParseFilename(`Movie.Name.2010.mkv`, `tv`, `movie`)
+	assert.NoError(err)
+	assert.Equal(`Movie Name`, fields[`media.title`])
+
+	_, err = ParseFilename(`Movie.Name.2010.mkv`, `nope`)
+	assert.Error(err)
+}
+
+func TestGroupProfile(t *testing.T) {
+	assert := require.New(t)
+
+	defer SetGroupProfile(`/media/tv`, nil)
+	defer SetGroupProfile(`/media/tv/anime`, nil)
+
+	assert.Error(SetGroupProfile(`/media/tv`, &GroupProfile{
+		Presets: []string{`nope`},
+	}))
+
+	assert.NoError(SetGroupProfile(`/media/tv`, &GroupProfile{
+		Presets: []string{`tv`},
+	}))
+
+	assert.NoError(SetGroupProfile(`/media/tv/anime/`, &GroupProfile{
+		Presets: []string{`tv`, `movie`},
+	}))
+
+	assert.Nil(GetGroupProfile(`/media/tvshows/a.mkv`))
+	assert.Equal([]string{`tv`}, GetGroupProfile(`/media/tv/Show/a.mkv`).Presets)
+	assert.Equal([]string{`tv`, `movie`}, GetGroupProfile(`/media/tv/anime/a.mkv`).Presets)
+}
@@ -1,14 +1,156 @@
 package metadata
 
 import (
+	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ghetzel/go-stockutil/sliceutil"
 	"github.com/ghetzel/go-stockutil/stringutil"
 )
 
+// Patterns applied to the absolute path of every file (see DB.ExtractFields).
 var RegexpPatterns []*regexp.Regexp
 
+// Layouts tried (in order) when converting captures with the "date" type hint.
+var ExtractDateLayouts = []string{
+	time.RFC3339,
+	`2006-01-02 15:04:05`,
+	`2006-01-02`,
+	`2006.01.02`,
+	`2006_01_02`,
+	`20060102`,
+	`2006-01`,
+	`2006`,
+}
+
+// A regular expression whose named capture groups are extracted as metadata fields from paths
+// relative to a group's root.  Double underscores in group names are converted to dots (e.g.:
+// "media__year" becomes "media.year").  Types maps capture names to one of the type hints "str",
+// "int", "float", "bool", "date" or "list"; captures without a hint are autotyped.  The date hint
+// accepts an explicit layout ("date:2006.01.02") and the list hint a separator ("list:;", the
+// default being a comma).
+type ExtractPattern struct {
+	Pattern string            `json:"pattern"`
+	Types   map[string]string `json:"types,omitempty"`
+	rx      *regexp.Regexp
+}
+
+func (self *ExtractPattern) Compile() error {
+	if rx, err := regexp.Compile(self.Pattern); err == nil {
+		for field, hint := range self.Types {
+			if !sliceutil.ContainsString(rx.SubexpNames(), field) {
+				return fmt.Errorf("Pattern %q has no capture group named %q", self.Pattern, field)
+			}
+
+			switch kind, _ := splitTypeHint(hint); kind {
+			case `str`, `int`, `float`, `bool`, `date`, `list`:
+				continue
+			default:
+				return fmt.Errorf("Unsupported type %q for capture %q", hint, field)
+			}
+		}
+
+		self.rx = rx
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (self *ExtractPattern) MatchString(name string) bool {
+	return self.rx != nil && self.rx.MatchString(name)
+}
+
+// Extract the fields captured from the given name.  Captures that cannot be converted to their
+// hinted type are omitted.
+func (self *ExtractPattern) Extract(name string) map[string]interface{} {
+	if self.rx == nil {
+		return nil
+	}
+
+	if match := self.rx.FindStringSubmatch(name); len(match) > 0 {
+		fields := make(map[string]interface{})
+
+		for i, fieldName := range self.rx.SubexpNames() {
+			if i > 0 && fieldName != `` && match[i] != `` {
+				if value, err := convertExtractedValue(match[i], self.Types[fieldName]); err == nil {
+					fields[strings.Replace(fieldName, `__`, `.`, -1)] = value
+				}
+			}
+		}
+
+		return fields
+	}
+
+	return nil
+}
+
+func splitTypeHint(hint string) (string, string) {
+	if parts := strings.SplitN(hint, `:`, 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	} else {
+		return hint, ``
+	}
+}
+
+func convertExtractedValue(value string, hint string) (interface{}, error) {
+	kind, arg := splitTypeHint(hint)
+
+	switch kind {
+	case ``:
+		return stringutil.Autotype(value), nil
+	case `str`:
+		return value, nil
+	case `int`:
+		// same type as autotyped integers
+		return strconv.Atoi(value)
+	case `float`:
+		return strconv.ParseFloat(value, 64)
+	case `bool`:
+		switch strings.ToLower(value) {
+		case `yes`, `y`, `on`:
+			return true, nil
+		case `no`, `n`, `off`:
+			return false, nil
+		default:
+			return strconv.ParseBool(value)
+		}
+	case `date`:
+		layouts := ExtractDateLayouts
+
+		if arg != `` {
+			layouts = []string{arg}
+		}
+
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, nil
+			}
+		}
+
+		return nil, fmt.Errorf("Cannot parse %q as a date", value)
+	case `list`:
+		if arg == `` {
+			arg = `,`
+		}
+
+		values := make([]string, 0)
+
+		for _, item := range strings.Split(value, arg) {
+			if item = strings.TrimSpace(item); item != `` {
+				values = append(values, item)
+			}
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("Unsupported type %q", hint)
+	}
+}
+
 type RegexLoader struct {
 	Loader
 }
@@ -20,6 +162,16 @@ func (self *RegexLoader) CanHandle(name string) Loader {
 		}
 	}
 
+	if profile := GetGroupProfile(name); profile != nil {
+		relPath := profile.RelativePath(name)
+
+		for _, pattern := range profile.ExtractPatterns {
+			if pattern.MatchString(relPath) {
+				return self
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -36,5 +188,16 @@ func (self *RegexLoader) LoadMetadata(name string) (map[string]interface{}, erro
 		}
 	}
 
+	// group patterns take precedence over the global ones
+	if profile := GetGroupProfile(name); profile != nil {
+		relPath := profile.RelativePath(name)
+
+		for _, pattern := range profile.ExtractPatterns {
+			for key, value := range pattern.Extract(relPath) {
+				metadata[key] = value
+			}
+		}
+	}
+
 	return metadata, nil
 }
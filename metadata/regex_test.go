@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertExtractedValue(t *testing.T) {
+	assert := require.New(t)
+
+	for _, tc := range []struct {
+		value    string
+		hint     string
+		expected interface{}
+		err      bool
+	}{
+		{`2004`, ``, 2004, false},
+		{`1.5`, ``, 1.5, false},
+		{`true`, ``, true, false},
+		{`Title`, ``, `Title`, false},
+		{`2004`, `str`, `2004`, false},
+		{`007`, `int`, 7, false},
+		{`7a`, `int`, nil, true},
+		{`2.50`, `float`, 2.5, false},
+		{`two`, `float`, nil, true},
+		{`Yes`, `bool`, true, false},
+		{`off`, `bool`, false, false},
+		{`1`, `bool`, true, false},
+		{`maybe`, `bool`, nil, true},
+		{`2004-05-06`, `date`, time.Date(2004, 5, 6, 0, 0, 0, 0, time.UTC), false},
+		{`20040506`, `date`, time.Date(2004, 5, 6, 0, 0, 0, 0, time.UTC), false},
+		{`2004`, `date`, time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{`06.05.2004`, `date:02.01.2006`, time.Date(2004, 5, 6, 0, 0, 0, 0, time.UTC), false},
+		{`2004-05-06`, `date:02.01.2006`, nil, true},
+		{`yesterday`, `date`, nil, true},
+		{`a, b,,c`, `list`, []string{`a`, `b`, `c`}, false},
+		{`a;b, c`, `list:;`, []string{`a`, `b, c`}, false},
+		{`x`, `nope`, nil, true},
+	} {
+		value, err := convertExtractedValue(tc.value, tc.hint)
+
+		if tc.err {
+			assert.Error(err, "%q as %q", tc.value, tc.hint)
+		} else {
+			assert.NoError(err, "%q as %q", tc.value, tc.hint)
+			assert.Equal(tc.expected, value, "%q as %q", tc.value, tc.hint)
+		}
+	}
+}
+
+func TestExtractPattern(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Error((&ExtractPattern{Pattern: `(`}).Compile())
+	assert.Error((&ExtractPattern{Pattern: `(?P<year>\d+)`, Types: map[string]string{`month`: `int`}}).Compile())
+	assert.Error((&ExtractPattern{Pattern: `(?P<year>\d+)`, Types: map[string]string{`year`: `decimal`}}).Compile())
+
+	// not compiled yet
+	assert.Nil((&ExtractPattern{Pattern: `.*`}).Extract(`a`))
+
+	pattern := &ExtractPattern{
+		Pattern: `^(?P<media__artist>[^/]+)/(?P<media__year>\d{4})(-(?P<media__disc>\w+))? - (?P<media__album>[^/]+)/(?P<media__tags>[^/]+)/`,
+		Types: map[string]string{
+			`media__year`: `date`,
+			`media__disc`: `int`,
+			`media__tags`: `list:+`,
+		},
+	}
+
+	assert.NoError(pattern.Compile())
+
+	for name, expected := range map[string]map[string]interface{}{
+		`Artist/2004 - Album/rock+live/01.flac`: {
+			`media.artist`: `Artist`,
+			`media.year`:   time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC),
+			`media.album`:  `Album`,
+			`media.tags`:   []string{`rock`, `live`},
+		},
+		`Artist/2004-2 - 1999/a/01.flac`: {
+			`media.artist`: `Artist`,
+			`media.year`:   time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC),
+			`media.disc`:   2,
+			`media.album`:  1999,
+			`media.tags`:   []string{`a`},
+		},
+
+		// captures that can't be converted are omitted
+		`Artist/2004-B - Album/a/01.flac`: {
+			`media.artist`: `Artist`,
+			`media.year`:   time.Date(2004, 1, 1, 0, 0, 0, 0, time.UTC),
+			`media.album`:  `Album`,
+			`media.tags`:   []string{`a`},
+		},
+		`Artist/01.flac`: nil,
+	} {
+		assert.Equal(pattern.MatchString(name), expected != nil, name)
+		assert.Equal(expected, pattern.Extract(name), name)
+	}
+}
@@ -0,0 +1,239 @@
+package metadata
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/stringutil"
+)
+
+// The pass in which checksums are generated.  If zero, the highest registered pass is used.
+var ChecksumPass = 0
+
+// The pass after which an entry's metadata is considered complete.  If zero, the highest
+// registered pass is used.
+var FinalizePass = 0
+
+type LoaderRegistration struct {
+	Name    string
+	Pass    int
+	Enabled bool
+	Loader  Loader
+
+	// How long the loader may run on a single file; zero uses DefaultLoaderTimeout.
+	Timeout time.Duration
+
+	// The metadata keys (or key prefixes) this loader reads and writes.  Loaders run after all
+	// loaders providing the keys they require, in a later pass if necessary.
+	Requires []string
+	Provides []string
+}
+
+var loaderRegistry = make([]*LoaderRegistration, 0)
+var loaderRegistryLock sync.RWMutex
+
+// incremented whenever the registry changes, invalidating the loader set built by GetLoaders
+var loaderRegistryVersion int
+
+func init() {
+	RegisterLoader(`file`, 1, &FileLoader{})
+	RegisterLoader(`presets`, 1, &PresetLoader{})
+	RegisterLoader(`media`, 1, &MediaLoader{})
+
+	// results are merged in registration order, so explicit extract patterns are registered after
+	// (and take precedence over) values inferred by presets and from filenames
+	RegisterLoader(`regex`, 1, &RegexLoader{})
+	RegisterLoader(`sidecar`, 1, &SidecarLoader{})
+	RegisterLoader(`xattr`, 1, &XattrLoader{})
+	RegisterLoader(`audio`, 2, &AudioLoader{})
+	RegisterLoader(`video`, 2, &VideoLoader{})
+	RegisterLoader(`subtitles`, 2, &SubtitleLoader{})
+	RegisterLoader(`image`, 2, &ImageLoader{})
+	RegisterLoader(`document`, 2, &DocumentLoader{})
+	RegisterLoader(`archive`, 2, &ArchiveLoader{})
+	RegisterLoader(`text`, 2, &TextLoader{})
+
+	// perceptual hashing decodes the entire image, so it must be explicitly enabled
+	RegisterLoader(`perceptual_hash`, 2, &PerceptualHashLoader{})
+	DisableLoader(`perceptual_hash`)
+
+	// the metadata namespaces populated by the built-in loaders
+	SetLoaderDependencies(`file`, nil, []string{`file`})
+	SetLoaderDependencies(`regex`, nil, []string{`media`})
+	SetLoaderDependencies(`presets`, nil, []string{`media`})
+	SetLoaderDependencies(`media`, nil, []string{`media`})
+	SetLoaderDependencies(`sidecar`, nil, SidecarProvides())
+	SetLoaderDependencies(`xattr`, nil, []string{`xattr`})
+	SetLoaderDependencies(`subtitles`, (&SubtitleLoader{}).Requires(), (&SubtitleLoader{}).Replaces())
+	SetLoaderDependencies(`audio`, nil, []string{`media`})
+	SetLoaderDependencies(`video`, nil, []string{`media`, `video`})
+	SetLoaderDependencies(`image`, nil, []string{`image`})
+	SetLoaderDependencies(`document`, nil, []string{`document`})
+	SetLoaderDependencies(`archive`, nil, []string{`archive`})
+	SetLoaderDependencies(`text`, nil, []string{`text`})
+	SetLoaderDependencies(`perceptual_hash`, nil, []string{`image.hash`})
+}
+
+// Register a loader under the given name to run during the given pass.  Loaders within a pass
+// run in the order they were registered, unless one depends on the output of another.
+func RegisterLoader(name string, pass int, loader Loader) error {
+	if name == `` {
+		return fmt.Errorf("Loader name cannot be empty")
+	} else if pass < 1 {
+		return fmt.Errorf("Loader pass must be greater than zero")
+	} else if loader == nil {
+		return fmt.Errorf("Loader cannot be nil")
+	}
+
+	loaderRegistryLock.Lock()
+	defer loaderRegistryLock.Unlock()
+
+	for _, registration := range loaderRegistry {
+		if registration.Name == name {
+			return fmt.Errorf("A loader named %q is already registered", name)
+		}
+	}
+
+	registration := &LoaderRegistration{
+		Name:    name,
+		Pass:    pass,
+		Enabled: true,
+		Loader:  loader,
+	}
+
+	if dependent, ok := loader.(DependentLoader); ok {
+		registration.Requires = dependent.Requires()
+	}
+
+	if err := checkLoaderDependencies(append(loaderRegistry, registration)); err != nil {
+		return err
+	}
+
+	loaderRegistry = append(loaderRegistry, registration)
+	loaderRegistryVersion += 1
+
+	return nil
+}
+
+// Remove the named loader from the registry.
+func UnregisterLoader(name string) error {
+	loaderRegistryLock.Lock()
+	defer loaderRegistryLock.Unlock()
+
+	for i, registration := range loaderRegistry {
+		if registration.Name == name {
+			loaderRegistry = append(loaderRegistry[:i], loaderRegistry[i+1:]...)
+			loaderRegistryVersion += 1
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No loader named %q is registered", name)
+}
+
+func EnableLoader(name string) error {
+	return setLoaderEnabled(name, true)
+}
+
+func DisableLoader(name string) error {
+	return setLoaderEnabled(name, false)
+}
+
+func setLoaderEnabled(name string, enabled bool) error {
+	loaderRegistryLock.Lock()
+	defer loaderRegistryLock.Unlock()
+
+	for _, registration := range loaderRegistry {
+		if registration.Name == name {
+			registration.Enabled = enabled
+			loaderRegistryVersion += 1
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No loader named %q is registered", name)
+}
+
+// Set how long the named loader may run on a single file.  A timeout of zero uses DefaultLoaderTimeout.
+func SetLoaderTimeout(name string, timeout time.Duration) error {
+	loaderRegistryLock.Lock()
+	defer loaderRegistryLock.Unlock()
+
+	for _, registration := range loaderRegistry {
+		if registration.Name == name {
+			registration.Timeout = timeout
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No loader named %q is registered", name)
+}
+
+// Declare the metadata keys the named loader reads and writes.
+func SetLoaderDependencies(name string, requires []string, provides []string) error {
+	loaderRegistryLock.Lock()
+	defer loaderRegistryLock.Unlock()
+
+	for _, registration := range loaderRegistry {
+		if registration.Name == name {
+			previousRequires, previousProvides := registration.Requires, registration.Provides
+			registration.Requires = requires
+			registration.Provides = provides
+
+			if err := checkLoaderDependencies(loaderRegistry); err != nil {
+				registration.Requires = previousRequires
+				registration.Provides = previousProvides
+				return err
+			}
+
+			loaderRegistryVersion += 1
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No loader named %q is registered", name)
+}
+
+// Return a copy of the named loader's registration.
+func GetLoaderRegistration(name string) (LoaderRegistration, bool) {
+	loaderRegistryLock.RLock()
+	defer loaderRegistryLock.RUnlock()
+
+	for _, registration := range loaderRegistry {
+		if registration.Name == name {
+			return *registration, true
+		}
+	}
+
+	return LoaderRegistration{}, false
+}
+
+// Return a copy of all registered loaders, in registration order.
+func ListLoaders() []LoaderRegistration {
+	loaderRegistryLock.RLock()
+	defer loaderRegistryLock.RUnlock()
+
+	registrations := make([]LoaderRegistration, len(loaderRegistry))
+
+	for i, registration := range loaderRegistry {
+		registrations[i] = *registration
+	}
+
+	return registrations
+}
+
+// Return the name the given loader was registered under (see NamedLoader).  Other loaders are
+// named after their type (e.g.: *metadata.PerceptualHashLoader -> "perceptual_hash").
+func LoaderName(loader Loader) string {
+	if named, ok := loader.(*NamedLoader); ok {
+		return named.Name
+	}
+
+	name := fmt.Sprintf("%T", loader)
+	name = name[strings.LastIndex(name, `.`)+1:]
+	name = strings.TrimSuffix(name, `Loader`)
+
+	return stringutil.Underscore(name)
+}
@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testRegistryLoader struct {
+	Loader
+}
+
+func testLoaderNames(loaders []Loader) []string {
+	names := make([]string, len(loaders))
+
+	for i, loader := range loaders {
+		names[i] = LoaderName(loader)
+	}
+
+	return names
+}
+
+func testPassLoaderNames(pass int) []string {
+	if group := GetLoaderGroupForPass(pass); group != nil {
+		return testLoaderNames(group.Loaders)
+	}
+
+	return nil
+}
+
+func TestLoaderMergeOrder(t *testing.T) {
+	assert := require.New(t)
+
+	names := testPassLoaderNames(1)
+	index := func(name string) int {
+		for i, n := range names {
+			if n == name {
+				return i
+			}
+		}
+
+		return -1
+	}
+
+	// explicit extract patterns are merged last, overriding presets and values parsed from filenames
+	assert.True(index(`presets`) >= 0)
+	assert.True(index(`media`) >= 0)
+	assert.True(index(`regex`) > index(`presets`))
+	assert.True(index(`regex`) > index(`media`))
+
+	registration, ok := GetLoaderRegistration(`regex`)
+	assert.True(ok)
+	assert.Equal([]string{`media`}, registration.Provides)
+}
+
+func TestGetLoadersCache(t *testing.T) {
+	assert := require.New(t)
+
+	first := GetLoaders()
+	second := GetLoaders()
+	assert.Equal(len(first), len(second))
+	assert.True(&first[0] == &second[0], "the loader set should be reused")
+	assert.NotContains(testPassLoaderNames(2), `test_registry`)
+
+	// every change to the registry invalidates the set
+	assert.NoError(RegisterLoader(`test_registry`, 2, &testRegistryLoader{}))
+	assert.Contains(testPassLoaderNames(2), `test_registry`)
+
+	assert.NoError(DisableLoader(`test_registry`))
+	assert.NotContains(testPassLoaderNames(2), `test_registry`)
+
+	assert.NoError(EnableLoader(`test_registry`))
+	assert.Contains(testPassLoaderNames(2), `test_registry`)
+
+	// requiring the output of a pass 3 loader moves it into pass 3
+	assert.NoError(RegisterLoader(`test_registry_late`, 3, &testPanicLoader{}))
+	assert.NoError(SetLoaderDependencies(`test_registry_late`, nil, []string{`test.late`}))
+	assert.NoError(SetLoaderDependencies(`test_registry`, []string{`test.late`}, nil))
+	assert.NotContains(testPassLoaderNames(2), `test_registry`)
+	assert.Equal([]string{`test_registry_late`, `test_registry`}, testPassLoaderNames(3))
+
+	assert.NoError(UnregisterLoader(`test_registry_late`))
+	assert.NoError(UnregisterLoader(`test_registry`))
+	assert.NotContains(testPassLoaderNames(2), `test_registry`)
+	assert.Nil(GetLoaderGroupForPass(3))
+
+	// the checksum and finalize passes are applied to the cached set
+	defer func() {
+		ChecksumPass = 0
+	}()
+
+	ChecksumPass = 1
+	assert.Equal(1, GetChecksumPass())
+
+	ChecksumPass = 0
+	assert.Equal(2, GetChecksumPass())
+}
+
+func TestLoaderRegistration(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Error(RegisterLoader(``, 1, &testPanicLoader{}))
+	assert.Error(RegisterLoader(`test_a`, 0, &testPanicLoader{}))
+	assert.Error(RegisterLoader(`test_a`, 1, nil))
+	assert.Error(RegisterLoader(`file`, 1, &testPanicLoader{}))
+	assert.Error(UnregisterLoader(`test_a`))
+	assert.Error(EnableLoader(`test_a`))
+	assert.Error(SetLoaderTimeout(`test_a`, time.Second))
+
+	// two registrations sharing a type keep their own names and settings
+	assert.NoError(RegisterLoader(`test_a`, 3, &testPanicLoader{}))
+	assert.NoError(RegisterLoader(`test_b`, 3, &testPanicLoader{}))
+	defer UnregisterLoader(`test_a`)
+	defer UnregisterLoader(`test_b`)
+
+	assert.NoError(SetLoaderTimeout(`test_b`, time.Minute))
+	assert.Equal(DefaultLoaderTimeout, LoaderTimeout(`test_a`))
+	assert.Equal(time.Minute, LoaderTimeout(`test_b`))
+
+	registration, ok := GetLoaderRegistration(`test_b`)
+	assert.True(ok)
+	assert.Equal(3, registration.Pass)
+	assert.True(registration.Enabled)
+
+	loaders := GetLoadersForFile(`error`, 3)
+	assert.Equal([]string{`test_a`, `test_b`}, testLoaderNames(loaders))
+
+	for _, loader := range loaders {
+		_, err := RunLoader(loader, `error`, nil)
+		assert.Error(err)
+		assert.Equal(LoaderName(loader), err.(*LoaderError).Loader)
+	}
+
+	// unregistered loaders are named after their type
+	assert.Equal(`test_panic`, LoaderName(&testPanicLoader{}))
+	assert.Equal(`test_b`, LoaderName(CanHandleFile(loaders[1], `ok`)))
+}
@@ -0,0 +1,420 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/maputil"
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/stringutil"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SidecarFormatJSON = `json`
+	SidecarFormatYAML = `yaml`
+)
+
+const (
+	SidecarTransformRename   = `rename`
+	SidecarTransformMultiply = `multiply`
+	SidecarTransformDate     = `date`
+)
+
+// The layout dates are written in when a mapping does not specify one.
+var SidecarDateOutputLayout = `2006-01-02`
+
+// Fields of youtube-dl info files that are too large or volatile to be worth storing.
+var DefaultExcludeFields = []string{
+	`_filename`,
+	`description`,
+	`dislike_count`,
+	`formats`,
+	`http_headers`,
+	`like_count`,
+	`thumbnails`,
+	`url`,
+	`view_count`,
+}
+
+var DefaultSidecarRules = []SidecarRule{
+	{
+		Name:      `ytdl`,
+		Patterns:  []string{`{base}.info.json`},
+		Format:    SidecarFormatJSON,
+		Namespace: `ytdl`,
+		Mappings: []SidecarMapping{
+			{To: `media.type`, Value: `web`},
+			{From: `title`, To: `media.title`},
+			{From: `description`, To: `media.description`},
+			{From: `duration`, To: `media.duration`, Transform: SidecarTransformMultiply, Factor: 1000},
+			{From: `upload_date`, To: `media.aired`, Transform: SidecarTransformDate, Layout: `20060102`},
+			{From: `average_rating`, To: `media.rating`},
+			{From: `thumbnail`, To: `media.thumbnail`},
+		},
+		ExcludeFields: DefaultExcludeFields,
+	},
+}
+
+// Rules describing structured sidecar files to load metadata from.
+var SidecarRules = DefaultSidecarRules
+
+// Copies a field from a sidecar file into the entry's metadata.  From and To are dot-separated
+// paths; if From is empty, Value is used as-is.  The "rename" transform also removes the field
+// from the rule's namespace, "multiply" scales numbers by Factor, and "date" parses the value using
+// Layout (or ExtractDateLayouts) and writes it out using OutputLayout (as an integer if the result
+// is numeric, e.g.: for a layout of "2006").
+type SidecarMapping struct {
+	From         string      `json:"from,omitempty"`
+	To           string      `json:"to"`
+	Value        interface{} `json:"value,omitempty"`
+	Transform    string      `json:"transform,omitempty"`
+	Factor       float64     `json:"factor,omitempty"`
+	Layout       string      `json:"layout,omitempty"`
+	OutputLayout string      `json:"output_layout,omitempty"`
+}
+
+// A SidecarRule loads a JSON or YAML file found alongside the file being scanned.  Patterns are
+// globs relative to the file's directory, in which "{base}" is replaced with the filename without
+// its extension and "{name}" with the full filename.  The sidecar's contents (minus ExcludeFields)
+// are stored under Namespace, which defaults to Name and can be set to "-" to only keep the
+// mapped fields.
+type SidecarRule struct {
+	Name          string           `json:"name"`
+	Patterns      []string         `json:"patterns"`
+	Format        string           `json:"format,omitempty"`
+	Namespace     string           `json:"namespace,omitempty"`
+	Mappings      []SidecarMapping `json:"mappings,omitempty"`
+	ExcludeFields []string         `json:"exclude_fields,omitempty"`
+}
+
+func (self *SidecarRule) Validate() error {
+	if self.Name == `` {
+		return fmt.Errorf("Sidecar rule must have a name")
+	}
+
+	if len(self.Patterns) == 0 {
+		return fmt.Errorf("Sidecar rule %q: must specify at least one pattern", self.Name)
+	}
+
+	for _, pattern := range self.Patterns {
+		if _, err := filepath.Match(ExpandFilenamePattern(pattern, `x.y`), ``); err != nil {
+			return fmt.Errorf("Sidecar rule %q: invalid pattern %q: %v", self.Name, pattern, err)
+		}
+	}
+
+	switch self.Format {
+	case ``, SidecarFormatJSON, SidecarFormatYAML:
+		break
+	default:
+		return fmt.Errorf("Sidecar rule %q: unsupported format %q", self.Name, self.Format)
+	}
+
+	if self.Namespace == `` {
+		self.Namespace = self.Name
+	}
+
+	for _, mapping := range self.Mappings {
+		if mapping.To == `` {
+			return fmt.Errorf("Sidecar rule %q: mappings must specify a destination field", self.Name)
+		}
+
+		switch mapping.Transform {
+		case ``, SidecarTransformRename, SidecarTransformDate:
+			break
+		case SidecarTransformMultiply:
+			if mapping.Factor == 0 {
+				return fmt.Errorf("Sidecar rule %q: mapping for %q must specify a factor", self.Name, mapping.To)
+			}
+		default:
+			return fmt.Errorf("Sidecar rule %q: unsupported transform %q", self.Name, mapping.Transform)
+		}
+	}
+
+	return nil
+}
+
+// Return the sidecar files that exist for the named file.
+func (self *SidecarRule) Files(name string) []string {
+	files := make([]string, 0)
+	dir, filename := filepath.Split(name)
+
+	for _, pattern := range self.Patterns {
+		if matches, err := filepath.Glob(filepath.Join(dir, ExpandFilenamePattern(pattern, filename))); err == nil {
+			for _, match := range matches {
+				if match != name && !sliceutil.ContainsString(files, match) {
+					files = append(files, match)
+				}
+			}
+		}
+	}
+
+	sort.Strings(files)
+
+	return files
+}
+
+// Load the given sidecar file and apply the rule's mappings to it.
+func (self *SidecarRule) Load(sidecar string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(sidecar)
+
+	if err != nil {
+		return nil, err
+	}
+
+	format := self.Format
+
+	if format == `` {
+		switch strings.ToLower(filepath.Ext(sidecar)) {
+		case `.yml`, `.yaml`:
+			format = SidecarFormatYAML
+		default:
+			format = SidecarFormatJSON
+		}
+	}
+
+	var raw map[string]interface{}
+
+	switch format {
+	case SidecarFormatYAML:
+		var document map[interface{}]interface{}
+
+		if err := yaml.Unmarshal(data, &document); err == nil {
+			raw, _ = stringifyYamlKeys(document).(map[string]interface{})
+		} else {
+			return nil, fmt.Errorf("Unrecognized %s sidecar format at %q: %v", self.Name, sidecar, err)
+		}
+	default:
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("Unrecognized %s sidecar format at %q: %v", self.Name, sidecar, err)
+		}
+	}
+
+	output := make(map[string]interface{})
+	removeFields := append([]string{}, self.ExcludeFields...)
+
+	for _, mapping := range self.Mappings {
+		if value, ok := mapping.apply(raw); ok {
+			output[mapping.To] = value
+		}
+
+		if mapping.Transform == SidecarTransformRename && mapping.From != `` {
+			removeFields = append(removeFields, mapping.From)
+		}
+	}
+
+	if namespace := self.namespace(); namespace != `-` {
+		for _, field := range removeFields {
+			maputil.DeepSet(raw, strings.Split(field, `.`), nil)
+		}
+
+		if v, err := maputil.Compact(raw); err == nil {
+			raw = v
+		}
+
+		output[namespace] = raw
+	}
+
+	return output, nil
+}
+
+func (self *SidecarRule) namespace() string {
+	if self.Namespace == `` {
+		return self.Name
+	}
+
+	return self.Namespace
+}
+
+// The top-level metadata keys written by this rule.
+func (self *SidecarRule) Provides() []string {
+	provides := make([]string, 0)
+
+	if namespace := self.namespace(); namespace != `-` {
+		provides = append(provides, namespace)
+	}
+
+	for _, mapping := range self.Mappings {
+		if key := strings.Split(mapping.To, `.`)[0]; !sliceutil.ContainsString(provides, key) {
+			provides = append(provides, key)
+		}
+	}
+
+	return provides
+}
+
+func (self *SidecarMapping) apply(data map[string]interface{}) (interface{}, bool) {
+	if self.From == `` {
+		return self.Value, self.Value != nil
+	}
+
+	value := maputil.DeepGet(data, strings.Split(self.From, `.`), nil)
+
+	if value == nil {
+		return nil, false
+	}
+
+	switch self.Transform {
+	case SidecarTransformMultiply:
+		if number, err := stringutil.ConvertToFloat(value); err == nil {
+			number = number * self.Factor
+
+			if number == math.Trunc(number) {
+				return int64(number), true
+			}
+
+			return number, true
+		} else {
+			return nil, false
+		}
+
+	case SidecarTransformDate:
+		var date string
+
+		// numeric dates (e.g.: 20190102) are decoded from JSON as floats
+		if number, ok := value.(float64); ok {
+			date = strconv.FormatFloat(number, 'f', -1, 64)
+		} else {
+			date = fmt.Sprintf("%v", value)
+		}
+
+		layouts := ExtractDateLayouts
+		outputLayout := SidecarDateOutputLayout
+
+		if self.Layout != `` {
+			layouts = []string{self.Layout}
+		}
+
+		if self.OutputLayout != `` {
+			outputLayout = self.OutputLayout
+		}
+
+		for _, layout := range layouts {
+			if t, err := time.Parse(layout, date); err == nil {
+				formatted := t.Format(outputLayout)
+
+				// numeric outputs (e.g.: years) are stored as numbers, like other loaders do
+				if number, err := strconv.Atoi(formatted); err == nil {
+					return number, true
+				}
+
+				return formatted, true
+			}
+		}
+
+		return nil, false
+	}
+
+	return value, true
+}
+
+// Expand the "{base}" (filename without its extension) and "{name}" (full filename) placeholders
+// of a glob pattern, escaping any glob characters in the filename so they are matched literally.
+func ExpandFilenamePattern(pattern string, filename string) string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	pattern = strings.Replace(pattern, `{base}`, escapeGlob(base), -1)
+	pattern = strings.Replace(pattern, `{name}`, escapeGlob(filename), -1)
+
+	return pattern
+}
+
+func escapeGlob(value string) string {
+	for _, c := range []string{`\`, `*`, `?`, `[`} {
+		value = strings.Replace(value, c, `\`+c, -1)
+	}
+
+	return value
+}
+
+// YAML decodes nested mappings with interface{} keys, which can't be stored as metadata.
+func stringifyYamlKeys(value interface{}) interface{} {
+	switch value.(type) {
+	case map[interface{}]interface{}:
+		rv := make(map[string]interface{})
+
+		for k, v := range value.(map[interface{}]interface{}) {
+			rv[fmt.Sprintf("%v", k)] = stringifyYamlKeys(v)
+		}
+
+		return rv
+	case []interface{}:
+		rv := make([]interface{}, 0)
+
+		for _, v := range value.([]interface{}) {
+			rv = append(rv, stringifyYamlKeys(v))
+		}
+
+		return rv
+	default:
+		return value
+	}
+}
+
+type SidecarLoader struct {
+	Loader
+	rules []SidecarRule
+	files [][]string
+}
+
+func (self *SidecarLoader) CanHandle(name string) Loader {
+	loader := &SidecarLoader{}
+
+	for _, rule := range SidecarRules {
+		if files := rule.Files(name); len(files) > 0 {
+			loader.rules = append(loader.rules, rule)
+			loader.files = append(loader.files, files)
+		}
+	}
+
+	if len(loader.rules) > 0 {
+		return loader
+	}
+
+	return nil
+}
+
+func (self *SidecarLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{})
+	var errs []string
+
+	for i, rule := range self.rules {
+		for _, sidecar := range self.files[i] {
+			if data, err := rule.Load(sidecar); err == nil {
+				for k, v := range data {
+					metadata[k] = v
+				}
+			} else {
+				errs = append(errs, fmt.Sprintf("%s: %v", rule.Name, err))
+			}
+		}
+	}
+
+	if len(metadata) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, `; `))
+	}
+
+	return metadata, nil
+}
+
+// The top-level metadata keys written by the current sidecar rules.
+func SidecarProvides() []string {
+	provides := make([]string, 0)
+
+	for _, rule := range SidecarRules {
+		for _, key := range rule.Provides() {
+			if !sliceutil.ContainsString(provides, key) {
+				provides = append(provides, key)
+			}
+		}
+	}
+
+	return provides
+}
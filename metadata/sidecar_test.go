@@ -0,0 +1,158 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandFilenamePattern(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(`movie.info.json`, ExpandFilenamePattern(`{base}.info.json`, `movie.mkv`))
+	assert.Equal(`movie.mkv.md5`, ExpandFilenamePattern(`{name}.md5`, `movie.mkv`))
+	assert.Equal(`a\[1]\*.*.srt`, ExpandFilenamePattern(`{base}.*.srt`, `a[1]*.mkv`))
+	assert.Equal(`poster.jpg`, ExpandFilenamePattern(`poster.jpg`, `movie.mkv`))
+}
+
+func TestSidecarRuleLoad(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-sidecar-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, `video.mp4`), nil, 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, `video.info.json`), []byte(`{
+		"title": "A Video",
+		"description": "Long text",
+		"duration": 61.5,
+		"upload_date": 20190102,
+		"uploader": {"name": "Someone", "id": "abc"},
+		"formats": [{"url": "x"}],
+		"view_count": 10
+	}`), 0644))
+
+	rule := SidecarRule{
+		Name:     `ytdl`,
+		Patterns: []string{`{base}.info.json`},
+		Mappings: []SidecarMapping{
+			{To: `media.type`, Value: `web`},
+			{From: `title`, To: `media.title`},
+			{From: `duration`, To: `media.duration`, Transform: SidecarTransformMultiply, Factor: 1000},
+			{From: `duration`, To: `media.minutes`, Transform: SidecarTransformMultiply, Factor: 0.01},
+			{From: `upload_date`, To: `media.aired`, Transform: SidecarTransformDate, Layout: `20060102`},
+			{From: `upload_date`, To: `media.year`, Transform: SidecarTransformDate, OutputLayout: `2006`},
+			{From: `uploader.name`, To: `media.artist`, Transform: SidecarTransformRename},
+			{From: `title`, To: `media.bad_date`, Transform: SidecarTransformDate},
+			{From: `title`, To: `media.bad_number`, Transform: SidecarTransformMultiply, Factor: 2},
+			{From: `missing`, To: `media.missing`},
+		},
+		ExcludeFields: DefaultExcludeFields,
+	}
+
+	assert.NoError(rule.Validate())
+	assert.Equal([]string{filepath.Join(dir, `video.info.json`)}, rule.Files(filepath.Join(dir, `video.mp4`)))
+	assert.Equal([]string{`ytdl`, `media`}, rule.Provides())
+
+	data, err := rule.Load(filepath.Join(dir, `video.info.json`))
+	assert.NoError(err)
+
+	// values that can't be transformed are omitted, and renamed fields are removed from the namespace
+	assert.Equal(map[string]interface{}{
+		`media.type`:     `web`,
+		`media.title`:    `A Video`,
+		`media.duration`: int64(61500),
+		`media.minutes`:  0.615,
+		`media.aired`:    `2019-01-02`,
+		`media.year`:     2019,
+		`media.artist`:   `Someone`,
+		`ytdl`: map[string]interface{}{
+			`title`:       `A Video`,
+			`duration`:    61.5,
+			`upload_date`: float64(20190102),
+			`uploader`: map[string]interface{}{
+				`id`: `abc`,
+			},
+		},
+	}, data)
+
+	// mapped fields only
+	rule.Namespace = `-`
+	data, err = rule.Load(filepath.Join(dir, `video.info.json`))
+	assert.NoError(err)
+	assert.NotContains(data, `ytdl`)
+	assert.Equal([]string{`media`}, rule.Provides())
+
+	for _, invalid := range []SidecarRule{
+		{Patterns: []string{`{base}.json`}},
+		{Name: `x`},
+		{Name: `x`, Patterns: []string{`[`}},
+		{Name: `x`, Patterns: []string{`{base}.json`}, Format: `xml`},
+		{Name: `x`, Patterns: []string{`{base}.json`}, Mappings: []SidecarMapping{{From: `a`}}},
+		{Name: `x`, Patterns: []string{`{base}.json`}, Mappings: []SidecarMapping{{From: `a`, To: `b`, Transform: SidecarTransformMultiply}}},
+		{Name: `x`, Patterns: []string{`{base}.json`}, Mappings: []SidecarMapping{{From: `a`, To: `b`, Transform: `upper`}}},
+	} {
+		assert.Error(invalid.Validate(), "%+v", invalid)
+	}
+}
+
+func TestSidecarRuleLoadYaml(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-sidecar-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	sidecar := filepath.Join(dir, `photo.yaml`)
+	assert.NoError(ioutil.WriteFile(sidecar, []byte("title: Photo\n"+
+		"taken: 2019-01-02 03:04:05\n"+
+		"tags: [a, b]\n"+
+		"sizes:\n"+
+		"  1: small\n"+
+		"  true: yes\n"+
+		"people:\n"+
+		"  - name: A\n"+
+		"    ages: {2019: 30}\n"), 0644))
+
+	rule := SidecarRule{
+		Name:     `info`,
+		Patterns: []string{`{base}.yaml`},
+		Mappings: []SidecarMapping{
+			{From: `taken`, To: `media.taken`, Transform: SidecarTransformDate},
+		},
+	}
+
+	assert.NoError(rule.Validate())
+
+	data, err := rule.Load(sidecar)
+	assert.NoError(err)
+
+	// nested mapping keys of any type become strings
+	assert.Equal(map[string]interface{}{
+		`media.taken`: `2019-01-02`,
+		`info`: map[string]interface{}{
+			`title`: `Photo`,
+			`taken`: `2019-01-02 03:04:05`,
+			`tags`:  []interface{}{`a`, `b`},
+			`sizes`: map[string]interface{}{
+				`1`:    `small`,
+				`true`: true,
+			},
+			`people`: []interface{}{
+				map[string]interface{}{
+					`name`: `A`,
+					`ages`: map[string]interface{}{`2019`: 30},
+				},
+			},
+		},
+	}, data)
+
+	// the format is taken from the rule rather than the extension when given
+	rule.Format = SidecarFormatJSON
+	_, err = rule.Load(sidecar)
+	assert.Error(err)
+}
@@ -0,0 +1,338 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	MimeSourceExtension = `extension`
+	MimeSourceDetected  = `detected`
+	MimeSourceAuto      = `auto`
+)
+
+// Determines which MIME type is used to classify files: the one derived from the file extension,
+// the one detected from the file's contents, or the extension-derived type falling back to detection
+// when the extension is missing or unrecognized.
+var MimeTypeSource = MimeSourceExtension
+
+// The number of bytes read from the start of a file when detecting its type.
+var SniffLength = 8192
+
+// The number of detection results kept so that the several loaders classifying the same file
+// only read it once.
+var SniffCacheSize = 1024
+
+type sniffResult struct {
+	Size      int64
+	ModTime   time.Time
+	MediaType string
+}
+
+var sniffCache = make(map[string]sniffResult)
+var sniffCacheLock sync.Mutex
+
+const mimeOctetStream = `application/octet-stream`
+
+type mimeSignature struct {
+	Offset    int
+	Magic     []byte
+	MediaType string
+}
+
+var MimeSignatures = []mimeSignature{
+	// images
+	{0, []byte("\xFF\xD8\xFF"), `image/jpeg`},
+	{0, []byte("\x89PNG\r\n\x1A\n"), `image/png`},
+	{0, []byte("GIF87a"), `image/gif`},
+	{0, []byte("GIF89a"), `image/gif`},
+	{0, []byte("II*\x00"), `image/tiff`},
+	{0, []byte("MM\x00*"), `image/tiff`},
+	{0, []byte("\x00\x00\x01\x00"), `image/x-icon`},
+	{0, []byte("8BPS"), `image/vnd.adobe.photoshop`},
+
+	// audio
+	{0, []byte("ID3"), `audio/mpeg`},
+	{0, []byte("fLaC"), `audio/flac`},
+	{0, []byte("MThd"), `audio/midi`},
+	{0, []byte("#!AMR"), `audio/amr`},
+
+	// video
+	{0, []byte("\x00\x00\x01\xBA"), `video/mpeg`},
+	{0, []byte("\x00\x00\x01\xB3"), `video/mpeg`},
+	{0, []byte("FLV\x01"), `video/x-flv`},
+	{0, []byte("\x30\x26\xB2\x75\x8E\x66\xCF\x11"), `video/x-ms-asf`},
+
+	// archives
+	{0, []byte("PK\x03\x04"), `application/zip`},
+	{0, []byte("PK\x05\x06"), `application/zip`},
+	{0, []byte("\x1F\x8B"), `application/gzip`},
+	{0, []byte("BZh"), `application/x-bzip2`},
+	{0, []byte("\xFD7zXZ\x00"), `application/x-xz`},
+	{0, []byte("7z\xBC\xAF\x27\x1C"), `application/x-7z-compressed`},
+	{0, []byte("Rar!\x1A\x07"), `application/vnd.rar`},
+	{0, []byte("\x28\xB5\x2F\xFD"), `application/zstd`},
+	{257, []byte("ustar"), `application/x-tar`},
+	{32769, []byte("CD001"), `application/x-iso9660-image`},
+
+	// documents
+	{0, []byte("%PDF-"), `application/pdf`},
+	{0, []byte("%!PS"), `application/postscript`},
+	{0, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), `application/x-ole-storage`},
+	{0, []byte("{\\rtf"), `application/rtf`},
+
+	// fonts
+	{0, []byte("wOFF"), `font/woff`},
+	{0, []byte("wOF2"), `font/woff2`},
+	{0, []byte("OTTO"), `font/otf`},
+	{0, []byte("\x00\x01\x00\x00\x00"), `font/ttf`},
+
+	// executables
+	{0, []byte("\x7FELF"), `application/x-executable`},
+	{0, []byte("\xFE\xED\xFA\xCE"), `application/x-mach-binary`},
+	{0, []byte("\xFE\xED\xFA\xCF"), `application/x-mach-binary`},
+	{0, []byte("\xCE\xFA\xED\xFE"), `application/x-mach-binary`},
+	{0, []byte("\xCF\xFA\xED\xFE"), `application/x-mach-binary`},
+	{0, []byte("\xCA\xFE\xBA\xBE"), `application/x-mach-binary`},
+	{0, []byte("\x00asm"), `application/wasm`},
+}
+
+// Detect the MIME type of the named file by inspecting the first SniffLength bytes of its content.
+// Results are cached until the file's size or modification time changes.
+func DetectMimeType(name string) (string, error) {
+	if file, err := os.Open(name); err == nil {
+		defer file.Close()
+
+		stat, err := file.Stat()
+
+		if err != nil {
+			return ``, err
+		}
+
+		sniffCacheLock.Lock()
+		cached, ok := sniffCache[name]
+		sniffCacheLock.Unlock()
+
+		if ok && cached.Size == stat.Size() && cached.ModTime.Equal(stat.ModTime()) {
+			return cached.MediaType, nil
+		}
+
+		length := SniffLength
+
+		// ISO9660 images have their signature beyond the usual sniffing window
+		if length < 32774 && filepath.Ext(name) == `.iso` {
+			length = 32774
+		}
+
+		data := make([]byte, length)
+
+		if n, err := io.ReadFull(file, data); err == nil || err == io.ErrUnexpectedEOF || err == io.EOF {
+			mediaType := DetectMimeTypeFromBytes(data[:n])
+
+			sniffCacheLock.Lock()
+
+			if len(sniffCache) >= SniffCacheSize {
+				sniffCache = make(map[string]sniffResult)
+			}
+
+			sniffCache[name] = sniffResult{
+				Size:      stat.Size(),
+				ModTime:   stat.ModTime(),
+				MediaType: mediaType,
+			}
+
+			sniffCacheLock.Unlock()
+
+			return mediaType, nil
+		} else {
+			return ``, err
+		}
+	} else {
+		return ``, err
+	}
+}
+
+// Detect the MIME type of the given data, returning application/octet-stream if it cannot be determined.
+func DetectMimeTypeFromBytes(data []byte) string {
+	if len(data) == 0 {
+		return mimeOctetStream
+	}
+
+	// container formats that require looking at more than a prefix
+	if len(data) >= 12 {
+		switch {
+		case bytes.HasPrefix(data, []byte("RIFF")):
+			switch string(data[8:12]) {
+			case `WEBP`:
+				return `image/webp`
+			case `WAVE`:
+				return `audio/x-wav`
+			case `AVI `:
+				return `video/x-msvideo`
+			}
+
+		case string(data[4:8]) == `ftyp`:
+			return mimeTypeForFtypBrand(string(data[8:12]))
+
+		case bytes.HasPrefix(data, []byte("\x1A\x45\xDF\xA3")):
+			if bytes.Contains(data, []byte("webm")) {
+				return `video/webm`
+			}
+
+			return `video/x-matroska`
+
+		case bytes.HasPrefix(data, []byte("FORM")) && (string(data[8:12]) == `AIFF` || string(data[8:12]) == `AIFC`):
+			return `audio/x-aiff`
+
+		case bytes.HasPrefix(data, []byte("BM")) && bytes.Equal(data[6:10], []byte{0, 0, 0, 0}):
+			return `image/bmp`
+
+		case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+			if mediaType := zipDocumentMimeType(data); mediaType != `` {
+				return mediaType
+			}
+
+		case bytes.HasPrefix(data, []byte("OggS")):
+			if bytes.Contains(data, []byte("theora")) {
+				return `video/ogg`
+			} else if bytes.Contains(data, []byte("OpusHead")) {
+				return `audio/opus`
+			}
+
+			return `audio/ogg`
+		}
+	}
+
+	for _, signature := range MimeSignatures {
+		if end := signature.Offset + len(signature.Magic); len(data) >= end {
+			if bytes.Equal(data[signature.Offset:end], signature.Magic) {
+				return signature.MediaType
+			}
+		}
+	}
+
+	// DOS/Windows executables; text that happens to start with "MZ" has no PE header and no
+	// NUL bytes in what would be the DOS header
+	if len(data) >= 64 && bytes.HasPrefix(data, []byte("MZ")) {
+		offset := int64(binary.LittleEndian.Uint32(data[60:64]))
+
+		if offset >= 64 && offset+4 <= int64(len(data)) && bytes.Equal(data[offset:offset+4], []byte("PE\x00\x00")) {
+			return `application/vnd.microsoft.portable-executable`
+		} else if bytes.IndexByte(data[:64], 0) >= 0 {
+			return `application/x-dosexec`
+		}
+	}
+
+	// scripts with an interpreter line (e.g.: "#!/bin/sh" or "#! /usr/bin/env python")
+	if bytes.HasPrefix(data, []byte("#!/")) || bytes.HasPrefix(data, []byte("#! /")) {
+		return `text/x-shellscript`
+	}
+
+	// MPEG audio frame sync (without an ID3 header)
+	if len(data) >= 2 && data[0] == 0xFF && (data[1]&0xE0) == 0xE0 {
+		switch data[1] & 0x06 {
+		case 0x02, 0x04, 0x06:
+			return `audio/mpeg`
+		case 0x00:
+			if (data[1] & 0xF6) == 0xF0 {
+				return `audio/aac`
+			}
+		}
+	}
+
+	// fallback to the standard library's sniffing (HTML, XML, text, etc.)
+	if mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data)); err == nil {
+		return mediaType
+	}
+
+	return mimeOctetStream
+}
+
+func mimeTypeForFtypBrand(brand string) string {
+	switch brand {
+	case `M4A `, `M4B `, `M4P `, `F4A `:
+		return `audio/mp4`
+	case `M4V `, `M4VH`, `M4VP`:
+		return `video/x-m4v`
+	case `qt  `:
+		return `video/quicktime`
+	case `heic`, `heix`, `mif1`, `msf1`:
+		return `image/heic`
+	case `avif`, `avis`:
+		return `image/avif`
+	case `3gp4`, `3gp5`, `3gp6`, `3ge6`, `3gg6`:
+		return `video/3gpp`
+	case `3g2a`:
+		return `video/3gpp2`
+	case `crx `:
+		return `image/x-canon-cr3`
+	default:
+		return `video/mp4`
+	}
+}
+
+// Return the MIME type of the named file according to the current MimeTypeSource setting.
+func GetMimeType(filename string) string {
+	initMime.Do(func() {
+		SetupMimeTypes()
+	})
+
+	var byExtension string
+
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(filename))); err == nil {
+		byExtension = mediaType
+	}
+
+	switch MimeTypeSource {
+	case MimeSourceDetected:
+		if detected, err := DetectMimeType(filename); err == nil && detected != mimeOctetStream {
+			return detected
+		}
+
+	case MimeSourceAuto:
+		if byExtension == `` {
+			if detected, err := DetectMimeType(filename); err == nil && detected != mimeOctetStream {
+				return detected
+			}
+		}
+	}
+
+	return byExtension
+}
+
+// Identify ZIP-based document formats from the start of the archive.
+func zipDocumentMimeType(data []byte) string {
+	// ODF and EPUB store their MIME type uncompressed as the first member, named "mimetype"
+	if len(data) > 38 && string(data[30:38]) == `mimetype` {
+		mediaType := data[38:]
+
+		if i := bytes.Index(mediaType, []byte("PK")); i >= 0 {
+			mediaType = mediaType[:i]
+		}
+
+		if len(mediaType) > 0 && len(mediaType) < 128 {
+			return string(bytes.TrimSpace(mediaType))
+		}
+	}
+
+	// OOXML packages are identified by the directories named in the first few members
+	if bytes.Contains(data, []byte("[Content_Types].xml")) || bytes.Contains(data, []byte("_rels/.rels")) {
+		switch {
+		case bytes.Contains(data, []byte("word/")):
+			return `application/vnd.openxmlformats-officedocument.wordprocessingml.document`
+		case bytes.Contains(data, []byte("xl/")):
+			return `application/vnd.openxmlformats-officedocument.spreadsheetml.sheet`
+		case bytes.Contains(data, []byte("ppt/")):
+			return `application/vnd.openxmlformats-officedocument.presentationml.presentation`
+		}
+	}
+
+	return ``
+}
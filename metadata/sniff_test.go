@@ -0,0 +1,79 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectMimeTypeFromBytes(t *testing.T) {
+	assert := require.New(t)
+
+	pe := make([]byte, 256)
+	copy(pe, "MZ")
+	pe[60] = 128
+	copy(pe[128:], "PE\x00\x00")
+
+	dos := make([]byte, 128)
+	copy(dos, "MZ\x90\x00")
+
+	for data, expected := range map[string]string{
+		``:                                      `application/octet-stream`,
+		"\xFF\xD8\xFF\xE0\x00\x10JFIF":          `image/jpeg`,
+		"\x89PNG\r\n\x1A\n\x00\x00\x00\x0DIHDR": `image/png`,
+		"RIFF\x00\x00\x00\x00WEBPVP8 ":          `image/webp`,
+		"RIFF\x00\x00\x00\x00WAVEfmt ":          `audio/x-wav`,
+		"\x00\x00\x00\x20ftypM4A \x00\x00\x00":  `audio/mp4`,
+		"\x00\x00\x00\x20ftypisom\x00\x00\x00":  `video/mp4`,
+		"\x1A\x45\xDF\xA3\x01\x00\x00\x00webm":  `video/webm`,
+		"OggS\x00\x02\x00\x00\x00\x00OpusHead":  `audio/opus`,
+		"ID3\x04\x00\x00\x00\x00\x00\x00":       `audio/mpeg`,
+		"\xFF\xFB\x90\x00":                      `audio/mpeg`,
+		"%PDF-1.7\n":                            `application/pdf`,
+		"\x7FELF\x02\x01\x01":                   `application/x-executable`,
+		string(pe):                              `application/vnd.microsoft.portable-executable`,
+		string(dos):                             `application/x-dosexec`,
+		"#!/bin/sh\necho hi\n":                  `text/x-shellscript`,
+		"#! /usr/bin/env python\n":              `text/x-shellscript`,
+		"<html><body></body></html>":            `text/html`,
+		"just some text\n":                      `text/plain`,
+
+		// text that merely starts like an executable or script
+		"MZ is the start of this sentence, which goes on long enough to look like a header.\n": `text/plain`,
+		"#!important notes\n": `text/plain`,
+	} {
+		assert.Equal(expected, DetectMimeTypeFromBytes([]byte(data)), "data: %q", data)
+	}
+}
+
+func TestDetectMimeTypeCache(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-sniff-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, `script`)
+	assert.NoError(ioutil.WriteFile(name, []byte("#!/bin/sh\n"), 0644))
+
+	mediaType, err := DetectMimeType(name)
+	assert.NoError(err)
+	assert.Equal(`text/x-shellscript`, mediaType)
+
+	defer func(source string) {
+		MimeTypeSource = source
+	}(MimeTypeSource)
+
+	MimeTypeSource = MimeSourceDetected
+	assert.Equal(`code`, GetGeneralFileType(name))
+
+	// a change in size invalidates the cached result
+	assert.NoError(ioutil.WriteFile(name, []byte("%PDF-1.4\n%more"), 0644))
+
+	mediaType, err = DetectMimeType(name)
+	assert.NoError(err)
+	assert.Equal(`application/pdf`, mediaType)
+}
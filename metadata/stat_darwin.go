@@ -0,0 +1,26 @@
+package metadata
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func statDetails(name string, stat os.FileInfo) map[string]interface{} {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return map[string]interface{}{
+			`uid`:         int64(sys.Uid),
+			`gid`:         int64(sys.Gid),
+			`inode`:       uint64(sys.Ino),
+			`device`:      uint64(sys.Dev),
+			`links`:       uint64(sys.Nlink),
+			`accessed_at`: time.Unix(sys.Atimespec.Unix()),
+			`changed_at`:  time.Unix(sys.Ctimespec.Unix()),
+			`created_at`:  time.Unix(sys.Birthtimespec.Unix()),
+			`blocks`:      int64(sys.Blocks),
+			`block_size`:  int64(sys.Blksize),
+		}
+	}
+
+	return nil
+}
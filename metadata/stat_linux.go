@@ -0,0 +1,38 @@
+package metadata
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func statDetails(name string, stat os.FileInfo) map[string]interface{} {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		details := map[string]interface{}{
+			`uid`:         int64(sys.Uid),
+			`gid`:         int64(sys.Gid),
+			`inode`:       uint64(sys.Ino),
+			`device`:      uint64(sys.Dev),
+			`links`:       uint64(sys.Nlink),
+			`accessed_at`: time.Unix(sys.Atim.Unix()),
+			`changed_at`:  time.Unix(sys.Ctim.Unix()),
+			`blocks`:      int64(sys.Blocks),
+			`block_size`:  int64(sys.Blksize),
+		}
+
+		// birth time is only available through statx(2), and only on some filesystems
+		var stx unix.Statx_t
+
+		if err := unix.Statx(unix.AT_FDCWD, name, unix.AT_STATX_SYNC_AS_STAT, unix.STATX_BTIME, &stx); err == nil {
+			if (stx.Mask & unix.STATX_BTIME) == unix.STATX_BTIME {
+				details[`created_at`] = time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+			}
+		}
+
+		return details
+	}
+
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package metadata
+
+import (
+	"os"
+)
+
+func statDetails(name string, stat os.FileInfo) map[string]interface{} {
+	return nil
+}
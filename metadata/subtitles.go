@@ -0,0 +1,327 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+)
+
+// File extensions recognized as external subtitle tracks.
+var SubtitleExtensions = []string{
+	`ass`, `idx`, `smi`, `srt`, `ssa`, `sub`, `sup`, `ttml`, `vtt`,
+}
+
+// File extensions recognized as external audio tracks.
+var ExternalAudioExtensions = []string{
+	`aac`, `ac3`, `dts`, `eac3`, `flac`, `m4a`, `mka`, `opus`, `thd`,
+}
+
+// Maps the language codes and names found in sidecar filenames to ISO 639-1 codes.
+var SidecarLanguages = map[string]string{
+	`ar`: `ar`, `ara`: `ar`, `arabic`: `ar`,
+	`bg`: `bg`, `bul`: `bg`, `bulgarian`: `bg`,
+	`cs`: `cs`, `ces`: `cs`, `cze`: `cs`, `czech`: `cs`,
+	`da`: `da`, `dan`: `da`, `danish`: `da`,
+	`de`: `de`, `deu`: `de`, `ger`: `de`, `german`: `de`,
+	`el`: `el`, `ell`: `el`, `gre`: `el`, `greek`: `el`,
+	`en`: `en`, `eng`: `en`, `english`: `en`,
+	`es`: `es`, `spa`: `es`, `spanish`: `es`,
+	`fa`: `fa`, `fas`: `fa`, `per`: `fa`, `persian`: `fa`,
+	`fi`: `fi`, `fin`: `fi`, `finnish`: `fi`,
+	`fr`: `fr`, `fra`: `fr`, `fre`: `fr`, `french`: `fr`,
+	`he`: `he`, `heb`: `he`, `hebrew`: `he`,
+	`hi`: `hi`, `hin`: `hi`, `hindi`: `hi`,
+	`hr`: `hr`, `hrv`: `hr`, `croatian`: `hr`,
+	`hu`: `hu`, `hun`: `hu`, `hungarian`: `hu`,
+	`id`: `id`, `ind`: `id`, `indonesian`: `id`,
+	`it`: `it`, `ita`: `it`, `italian`: `it`,
+	`ja`: `ja`, `jpn`: `ja`, `japanese`: `ja`,
+	`ko`: `ko`, `kor`: `ko`, `korean`: `ko`,
+	`nl`: `nl`, `nld`: `nl`, `dut`: `nl`, `dutch`: `nl`,
+	`no`: `no`, `nor`: `no`, `nob`: `no`, `norwegian`: `no`,
+	`pl`: `pl`, `pol`: `pl`, `polish`: `pl`,
+	`pt`: `pt`, `por`: `pt`, `portuguese`: `pt`,
+	`ro`: `ro`, `ron`: `ro`, `rum`: `ro`, `romanian`: `ro`,
+	`ru`: `ru`, `rus`: `ru`, `russian`: `ru`,
+	`sk`: `sk`, `slk`: `sk`, `slo`: `sk`, `slovak`: `sk`,
+	`sr`: `sr`, `srp`: `sr`, `serbian`: `sr`,
+	`sv`: `sv`, `swe`: `sv`, `swedish`: `sv`,
+	`th`: `th`, `tha`: `th`, `thai`: `th`,
+	`tr`: `tr`, `tur`: `tr`, `turkish`: `tr`,
+	`uk`: `uk`, `ukr`: `uk`, `ukrainian`: `uk`,
+	`vi`: `vi`, `vie`: `vi`, `vietnamese`: `vi`,
+	`zh`: `zh`, `zho`: `zh`, `chi`: `zh`, `chinese`: `zh`,
+}
+
+// The number of directory listings kept so that sidecars are found without re-reading the directory
+// for every video in it.
+var SidecarDirectoryCacheSize = 64
+
+type sidecarListing struct {
+	ModTime time.Time
+	Names   []string
+}
+
+var sidecarListingCache = make(map[string]sidecarListing)
+var sidecarListingCacheLock sync.Mutex
+
+// language codes with a region suffix (e.g.: "pt-BR", "en_US", "zh-Hant")
+var rxSidecarRegionalLanguage = regexp.MustCompile(`^([a-z]{2,3})[-_]([a-z]{2}|[a-z]{4})$`)
+
+type SubtitleLoader struct {
+	Loader
+}
+
+func (self *SubtitleLoader) CanHandle(name string) Loader {
+	if GetGeneralFileType(name) == `video` {
+		return &SubtitleLoader{}
+	}
+
+	return nil
+}
+
+// Subtitle languages are combined with those of the subtitle tracks embedded in the video.
+func (self *SubtitleLoader) Requires() []string {
+	return []string{`video.subtitle_languages`}
+}
+
+// Sidecars that have been removed shouldn't linger in the metadata from earlier scans.
+func (self *SubtitleLoader) Replaces() []string {
+	return []string{
+		`media.subtitles`,
+		`media.subtitle_languages`,
+		`media.external_audio`,
+		`media.external_audio_languages`,
+	}
+}
+
+func (self *SubtitleLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	return self.LoadMetadataWith(name, nil)
+}
+
+// Describe the subtitle and audio sidecar files of the named video.  The subtitle_languages field
+// lists the languages of both the external subtitles and those embedded in the video.
+func (self *SubtitleLoader) LoadMetadataWith(name string, existing map[string]interface{}) (map[string]interface{}, error) {
+	dir := filepath.Dir(name)
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+
+	names, err := listSidecarDirectory(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	subtitles := make([]map[string]interface{}, 0)
+	audio := make([]map[string]interface{}, 0)
+	subtitleLanguages := make([]string, 0)
+	audioLanguages := make([]string, 0)
+	present := make(map[string]bool)
+	candidates := make([]string, 0)
+
+	// the listing is sorted, so all sidecars of this video are found together
+	for i := sort.SearchStrings(names, base+`.`); i < len(names) && strings.HasPrefix(names[i], base+`.`); i++ {
+		present[names[i]] = true
+
+		if names[i] != filepath.Base(name) {
+			candidates = append(candidates, names[i])
+		}
+	}
+
+	for _, filename := range candidates {
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), `.`))
+		stem := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+		// VobSub .sub files are described by the .idx file alongside them
+		if ext == `sub` && (present[stem+`.idx`] || present[stem+`.IDX`]) {
+			continue
+		}
+
+		if !sliceutil.ContainsString(SubtitleExtensions, ext) && !sliceutil.ContainsString(ExternalAudioExtensions, ext) {
+			continue
+		} else if stat, err := os.Stat(filepath.Join(dir, filename)); err != nil || stat.IsDir() {
+			continue
+		}
+
+		sidecar := ParseSidecarName(strings.TrimPrefix(stem, base))
+		sidecar[`file`] = filename
+		sidecar[`format`] = ext
+
+		switch {
+		case sliceutil.ContainsString(SubtitleExtensions, ext):
+			subtitles = append(subtitles, sidecar)
+
+			if language, ok := sidecar[`language`].(string); ok {
+				if !sliceutil.ContainsString(subtitleLanguages, language) {
+					subtitleLanguages = append(subtitleLanguages, language)
+				}
+			}
+
+		case sliceutil.ContainsString(ExternalAudioExtensions, ext):
+			delete(sidecar, `sdh`)
+			audio = append(audio, sidecar)
+
+			if language, ok := sidecar[`language`].(string); ok {
+				if !sliceutil.ContainsString(audioLanguages, language) {
+					audioLanguages = append(audioLanguages, language)
+				}
+			}
+		}
+	}
+
+	if video, ok := existing[`video`].(map[string]interface{}); ok {
+		for _, language := range embeddedLanguages(video[`subtitle_languages`]) {
+			if !sliceutil.ContainsString(subtitleLanguages, language) {
+				subtitleLanguages = append(subtitleLanguages, language)
+			}
+		}
+	}
+
+	media := make(map[string]interface{})
+
+	if len(subtitles) > 0 {
+		media[`subtitles`] = subtitles
+	}
+
+	if len(subtitleLanguages) > 0 {
+		sort.Strings(subtitleLanguages)
+		media[`subtitle_languages`] = subtitleLanguages
+	}
+
+	if len(audio) > 0 {
+		sort.Strings(audioLanguages)
+		media[`external_audio`] = audio
+		media[`external_audio_languages`] = audioLanguages
+	}
+
+	return map[string]interface{}{
+		`media`: media,
+	}, nil
+}
+
+// Return the sorted names of the entries in the given directory.  Listings are cached until the
+// directory's modification time changes (which happens whenever entries are added or removed).
+func listSidecarDirectory(dir string) ([]string, error) {
+	stat, err := os.Stat(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarListingCacheLock.Lock()
+	cached, ok := sidecarListingCache[dir]
+	sidecarListingCacheLock.Unlock()
+
+	if ok && cached.ModTime.Equal(stat.ModTime()) {
+		return cached.Names, nil
+	}
+
+	if file, err := os.Open(dir); err == nil {
+		defer file.Close()
+
+		if names, err := file.Readdirnames(-1); err == nil {
+			sort.Strings(names)
+
+			sidecarListingCacheLock.Lock()
+
+			if len(sidecarListingCache) >= SidecarDirectoryCacheSize {
+				sidecarListingCache = make(map[string]sidecarListing)
+			}
+
+			sidecarListingCache[dir] = sidecarListing{
+				ModTime: stat.ModTime(),
+				Names:   names,
+			}
+
+			sidecarListingCacheLock.Unlock()
+
+			return names, nil
+		} else {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+}
+
+// Languages are []string when loaded in this scan, or []interface{} when read back from the database.
+func embeddedLanguages(value interface{}) []string {
+	languages := make([]string, 0)
+
+	switch value := value.(type) {
+	case []string:
+		languages = append(languages, value...)
+	case []interface{}:
+		for _, language := range value {
+			if languageS, ok := language.(string); ok {
+				languages = append(languages, languageS)
+			}
+		}
+	}
+
+	return languages
+}
+
+// Parse the dot-separated tags that follow the primary file's name in a sidecar filename
+// (e.g.: ".forced.de" in "movie.forced.de.srt") into a language, flags, and a label made up of
+// any unrecognized tags.
+func ParseSidecarName(tags string) map[string]interface{} {
+	sidecar := map[string]interface{}{
+		`forced`:  false,
+		`sdh`:     false,
+		`default`: false,
+	}
+
+	labels := make([]string, 0)
+
+	for _, tag := range strings.Split(tags, `.`) {
+		if tag == `` {
+			continue
+		}
+
+		switch lower := strings.ToLower(tag); lower {
+		case `forced`, `foreign`:
+			sidecar[`forced`] = true
+		case `sdh`, `cc`, `hi`, `hoh`:
+			// "hi" is also the code for Hindi; it only counts as a flag once a language is known
+			if lower == `hi` {
+				if _, ok := sidecar[`language`]; !ok {
+					sidecar[`language`] = `hi`
+					continue
+				}
+			}
+
+			sidecar[`sdh`] = true
+		case `default`:
+			sidecar[`default`] = true
+		default:
+			if language, ok := SidecarLanguages[lower]; ok {
+				if _, ok := sidecar[`language`]; !ok {
+					sidecar[`language`] = language
+					continue
+				}
+			} else if match := rxSidecarRegionalLanguage.FindStringSubmatch(lower); match != nil {
+				if language, ok := SidecarLanguages[match[1]]; ok {
+					if _, ok := sidecar[`language`]; !ok {
+						sidecar[`language`] = language
+						sidecar[`region`] = match[2]
+						continue
+					}
+				}
+			}
+
+			labels = append(labels, tag)
+		}
+	}
+
+	if len(labels) > 0 {
+		sidecar[`label`] = strings.Join(labels, ` `)
+	}
+
+	return sidecar
+}
@@ -0,0 +1,127 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSidecarName(t *testing.T) {
+	assert := require.New(t)
+
+	for tags, expected := range map[string]map[string]interface{}{
+		``:        {`forced`: false, `sdh`: false, `default`: false},
+		`.en`:     {`forced`: false, `sdh`: false, `default`: false, `language`: `en`},
+		`.eng`:    {`forced`: false, `sdh`: false, `default`: false, `language`: `en`},
+		`.French`: {`forced`: false, `sdh`: false, `default`: false, `language`: `fr`},
+		`.pt-BR`:  {`forced`: false, `sdh`: false, `default`: false, `language`: `pt`, `region`: `br`},
+		`.zh_Hant`: {
+			`forced`: false, `sdh`: false, `default`: false, `language`: `zh`, `region`: `hant`,
+		},
+		`.forced.de`:      {`forced`: true, `sdh`: false, `default`: false, `language`: `de`},
+		`.en.sdh.default`: {`forced`: false, `sdh`: true, `default`: true, `language`: `en`},
+		`.en.hi`:          {`forced`: false, `sdh`: true, `default`: false, `language`: `en`},
+		`.en.cc`:          {`forced`: false, `sdh`: true, `default`: false, `language`: `en`},
+
+		// "hi" is Hindi until a language is known, after which further languages are labels
+		`.hi`:    {`forced`: false, `sdh`: false, `default`: false, `language`: `hi`},
+		`.hi.en`: {`forced`: false, `sdh`: false, `default`: false, `language`: `hi`, `label`: `en`},
+
+		`.Director's Commentary.en`: {
+			`forced`: false, `sdh`: false, `default`: false, `language`: `en`, `label`: `Director's Commentary`,
+		},
+		`.foreign.xx.yy`: {`forced`: true, `sdh`: false, `default`: false, `label`: `xx yy`},
+	} {
+		assert.Equal(expected, ParseSidecarName(tags), tags)
+	}
+}
+
+func TestSubtitleLoader(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-subtitles-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{
+		`movie.mkv`, `movie.en.srt`, `movie.forced.de.srt`, `movie.de.idx`, `movie.de.sub`,
+		`movie.fr.ac3`, `movie.nfo`, `other.en.srt`,
+	} {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	loader := &SubtitleLoader{}
+	name := filepath.Join(dir, `movie.mkv`)
+
+	assert.NotNil(loader.CanHandle(name))
+	assert.Nil(loader.CanHandle(filepath.Join(dir, `movie.en.srt`)))
+
+	// languages of embedded subtitle tracks are combined with those of external ones
+	data, err := loader.LoadMetadataWith(name, map[string]interface{}{
+		`video`: map[string]interface{}{
+			`subtitle_languages`: []interface{}{`ja`, `en`},
+		},
+	})
+
+	assert.NoError(err)
+
+	media := data[`media`].(map[string]interface{})
+	assert.Equal([]string{`de`, `en`, `ja`}, media[`subtitle_languages`])
+	assert.Equal([]string{`fr`}, media[`external_audio_languages`])
+
+	subtitles := media[`subtitles`].([]map[string]interface{})
+	assert.Len(subtitles, 3)
+
+	files := make([]string, 0)
+
+	for _, subtitle := range subtitles {
+		files = append(files, subtitle[`file`].(string))
+	}
+
+	// VobSub .sub files are represented by their .idx
+	assert.ElementsMatch([]string{`movie.en.srt`, `movie.forced.de.srt`, `movie.de.idx`}, files)
+
+	// with no sidecars, only the embedded languages remain (and the loader's keys are replaced)
+	for _, name := range []string{`movie.en.srt`, `movie.forced.de.srt`, `movie.de.idx`, `movie.de.sub`, `movie.fr.ac3`} {
+		assert.NoError(os.Remove(filepath.Join(dir, name)))
+	}
+
+	data, err = loader.LoadMetadata(name)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`media`: map[string]interface{}{},
+	}, data)
+
+	data, err = loader.LoadMetadataWith(name, map[string]interface{}{
+		`video`: map[string]interface{}{
+			`subtitle_languages`: []string{`en`},
+		},
+	})
+
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`media`: map[string]interface{}{
+			`subtitle_languages`: []string{`en`},
+		},
+	}, data)
+
+	// sidecars added later are found, but directories named like them are not
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, `movie.es.vtt`), nil, 0644))
+	assert.NoError(os.Mkdir(filepath.Join(dir, `movie.it.srt`), 0755))
+
+	data, err = loader.LoadMetadata(name)
+	assert.NoError(err)
+	assert.Equal([]string{`es`}, data[`media`].(map[string]interface{})[`subtitle_languages`])
+
+	assert.Contains(loader.Replaces(), `media.subtitles`)
+	assert.Contains(loader.Replaces(), `media.subtitle_languages`)
+
+	// the loader runs after the video loader it takes embedded languages from
+	registration, ok := GetLoaderRegistration(`subtitles`)
+	assert.True(ok)
+	assert.Equal(loader.Requires(), registration.Requires)
+	assert.Contains(testPassLoaderNames(2), `subtitles`)
+}
@@ -0,0 +1,427 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Text files larger than this (in bytes) are not inspected.
+var TextMaxSize int64 = 16777216
+
+// The number of bytes checked for null bytes when deciding whether a file is binary.
+var TextBinaryCheckLength = 8000
+
+// Maps file extensions to the programming language they contain.
+var TextLanguages = map[string]string{
+	`asm`:        `Assembly`,
+	`bash`:       `Shell`,
+	`bat`:        `Batch`,
+	`c`:          `C`,
+	`cc`:         `C++`,
+	`clj`:        `Clojure`,
+	`cmake`:      `CMake`,
+	`coffee`:     `CoffeeScript`,
+	`cpp`:        `C++`,
+	`cs`:         `C#`,
+	`css`:        `CSS`,
+	`cxx`:        `C++`,
+	`d`:          `D`,
+	`dart`:       `Dart`,
+	`dockerfile`: `Dockerfile`,
+	`el`:         `Emacs Lisp`,
+	`erl`:        `Erlang`,
+	`ex`:         `Elixir`,
+	`exs`:        `Elixir`,
+	`f90`:        `Fortran`,
+	`fish`:       `Shell`,
+	`fs`:         `F#`,
+	`go`:         `Go`,
+	`groovy`:     `Groovy`,
+	`h`:          `C`,
+	`hh`:         `C++`,
+	`hpp`:        `C++`,
+	`hs`:         `Haskell`,
+	`htm`:        `HTML`,
+	`html`:       `HTML`,
+	`ini`:        `INI`,
+	`java`:       `Java`,
+	`jl`:         `Julia`,
+	`js`:         `JavaScript`,
+	`json`:       `JSON`,
+	`jsx`:        `JavaScript`,
+	`kt`:         `Kotlin`,
+	`kts`:        `Kotlin`,
+	`less`:       `Less`,
+	`lisp`:       `Common Lisp`,
+	`lua`:        `Lua`,
+	`m`:          `Objective-C`,
+	`makefile`:   `Makefile`,
+	`md`:         `Markdown`,
+	`mjs`:        `JavaScript`,
+	`ml`:         `OCaml`,
+	`nim`:        `Nim`,
+	`php`:        `PHP`,
+	`pl`:         `Perl`,
+	`pm`:         `Perl`,
+	`proto`:      `Protocol Buffers`,
+	`ps1`:        `PowerShell`,
+	`py`:         `Python`,
+	`r`:          `R`,
+	`rb`:         `Ruby`,
+	`rs`:         `Rust`,
+	`rst`:        `reStructuredText`,
+	`sass`:       `Sass`,
+	`scala`:      `Scala`,
+	`scss`:       `SCSS`,
+	`sh`:         `Shell`,
+	`sql`:        `SQL`,
+	`swift`:      `Swift`,
+	`tcl`:        `Tcl`,
+	`tex`:        `TeX`,
+	`tf`:         `HCL`,
+	`toml`:       `TOML`,
+	`ts`:         `TypeScript`,
+	`tsx`:        `TypeScript`,
+	`v`:          `Verilog`,
+	`vb`:         `Visual Basic`,
+	`vim`:        `Vim Script`,
+	`vue`:        `Vue`,
+	`xml`:        `XML`,
+	`yaml`:       `YAML`,
+	`yml`:        `YAML`,
+	`zig`:        `Zig`,
+	`zsh`:        `Shell`,
+}
+
+// Maps shebang interpreters (with any version suffix removed) to the language they run.
+var TextInterpreters = map[string]string{
+	`ash`:     `Shell`,
+	`awk`:     `Awk`,
+	`bash`:    `Shell`,
+	`dash`:    `Shell`,
+	`deno`:    `TypeScript`,
+	`fish`:    `Shell`,
+	`gawk`:    `Awk`,
+	`groovy`:  `Groovy`,
+	`julia`:   `Julia`,
+	`ksh`:     `Shell`,
+	`lua`:     `Lua`,
+	`node`:    `JavaScript`,
+	`nodejs`:  `JavaScript`,
+	`perl`:    `Perl`,
+	`php`:     `PHP`,
+	`pwsh`:    `PowerShell`,
+	`python`:  `Python`,
+	`Rscript`: `R`,
+	`ruby`:    `Ruby`,
+	`sh`:      `Shell`,
+	`tclsh`:   `Tcl`,
+	`zsh`:     `Shell`,
+}
+
+// MIME types outside of the text/* tree that are nevertheless plain text.
+var textMimeTypes = map[string]bool{
+	`application/javascript`: true,
+	`application/json`:       true,
+	`application/sql`:        true,
+	`application/toml`:       true,
+	`application/x-sh`:       true,
+	`application/x-yaml`:     true,
+	`application/xml`:        true,
+	`application/yaml`:       true,
+}
+
+type TextLoader struct {
+	Loader
+}
+
+func (self *TextLoader) CanHandle(name string) Loader {
+	if isTextFile(name) {
+		return &TextLoader{}
+	}
+
+	return nil
+}
+
+func (self *TextLoader) LoaderVersion() string {
+	return `1`
+}
+
+func (self *TextLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	file, err := os.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	if stat, err := file.Stat(); err == nil {
+		if stat.Size() > TextMaxSize {
+			return nil, nil
+		}
+	} else {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	text := make(map[string]interface{})
+	encoding, bom := detectTextEncoding(data)
+
+	switch encoding {
+	case `utf-16le`, `utf-16be`:
+		data = decodeUtf16(data[len(bom):], encoding == `utf-16be`)
+	case `utf-32le`, `utf-32be`:
+		data = decodeUtf32(data[len(bom):], encoding == `utf-32be`)
+	default:
+		// binary files (or text in an encoding we can't identify) are skipped
+		if isBinaryData(data) {
+			return nil, nil
+		}
+
+		data = data[len(bom):]
+	}
+
+	text[`encoding`] = encoding
+	text[`bom`] = (len(bom) > 0)
+	text[`line_count`] = countLines(data)
+
+	if endings := detectLineEndings(data); endings != `` {
+		text[`line_endings`] = endings
+	}
+
+	language := TextLanguages[textExtension(name)]
+
+	if interpreter := parseShebang(data); interpreter != `` {
+		text[`interpreter`] = interpreter
+
+		// the shebang is more specific than the extension (if any)
+		if lang, ok := TextInterpreters[strings.TrimRight(interpreter, `0123456789.`)]; ok {
+			language = lang
+		}
+	}
+
+	if language != `` {
+		text[`language`] = language
+	}
+
+	return map[string]interface{}{
+		`text`: text,
+	}, nil
+}
+
+func isTextFile(name string) bool {
+	// media types win over language extensions (e.g.: ".ts" is also an MPEG transport stream)
+	switch GetGeneralFileType(name) {
+	case `code`:
+		return true
+	case `audio`, `video`, `image`:
+		return false
+	}
+
+	if mediaType := GetMimeType(name); strings.HasPrefix(mediaType, `text/`) || textMimeTypes[mediaType] {
+		return true
+	}
+
+	if _, ok := TextLanguages[textExtension(name)]; ok {
+		return true
+	}
+
+	// extensionless scripts are identified by their shebang
+	if filepath.Ext(name) == `` {
+		if file, err := os.Open(name); err == nil {
+			defer file.Close()
+
+			header := make([]byte, 2)
+
+			if _, err := io.ReadFull(file, header); err == nil {
+				return string(header) == `#!`
+			}
+		}
+	}
+
+	return false
+}
+
+// Returns the lowercase extension of the file, or the lowercase filename for files
+// conventionally named after their type (e.g.: Makefile, Dockerfile).
+func textExtension(name string) string {
+	base := strings.ToLower(filepath.Base(name))
+
+	switch base {
+	case `makefile`, `gnumakefile`:
+		return `makefile`
+	case `dockerfile`, `containerfile`:
+		return `dockerfile`
+	}
+
+	return strings.TrimPrefix(filepath.Ext(base), `.`)
+}
+
+func detectTextEncoding(data []byte) (string, []byte) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xEF\xBB\xBF")):
+		return `utf-8`, data[:3]
+	case bytes.HasPrefix(data, []byte("\xFF\xFE\x00\x00")):
+		return `utf-32le`, data[:4]
+	case bytes.HasPrefix(data, []byte("\x00\x00\xFE\xFF")):
+		return `utf-32be`, data[:4]
+	case bytes.HasPrefix(data, []byte("\xFF\xFE")):
+		return `utf-16le`, data[:2]
+	case bytes.HasPrefix(data, []byte("\xFE\xFF")):
+		return `utf-16be`, data[:2]
+	}
+
+	ascii := true
+
+	for _, b := range data {
+		if b >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+
+	if ascii {
+		return `ascii`, nil
+	} else if utf8.Valid(data) {
+		return `utf-8`, nil
+	} else {
+		return `iso-8859-1`, nil
+	}
+}
+
+// Text files essentially never contain null bytes, so their presence near the start indicates binary data.
+func isBinaryData(data []byte) bool {
+	if len(data) > TextBinaryCheckLength {
+		data = data[:TextBinaryCheckLength]
+	}
+
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+func decodeUtf16(data []byte, bigEndian bool) []byte {
+	units := make([]uint16, len(data)/2)
+
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+
+	return []byte(string(utf16.Decode(units)))
+}
+
+func decodeUtf32(data []byte, bigEndian bool) []byte {
+	runes := make([]rune, len(data)/4)
+
+	for i := range runes {
+		if bigEndian {
+			runes[i] = rune(binary.BigEndian.Uint32(data[4*i:]))
+		} else {
+			runes[i] = rune(binary.LittleEndian.Uint32(data[4*i:]))
+		}
+	}
+
+	return []byte(string(runes))
+}
+
+func countLines(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+
+	lines := bytes.Count(data, []byte("\n"))
+
+	// old Mac-style files only use carriage returns
+	if lines == 0 {
+		lines = bytes.Count(data, []byte("\r"))
+	}
+
+	// count a final line that has no trailing newline
+	if last := data[len(data)-1]; last != '\n' && last != '\r' {
+		lines += 1
+	}
+
+	return lines
+}
+
+// Returns one of "lf", "crlf", "cr" or "mixed"; or an empty string if the data contains no line breaks.
+func detectLineEndings(data []byte) string {
+	var lf, crlf, cr int
+
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '\r':
+			if i+1 < len(data) && data[i+1] == '\n' {
+				crlf += 1
+				i += 1
+			} else {
+				cr += 1
+			}
+		case '\n':
+			lf += 1
+		}
+	}
+
+	switch {
+	case lf > 0 && crlf == 0 && cr == 0:
+		return `lf`
+	case crlf > 0 && lf == 0 && cr == 0:
+		return `crlf`
+	case cr > 0 && lf == 0 && crlf == 0:
+		return `cr`
+	case lf+crlf+cr > 0:
+		return `mixed`
+	}
+
+	return ``
+}
+
+// Returns the name of the interpreter given in the data's shebang line (if any).
+func parseShebang(data []byte) string {
+	if !bytes.HasPrefix(data, []byte("#!")) {
+		return ``
+	}
+
+	line := data[2:]
+
+	if i := bytes.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(string(line))
+
+	if len(fields) == 0 {
+		return ``
+	}
+
+	interpreter := filepath.Base(fields[0])
+
+	// "#!/usr/bin/env [-S] python3" names the interpreter as an argument
+	if interpreter == `env` {
+		interpreter = ``
+
+		for _, arg := range fields[1:] {
+			if !strings.HasPrefix(arg, `-`) && !strings.Contains(arg, `=`) {
+				interpreter = filepath.Base(arg)
+				break
+			}
+		}
+	}
+
+	return interpreter
+}
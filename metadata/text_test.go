@@ -0,0 +1,161 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTextFile(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-text-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, `script`), []byte("#!/bin/sh\necho hi\n"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, `blob`), []byte("\x7FELF\x02\x01\x01"), 0755))
+
+	for name, expected := range map[string]bool{
+		`notes.txt`:       true,
+		`main.go`:         true,
+		`app.py`:          true,
+		`config.yaml`:     true,
+		`data.json`:       true,
+		`page.html`:       true,
+		`Makefile`:        true,
+		`Dockerfile`:      true,
+		`script`:          true,
+		`blob`:            false,
+		`missing`:         false,
+		`movie.mkv`:       false,
+		`song.mp3`:        false,
+		`photo.jpg`:       false,
+		`archive.zip`:     false,
+		`report.pdf`:      false,
+		`recording.ts`:    false,
+		`recording.m2ts`:  false,
+		`drawing.svg`:     false,
+		`playlist.m3u`:    false,
+		`program.exe`:     false,
+		`README.markdown`: true,
+	} {
+		assert.Equal(expected, isTextFile(filepath.Join(dir, name)), name)
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	assert := require.New(t)
+
+	for data, expected := range map[string]int{
+		``:                0,
+		"one":             1,
+		"one\n":           1,
+		"one\ntwo":        2,
+		"one\ntwo\n":      2,
+		"one\r\ntwo\r\n":  2,
+		"one\rtwo\rthree": 3,
+		"one\rtwo\r":      2,
+		"\n\n\n":          3,
+		"one\n\ntwo\n":    3,
+	} {
+		assert.Equal(expected, countLines([]byte(data)), "data: %q", data)
+	}
+}
+
+func TestDetectLineEndings(t *testing.T) {
+	assert := require.New(t)
+
+	for data, expected := range map[string]string{
+		``:                  ``,
+		"no breaks":         ``,
+		"one\ntwo\n":        `lf`,
+		"one\r\ntwo\r\n":    `crlf`,
+		"one\rtwo\r":        `cr`,
+		"one\r\ntwo\n":      `mixed`,
+		"one\rtwo\n":        `mixed`,
+		"one\r\ntwo\rthree": `mixed`,
+	} {
+		assert.Equal(expected, detectLineEndings([]byte(data)), "data: %q", data)
+	}
+}
+
+func TestParseShebang(t *testing.T) {
+	assert := require.New(t)
+
+	for data, expected := range map[string]string{
+		``:                                       ``,
+		"echo hi\n":                              ``,
+		"#!\n":                                   ``,
+		" #!/bin/sh\n":                           ``,
+		"#!/bin/sh":                              `sh`,
+		"#!/bin/bash\necho hi\n":                 `bash`,
+		"#! /usr/bin/perl -w\n":                  `perl`,
+		"#!/usr/bin/python3.8\r\nprint()\r\n":    `python3.8`,
+		"#!/usr/bin/env python3\n":               `python3`,
+		"#!/usr/bin/env -S node --harmony\n":     `node`,
+		"#!/usr/bin/env LANG=C ruby\n":           `ruby`,
+		"#!/usr/bin/env\n":                       ``,
+		"#!/usr/local/bin/Rscript --vanilla\n\n": `Rscript`,
+	} {
+		assert.Equal(expected, parseShebang([]byte(data)), "data: %q", data)
+	}
+}
+
+func TestDetectTextEncoding(t *testing.T) {
+	assert := require.New(t)
+
+	for data, expected := range map[string][]string{
+		``:                          {`ascii`, ``},
+		"plain text":                {`ascii`, ``},
+		"caf\xC3\xA9":               {`utf-8`, ``},
+		"\xEF\xBB\xBFcaf\xC3\xA9":   {`utf-8`, "\xEF\xBB\xBF"},
+		"caf\xE9":                   {`iso-8859-1`, ``},
+		"\xFF\xFEh\x00i\x00":        {`utf-16le`, "\xFF\xFE"},
+		"\xFE\xFF\x00h\x00i":        {`utf-16be`, "\xFE\xFF"},
+		"\xFF\xFE\x00\x00h\x00\x00": {`utf-32le`, "\xFF\xFE\x00\x00"},
+		"\x00\x00\xFE\xFF\x00\x00h": {`utf-32be`, "\x00\x00\xFE\xFF"},
+	} {
+		encoding, bom := detectTextEncoding([]byte(data))
+
+		assert.Equal(expected[0], encoding, "data: %q", data)
+		assert.Equal(expected[1], string(bom), "data: %q", data)
+	}
+
+	assert.Equal(`hi`, string(decodeUtf16([]byte("h\x00i\x00"), false)))
+	assert.Equal(`hi`, string(decodeUtf16([]byte("\x00h\x00i"), true)))
+	assert.Equal(`hi`, string(decodeUtf32([]byte("h\x00\x00\x00i\x00\x00\x00"), false)))
+	assert.Equal(`hi`, string(decodeUtf32([]byte("\x00\x00\x00h\x00\x00\x00i"), true)))
+}
+
+func TestTextLoader(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir(``, `metabase-text-`)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, `tool`)
+	assert.NoError(ioutil.WriteFile(name, []byte("\xFF\xFE#\x00!\x00/\x00b\x00i\x00n\x00/\x00s\x00h\x00\r\x00\n\x00"), 0755))
+
+	data, err := (&TextLoader{}).LoadMetadata(name)
+	assert.NoError(err)
+	assert.Equal(map[string]interface{}{
+		`encoding`:     `utf-16le`,
+		`bom`:          true,
+		`line_count`:   1,
+		`line_endings`: `crlf`,
+		`interpreter`:  `sh`,
+		`language`:     `Shell`,
+	}, data[`text`])
+
+	// binary data is skipped
+	assert.NoError(ioutil.WriteFile(name, []byte("#!\x00\x01\x02"), 0755))
+
+	data, err = (&TextLoader{}).LoadMetadata(name)
+	assert.NoError(err)
+	assert.Nil(data)
+}
@@ -2,13 +2,15 @@ package metadata
 
 import (
 	"encoding/json"
-	"os/exec"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/ghetzel/go-stockutil/maputil"
 	"github.com/ghetzel/go-stockutil/sliceutil"
-	"github.com/ghetzel/go-stockutil/stringutil"
-	"github.com/ghetzel/go-stockutil/typeutil"
 )
 
 var FFProbeCommandName = `ffprobe`
@@ -17,13 +19,89 @@ var FFProbeCommandArguments = []string{
 	`-show_format`,
 	`-print_format`, `json`,
 	`-show_streams`,
+	`-show_frames`,
+	`-read_intervals`, `%+#5`,
 	`{source}`,
 }
 
+// How long ffprobe may run before being killed; zero means no limit.
+var FFProbeTimeout = 2 * time.Minute
+
 var FFProbeOmitFields = []string{
 	`format.filename`,
 }
 
+// Whether to store the complete ffprobe output under "video.ffprobe" alongside the normalized fields.
+var FFProbeIncludeRaw = false
+
+type VideoResolution struct {
+	Label  string
+	Width  int
+	Height int
+}
+
+// Resolution labels, from largest to smallest.  A video gets the first label whose width or height
+// it reaches (allowing for letterboxed and cropped encodes).
+var VideoResolutions = []VideoResolution{
+	{`4320p`, 7600, 4320},
+	{`2160p`, 3800, 2160},
+	{`1440p`, 2500, 1440},
+	{`1080p`, 1900, 1080},
+	{`720p`, 1260, 720},
+	{`576p`, 1000, 576},
+	{`480p`, 840, 480},
+}
+
+var rxPixelFormatDepth = regexp.MustCompile(`p(\d{1,2})(?:le|be)?$`)
+
+// Only the first few packets are decoded (see FFProbeCommandArguments); HDR10+ metadata is only
+// present on frames rather than on the stream itself.
+type ffprobeOutput struct {
+	Format  ffprobeFormat   `json:"format"`
+	Streams []ffprobeStream `json:"streams"`
+	Frames  []ffprobeFrame  `json:"frames"`
+}
+
+type ffprobeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+}
+
+type ffprobeStream struct {
+	Index            int                      `json:"index"`
+	CodecName        string                   `json:"codec_name"`
+	CodecType        string                   `json:"codec_type"`
+	Profile          string                   `json:"profile"`
+	Width            int                      `json:"width"`
+	Height           int                      `json:"height"`
+	PixelFormat      string                   `json:"pix_fmt"`
+	BitsPerRawSample string                   `json:"bits_per_raw_sample"`
+	AvgFrameRate     string                   `json:"avg_frame_rate"`
+	RealFrameRate    string                   `json:"r_frame_rate"`
+	ColorTransfer    string                   `json:"color_transfer"`
+	Channels         int                      `json:"channels"`
+	ChannelLayout    string                   `json:"channel_layout"`
+	Disposition      map[string]int           `json:"disposition"`
+	Tags             map[string]string        `json:"tags"`
+	SideData         []map[string]interface{} `json:"side_data_list"`
+}
+
+type ffprobeFrame struct {
+	StreamIndex int                      `json:"stream_index"`
+	SideData    []map[string]interface{} `json:"side_data_list"`
+}
+
+func (self *ffprobeStream) tag(name string) string {
+	for key, value := range self.Tags {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+
+	return ``
+}
+
 type VideoLoader struct {
 	Loader
 }
@@ -36,70 +114,269 @@ func (self *VideoLoader) CanHandle(filename string) Loader {
 	return nil
 }
 
+func (self *VideoLoader) LoaderVersion() string {
+	if FFProbeIncludeRaw {
+		return `3+raw`
+	}
+
+	return `3`
+}
+
+// The loader owns the "video" namespace, so fields from earlier scans (including the "video.format"
+// and "video.streams" keys written by older versions) are cleared rather than left alongside the
+// current ones.
+func (self *VideoLoader) Replaces() []string {
+	return []string{`video`}
+}
+
 func (self *VideoLoader) LoadMetadata(name string) (map[string]interface{}, error) {
-	if info, err := self.probeVideoInfo(name); err == nil {
-		var duration interface{}
+	data, err := runCommand(FFProbeTimeout, FFProbeCommandName, FFProbeCommandArguments, name)
+
+	if err != nil {
+		return nil, err
+	}
 
-		if dSecI := maputil.DeepGet(info, []string{`format`, `duration`}, nil); dSecI != nil {
-			if dSecF, err := stringutil.ConvertToFloat(dSecI); err == nil {
-				duration = int64(dSecF * float64(1000))
+	var probe ffprobeOutput
+
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, err
+	}
+
+	video := normalizeVideoInfo(name, &probe)
+
+	if FFProbeIncludeRaw {
+		var raw map[string]interface{}
+
+		if err := json.Unmarshal(data, &raw); err == nil {
+			// the sampled frames are only used to detect HDR formats
+			delete(raw, `frames`)
+
+			// recursively walk through all metadata values, autotyping and dropping empty ones
+			if raw, err := autotypeMap(raw, FFProbeOmitFields); err == nil {
+				video[`ffprobe`] = raw
+			} else {
+				return nil, err
 			}
+		} else {
+			return nil, err
 		}
+	}
 
-		return map[string]interface{}{
-			`media`: map[string]interface{}{
-				`type`:     `video`,
-				`duration`: duration,
-			},
-			`video`: info,
-		}, nil
-	} else {
-		return nil, err
+	var duration interface{}
+
+	if seconds, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		duration = int64(seconds * float64(1000))
 	}
+
+	return map[string]interface{}{
+		`media`: map[string]interface{}{
+			`type`:     `video`,
+			`duration`: duration,
+		},
+		`video`: video,
+	}, nil
 }
 
-// Loads video metadata from the source file
-func (self *VideoLoader) probeVideoInfo(filename string) (map[string]interface{}, error) {
-	rv := make(map[string]interface{})
+func normalizeVideoInfo(name string, probe *ffprobeOutput) map[string]interface{} {
+	video := make(map[string]interface{})
+	audioTracks := make([]map[string]interface{}, 0)
+	audioLanguages := make([]string, 0)
+	subtitleTracks := make([]map[string]interface{}, 0)
+	subtitleLanguages := make([]string, 0)
 
-	args := make([]string, len(FFProbeCommandArguments))
-	copy(args, FFProbeCommandArguments)
+	// ffprobe reports every format a demuxer handles (e.g.: "mov,mp4,m4a,3gp,3g2,mj2"), so prefer
+	// the one matching the file's extension
+	if formats := strings.Split(probe.Format.FormatName, `,`); formats[0] != `` {
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), `.`))
 
-	for i, _ := range args {
-		args[i] = strings.Replace(
-			args[i], `{source}`, filename, -1,
-		)
+		if sliceutil.ContainsString(formats, ext) {
+			video[`container`] = ext
+		} else {
+			video[`container`] = formats[0]
+		}
 	}
 
-	probe := exec.Command(FFProbeCommandName, args...)
-	probe.Env = []string{
-		`AV_LOG_FORCE_NOCOLOR=1`,
+	if bitrate, err := strconv.ParseInt(probe.Format.BitRate, 10, 64); err == nil {
+		video[`bitrate`] = bitrate
 	}
 
-	if data, err := probe.Output(); err == nil {
-		var metadata map[string]interface{}
+	var primary *ffprobeStream
 
-		if err := json.Unmarshal(data, &metadata); err == nil {
-			// recursively walk through all metadata values, autotyping and dropping empty ones
-			if err := maputil.Walk(metadata, func(value interface{}, path []string, isLeaf bool) error {
-				if isLeaf {
-					if !sliceutil.Contains(FFProbeOmitFields, strings.Join(path, `.`)) {
-						if !typeutil.IsEmpty(value) {
-							maputil.DeepSet(rv, path, stringutil.Autotype(value))
-						}
-					}
-				}
-
-				return nil
-			}); err != nil {
-				return nil, err
+	for i, stream := range probe.Streams {
+		switch stream.CodecType {
+		case `video`:
+			// embedded cover art shows up as a video stream
+			if primary == nil && stream.Disposition[`attached_pic`] == 0 {
+				primary = &probe.Streams[i]
+			}
+
+		case `audio`:
+			track := streamTrack(&stream)
+
+			if stream.Channels > 0 {
+				track[`channels`] = stream.Channels
+			}
+
+			if stream.ChannelLayout != `` {
+				track[`channel_layout`] = stream.ChannelLayout
+			}
+
+			audioTracks = append(audioTracks, track)
+
+			if language, ok := track[`language`].(string); ok && !sliceutil.ContainsString(audioLanguages, language) {
+				audioLanguages = append(audioLanguages, language)
+			}
+
+		case `subtitle`:
+			track := streamTrack(&stream)
+			subtitleTracks = append(subtitleTracks, track)
+
+			if language, ok := track[`language`].(string); ok && !sliceutil.ContainsString(subtitleLanguages, language) {
+				subtitleLanguages = append(subtitleLanguages, language)
+			}
+		}
+	}
+
+	if primary != nil {
+		video[`codec`] = primary.CodecName
+		video[`profile`] = primary.Profile
+		video[`width`] = primary.Width
+		video[`height`] = primary.Height
+
+		for _, resolution := range VideoResolutions {
+			if primary.Width >= resolution.Width || primary.Height >= resolution.Height {
+				video[`resolution`] = resolution.Label
+				break
 			}
+		}
+
+		if _, ok := video[`resolution`]; !ok && primary.Height > 0 {
+			video[`resolution`] = `sd`
+		}
+
+		if rate, ok := parseFrameRate(primary.AvgFrameRate); ok {
+			video[`frame_rate`] = rate
+		} else if rate, ok := parseFrameRate(primary.RealFrameRate); ok {
+			video[`frame_rate`] = rate
+		}
+
+		if depth, err := strconv.Atoi(primary.BitsPerRawSample); err == nil && depth > 0 {
+			video[`bit_depth`] = depth
+		} else if match := rxPixelFormatDepth.FindStringSubmatch(primary.PixelFormat); match != nil {
+			video[`bit_depth`], _ = strconv.Atoi(match[1])
+		} else if primary.PixelFormat != `` {
+			video[`bit_depth`] = 8
+		}
+
+		if format := hdrFormat(primary, probe.Frames); format != `` {
+			video[`hdr`] = true
+			video[`hdr_format`] = format
 		} else {
-			return nil, err
+			video[`hdr`] = false
+		}
+	}
+
+	if len(audioTracks) > 0 {
+		sort.Strings(audioLanguages)
+		video[`audio_tracks`] = audioTracks
+		video[`audio_languages`] = audioLanguages
+	}
+
+	if len(subtitleTracks) > 0 {
+		sort.Strings(subtitleLanguages)
+		video[`subtitle_tracks`] = subtitleTracks
+		video[`subtitle_languages`] = subtitleLanguages
+	}
+
+	return video
+}
+
+// The fields shared by audio and subtitle tracks.
+func streamTrack(stream *ffprobeStream) map[string]interface{} {
+	track := map[string]interface{}{
+		`index`:   stream.Index,
+		`codec`:   stream.CodecName,
+		`default`: stream.Disposition[`default`] == 1,
+		`forced`:  stream.Disposition[`forced`] == 1,
+	}
+
+	if language := normalizeLanguage(stream.tag(`language`)); language != `` {
+		track[`language`] = language
+	}
+
+	if title := stream.tag(`title`); title != `` {
+		track[`title`] = title
+	}
+
+	return track
+}
+
+// Convert a language code to ISO 639-1 where possible; unknown codes are returned as-is.
+func normalizeLanguage(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	switch code {
+	case ``, `und`, `unk`, `mis`, `mul`, `zxx`:
+		return ``
+	}
+
+	if language, ok := SidecarLanguages[code]; ok {
+		return language
+	}
+
+	return code
+}
+
+// Parse an ffprobe rational frame rate (e.g.: "24000/1001") into frames per second.
+func parseFrameRate(value string) (float64, bool) {
+	parts := strings.SplitN(value, `/`, 2)
+
+	if num, err := strconv.ParseFloat(parts[0], 64); err == nil && num > 0 {
+		den := float64(1)
+
+		if len(parts) == 2 {
+			if d, err := strconv.ParseFloat(parts[1], 64); err == nil && d > 0 {
+				den = d
+			} else {
+				return 0, false
+			}
+		}
+
+		return math.Round(num/den*1000) / 1000, true
+	}
+
+	return 0, false
+}
+
+// Identify the HDR format of a video stream.  Dolby Vision is described by the stream's side data,
+// HDR10+ by the dynamic metadata attached to its frames.
+func hdrFormat(stream *ffprobeStream, frames []ffprobeFrame) string {
+	if hasSideData(stream.SideData, `DOVI`) {
+		return `dolby_vision`
+	}
+
+	for _, frame := range frames {
+		if frame.StreamIndex == stream.Index && hasSideData(frame.SideData, `SMPTE2094-40`) {
+			return `hdr10+`
+		}
+	}
+
+	switch stream.ColorTransfer {
+	case `smpte2084`:
+		return `hdr10`
+	case `arib-std-b67`:
+		return `hlg`
+	}
+
+	return ``
+}
+
+func hasSideData(sideData []map[string]interface{}, kind string) bool {
+	for _, data := range sideData {
+		if value, ok := data[`side_data_type`].(string); ok && strings.Contains(value, kind) {
+			return true
 		}
-	} else {
-		return nil, err
 	}
 
-	return rv, nil
+	return false
 }
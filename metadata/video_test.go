@@ -0,0 +1,165 @@
+package metadata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testFFProbeOutput = `{
+	"streams": [{
+		"index": 0,
+		"codec_name": "hevc",
+		"codec_type": "video",
+		"profile": "Main 10",
+		"width": 3840,
+		"height": 1600,
+		"pix_fmt": "yuv420p10le",
+		"color_transfer": "smpte2084",
+		"r_frame_rate": "24000/1001",
+		"avg_frame_rate": "0/0",
+		"disposition": {"default": 1, "attached_pic": 0}
+	}, {
+		"index": 1,
+		"codec_name": "eac3",
+		"codec_type": "audio",
+		"channels": 6,
+		"channel_layout": "5.1(side)",
+		"disposition": {"default": 1, "forced": 0},
+		"tags": {"LANGUAGE": "eng", "title": "Surround"}
+	}, {
+		"index": 2,
+		"codec_name": "aac",
+		"codec_type": "audio",
+		"channels": 2,
+		"disposition": {"default": 0},
+		"tags": {"language": "ger"}
+	}, {
+		"index": 3,
+		"codec_name": "subrip",
+		"codec_type": "subtitle",
+		"disposition": {"default": 0, "forced": 1},
+		"tags": {"language": "fre"}
+	}, {
+		"index": 4,
+		"codec_name": "hdmv_pgs_subtitle",
+		"codec_type": "subtitle",
+		"disposition": {},
+		"tags": {"language": "und"}
+	}, {
+		"index": 5,
+		"codec_name": "mjpeg",
+		"codec_type": "video",
+		"width": 600,
+		"height": 900,
+		"pix_fmt": "yuvj420p",
+		"disposition": {"attached_pic": 1}
+	}],
+	"frames": [{
+		"stream_index": 1,
+		"side_data_list": [{"side_data_type": "HDR Dynamic Metadata SMPTE2094-40 (HDR10+)"}]
+	}, {
+		"stream_index": 0,
+		"side_data_list": [{"side_data_type": "Mastering display metadata"}]
+	}],
+	"format": {
+		"filename": "/media/movie.mkv",
+		"format_name": "matroska,webm",
+		"duration": "7260.512000",
+		"bit_rate": "18000000"
+	}
+}`
+
+func TestNormalizeVideoInfo(t *testing.T) {
+	assert := require.New(t)
+
+	var probe ffprobeOutput
+	assert.NoError(json.Unmarshal([]byte(testFFProbeOutput), &probe))
+
+	assert.Equal(map[string]interface{}{
+		`container`:  `matroska`,
+		`bitrate`:    int64(18000000),
+		`codec`:      `hevc`,
+		`profile`:    `Main 10`,
+		`width`:      3840,
+		`height`:     1600,
+		`resolution`: `2160p`,
+		`frame_rate`: 23.976,
+		`bit_depth`:  10,
+		`hdr`:        true,
+		`hdr_format`: `hdr10`,
+		`audio_tracks`: []map[string]interface{}{
+			{`index`: 1, `codec`: `eac3`, `default`: true, `forced`: false, `language`: `en`, `title`: `Surround`, `channels`: 6, `channel_layout`: `5.1(side)`},
+			{`index`: 2, `codec`: `aac`, `default`: false, `forced`: false, `language`: `de`, `channels`: 2},
+		},
+		`audio_languages`: []string{`de`, `en`},
+		`subtitle_tracks`: []map[string]interface{}{
+			{`index`: 3, `codec`: `subrip`, `default`: false, `forced`: true, `language`: `fr`},
+			{`index`: 4, `codec`: `hdmv_pgs_subtitle`, `default`: false, `forced`: false},
+		},
+		`subtitle_languages`: []string{`fr`},
+	}, normalizeVideoInfo(`/media/movie.mkv`, &probe))
+
+	// the container matching the extension is preferred
+	probe.Format.FormatName = `mov,mp4,m4a,3gp,3g2,mj2`
+	probe.Streams = probe.Streams[:1]
+	probe.Streams[0].Width = 1280
+	probe.Streams[0].Height = 536
+	probe.Streams[0].PixelFormat = `yuv420p`
+	probe.Streams[0].ColorTransfer = `bt709`
+
+	video := normalizeVideoInfo(`/media/movie.mp4`, &probe)
+	assert.Equal(`mp4`, video[`container`])
+	assert.Equal(`720p`, video[`resolution`])
+	assert.Equal(8, video[`bit_depth`])
+	assert.Equal(false, video[`hdr`])
+	assert.NotContains(video, `audio_tracks`)
+	assert.NotContains(video, `subtitle_languages`)
+}
+
+func TestParseFrameRate(t *testing.T) {
+	assert := require.New(t)
+
+	for value, expected := range map[string]float64{
+		`24000/1001`: 23.976,
+		`30000/1001`: 29.97,
+		`25/1`:       25,
+		`50`:         50,
+		`0/0`:        0,
+		`25/0`:       0,
+		`1/x`:        0,
+		``:           0,
+	} {
+		rate, ok := parseFrameRate(value)
+		assert.Equal(expected != 0, ok, value)
+		assert.Equal(expected, rate, value)
+	}
+}
+
+func TestHdrFormat(t *testing.T) {
+	assert := require.New(t)
+
+	dovi := []map[string]interface{}{{`side_data_type`: `DOVI configuration record`}}
+	hdr10plus := []map[string]interface{}{{`side_data_type`: `HDR Dynamic Metadata SMPTE2094-40 (HDR10+)`}}
+
+	for _, tc := range []struct {
+		stream   ffprobeStream
+		frames   []ffprobeFrame
+		expected string
+	}{
+		{ffprobeStream{ColorTransfer: `bt709`}, nil, ``},
+		{ffprobeStream{ColorTransfer: `smpte2084`}, nil, `hdr10`},
+		{ffprobeStream{ColorTransfer: `arib-std-b67`}, nil, `hlg`},
+		{ffprobeStream{ColorTransfer: `smpte2084`, SideData: dovi}, nil, `dolby_vision`},
+		{ffprobeStream{ColorTransfer: `smpte2084`, SideData: dovi}, []ffprobeFrame{{SideData: hdr10plus}}, `dolby_vision`},
+		{ffprobeStream{ColorTransfer: `smpte2084`}, []ffprobeFrame{{SideData: hdr10plus}}, `hdr10+`},
+
+		// HDR10+ metadata on the frames of another stream
+		{ffprobeStream{ColorTransfer: `smpte2084`}, []ffprobeFrame{{StreamIndex: 1, SideData: hdr10plus}}, `hdr10`},
+	} {
+		assert.Equal(tc.expected, hdrFormat(&tc.stream, tc.frames), "%+v", tc)
+	}
+
+	assert.Equal([]string{`video`}, (&VideoLoader{}).Replaces())
+}
@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/go-stockutil/stringutil"
+)
+
+var ErrXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// Checksums are stored in attributes named after their algorithm (e.g.: "user.checksum.sha256").
+var XattrChecksumPrefix = `user.checksum.`
+var XattrChecksumModTimeAttribute = `user.checksum.mtime`
+var XattrMetadataPrefix = `user.metabase.`
+
+// attributes whose values are comma-separated lists
+var XattrListAttributes = []string{
+	`user.xdg.tags`,
+}
+
+type XattrLoader struct {
+	Loader
+}
+
+func (self *XattrLoader) CanHandle(_ string) Loader {
+	return self
+}
+
+func (self *XattrLoader) LoadMetadata(name string) (map[string]interface{}, error) {
+	if attrs, err := ReadXattrs(name); err == nil {
+		metadata := make(map[string]interface{})
+
+		for key, value := range attrs {
+			metadata[`xattr.`+key] = DecodeXattrValue(key, value)
+		}
+
+		return metadata, nil
+	} else if err == ErrXattrUnsupported {
+		return nil, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Retrieve a checksum of the given algorithm stored in extended attributes.  Checksums written
+// alongside a modification time are only trusted if the file has not been modified since; those
+// written by other tools are trusted as-is, the same way checksum sidecar files are.
+func GetXattrChecksum(name string, algorithm string) (string, bool) {
+	if stat, err := os.Stat(name); err == nil {
+		if attrs, err := ReadXattrs(name); err == nil {
+			if sum, ok := attrs[XattrChecksumPrefix+algorithm]; ok && sum != `` {
+				if mtime, ok := attrs[XattrChecksumModTimeAttribute]; ok {
+					if mtime == fmt.Sprintf("%d", stat.ModTime().UnixNano()) {
+						return sum, true
+					}
+				} else {
+					return sum, true
+				}
+			}
+		}
+	}
+
+	return ``, false
+}
+
+// Return the attributes that record the given checksum along with the file's current
+// modification time.
+func XattrChecksumAttributes(name string, algorithm string, sum string) (map[string]string, error) {
+	if stat, err := os.Stat(name); err == nil {
+		return map[string]string{
+			XattrChecksumPrefix + algorithm: sum,
+			XattrChecksumModTimeAttribute:   fmt.Sprintf("%d", stat.ModTime().UnixNano()),
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Write the given attributes, skipping any that already hold the same value.
+func UpdateXattrs(name string, attrs map[string]string) error {
+	if existing, err := ReadXattrs(name); err == nil {
+		if changed := changedXattrs(existing, attrs); len(changed) > 0 {
+			return WriteXattrs(name, changed)
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+func changedXattrs(existing map[string]string, attrs map[string]string) map[string]string {
+	changed := make(map[string]string)
+
+	for key, value := range attrs {
+		if current, ok := existing[key]; !ok || current != value {
+			changed[key] = value
+		}
+	}
+
+	return changed
+}
+
+// Encode a metadata value for storage in an extended attribute.  Lists and maps are stored as
+// JSON so they can be decoded by DecodeXattrValue.
+func EncodeXattrValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		if data, err := json.Marshal(value); err == nil {
+			return string(data)
+		}
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// Decode the value of the named extended attribute.  Attributes in XattrListAttributes are split
+// on commas, and values written by EncodeXattrValue are converted back to their original types.
+func DecodeXattrValue(key string, value string) interface{} {
+	if sliceutil.ContainsString(XattrListAttributes, key) {
+		return sliceutil.CompactString(strings.Split(value, `,`))
+	}
+
+	if strings.HasPrefix(key, XattrMetadataPrefix) {
+		if strings.HasPrefix(value, `[`) || strings.HasPrefix(value, `{`) {
+			var decoded interface{}
+
+			if err := json.Unmarshal([]byte(value), &decoded); err == nil {
+				return decoded
+			}
+		}
+
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t
+		}
+
+		return stringutil.Autotype(value)
+	}
+
+	return value
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package metadata
+
+func ReadXattrs(name string) (map[string]string, error) {
+	return nil, ErrXattrUnsupported
+}
+
+func WriteXattrs(name string, attrs map[string]string) error {
+	return ErrXattrUnsupported
+}
@@ -0,0 +1,87 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattrValueEncoding(t *testing.T) {
+	assert := require.New(t)
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.Equal(`hello`, EncodeXattrValue(`hello`))
+	assert.Equal(`42`, EncodeXattrValue(42))
+	assert.Equal(`2020-01-02T03:04:05Z`, EncodeXattrValue(ts))
+	assert.Equal(`["a","b, c"]`, EncodeXattrValue([]string{`a`, `b, c`}))
+	assert.Equal(`{"x":1}`, EncodeXattrValue(map[string]interface{}{`x`: 1}))
+
+	assert.Equal(`hello`, DecodeXattrValue(XattrMetadataPrefix+`media.title`, `hello`))
+	assert.Equal(ts, DecodeXattrValue(XattrMetadataPrefix+`media.aired`, `2020-01-02T03:04:05Z`))
+	assert.Equal([]interface{}{`a`, `b, c`}, DecodeXattrValue(XattrMetadataPrefix+`media.tags`, `["a","b, c"]`))
+	assert.Equal(`[not json`, DecodeXattrValue(XattrMetadataPrefix+`media.title`, `[not json`))
+
+	// attributes written by other tools are left alone
+	assert.Equal(`42`, DecodeXattrValue(`user.xdg.origin.url`, `42`))
+	assert.Equal([]string{`one`, `two`}, DecodeXattrValue(`user.xdg.tags`, `one,,two`))
+}
+
+func TestChangedXattrs(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(map[string]string{
+		`user.b`: `2`,
+		`user.c`: `3`,
+	}, changedXattrs(map[string]string{
+		`user.a`: `1`,
+		`user.b`: `1`,
+	}, map[string]string{
+		`user.a`: `1`,
+		`user.b`: `2`,
+		`user.c`: `3`,
+	}))
+
+	assert.Empty(changedXattrs(map[string]string{`user.a`: `1`}, map[string]string{`user.a`: `1`}))
+}
+
+func TestXattrChecksum(t *testing.T) {
+	assert := require.New(t)
+
+	file, err := ioutil.TempFile(``, `metabase-xattr-`)
+	assert.NoError(err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := WriteXattrs(file.Name(), map[string]string{`user.test`: `1`}); err != nil {
+		t.Skipf("extended attributes unavailable: %v", err)
+	}
+
+	// written by another tool, without a modification time
+	assert.NoError(WriteXattrs(file.Name(), map[string]string{
+		XattrChecksumPrefix + `sha256`: `abc123`,
+	}))
+
+	sum, ok := GetXattrChecksum(file.Name(), `sha256`)
+	assert.True(ok)
+	assert.Equal(`abc123`, sum)
+
+	_, ok = GetXattrChecksum(file.Name(), `sha1`)
+	assert.False(ok)
+
+	attrs, err := XattrChecksumAttributes(file.Name(), `sha256`, `def456`)
+	assert.NoError(err)
+	assert.NoError(UpdateXattrs(file.Name(), attrs))
+
+	sum, ok = GetXattrChecksum(file.Name(), `sha256`)
+	assert.True(ok)
+	assert.Equal(`def456`, sum)
+
+	// modifying the file invalidates checksums we wrote
+	assert.NoError(os.Chtimes(file.Name(), time.Now(), time.Now().Add(time.Hour)))
+
+	_, ok = GetXattrChecksum(file.Name(), `sha256`)
+	assert.False(ok)
+}
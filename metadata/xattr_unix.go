@@ -0,0 +1,73 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package metadata
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+func ReadXattrs(name string) (map[string]string, error) {
+	attrs := make(map[string]string)
+
+	if size, err := unix.Listxattr(name, nil); err == nil {
+		if size == 0 {
+			return attrs, nil
+		}
+
+		buf := make([]byte, size)
+
+		if size, err = unix.Listxattr(name, buf); err != nil {
+			return nil, xattrError(err)
+		}
+
+		for _, key := range bytes.Split(buf[:size], []byte{0}) {
+			if len(key) == 0 {
+				continue
+			}
+
+			// attributes can disappear between listing and reading them, so skip any we can't read
+			if value, err := getXattr(name, string(key)); err == nil {
+				attrs[string(key)] = value
+			}
+		}
+
+		return attrs, nil
+	} else {
+		return nil, xattrError(err)
+	}
+}
+
+func WriteXattrs(name string, attrs map[string]string) error {
+	for key, value := range attrs {
+		if err := unix.Setxattr(name, key, []byte(value), 0); err != nil {
+			return xattrError(err)
+		}
+	}
+
+	return nil
+}
+
+func getXattr(name string, key string) (string, error) {
+	if size, err := unix.Getxattr(name, key, nil); err == nil {
+		buf := make([]byte, size)
+
+		if size, err = unix.Getxattr(name, key, buf); err == nil {
+			return string(buf[:size]), nil
+		} else {
+			return ``, err
+		}
+	} else {
+		return ``, err
+	}
+}
+
+func xattrError(err error) error {
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		return ErrXattrUnsupported
+	}
+
+	return err
+}
@@ -5,3 +5,4 @@ import (
 )
 
 var Metadata mapper.Mapper
+var Chunks mapper.Mapper
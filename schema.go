@@ -58,6 +58,18 @@ var MetadataSchema = &dal.Collection{
 		}, {
 			Name: `metadata`,
 			Type: dal.ObjectType,
+		}, {
+			Name: `container`,
+			Type: dal.StringType,
+		}, {
+			Name: `attached_to`,
+			Type: dal.StringType,
+		}, {
+			Name: `attachments`,
+			Type: dal.ArrayType,
+		}, {
+			Name: `hidden`,
+			Type: dal.BooleanType,
 		},
 	},
 }
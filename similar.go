@@ -0,0 +1,177 @@
+package metabase
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ghetzel/go-stockutil/sliceutil"
+	"github.com/ghetzel/metabase/metadata"
+)
+
+var DefaultSimilarityThreshold = 6
+
+// A BK-tree indexing 64-bit hashes by Hamming distance, used to find near neighbors
+// without comparing every pair of hashes.
+type hashNode struct {
+	hash     uint64
+	indices  []int
+	children map[int]*hashNode
+}
+
+func (self *hashNode) insert(hash uint64, index int) {
+	node := self
+
+	for {
+		distance := metadata.HammingDistance(node.hash, hash)
+
+		if distance == 0 {
+			node.indices = append(node.indices, index)
+			return
+		}
+
+		if child, ok := node.children[distance]; ok {
+			node = child
+		} else {
+			node.children[distance] = &hashNode{
+				hash:     hash,
+				indices:  []int{index},
+				children: make(map[int]*hashNode),
+			}
+
+			return
+		}
+	}
+}
+
+func (self *hashNode) search(hash uint64, threshold int, fn func(index int)) {
+	distance := metadata.HammingDistance(self.hash, hash)
+
+	if distance <= threshold {
+		for _, index := range self.indices {
+			fn(index)
+		}
+	}
+
+	for d, child := range self.children {
+		if d >= distance-threshold && d <= distance+threshold {
+			child.search(hash, threshold, fn)
+		}
+	}
+}
+
+// Return groups of image entries whose perceptual hashes (of the given type: ahash, dhash, or phash)
+// differ by no more than threshold bits from the first entry in the group (see clusterHashes).  If
+// no root groups are given, all of them are searched.  Groups are sorted from largest to smallest.
+func (self *DB) FindSimilarImages(hashType string, threshold int, rootGroups ...string) ([][]*Entry, error) {
+	if !sliceutil.ContainsString(metadata.PerceptualHashTypes, hashType) {
+		return nil, fmt.Errorf("Unsupported hash type %q", hashType)
+	}
+
+	if threshold < 0 {
+		threshold = DefaultSimilarityThreshold
+	}
+
+	query := map[string]interface{}{
+		`type`: `image`,
+	}
+
+	if len(rootGroups) > 0 {
+		query[`root_group`] = rootGroups
+	}
+
+	if f, err := ParseFilter(query); err == nil {
+		entries := make([]*Entry, 0)
+		hashes := make([]uint64, 0)
+
+		f.Limit = 0
+		f.Sort = []string{`id`}
+		f.Fields = []string{`id`, `name`, `root_group`, `parent`, `size`, `checksum`, `metadata`}
+
+		if err := Metadata.FindFunc(f, Entry{}, func(entryI interface{}, err error) {
+			if err == nil {
+				if entry, ok := entryI.(*Entry); ok {
+					if v, ok := entry.Get(`image.hash.` + hashType).(string); ok {
+						if hash, err := metadata.ParseHash(v); err == nil {
+							entries = append(entries, entry)
+							hashes = append(hashes, hash)
+						}
+					}
+				}
+			} else {
+				log.Warningf("Error reading entry: %v", err)
+			}
+		}); err != nil {
+			return nil, err
+		}
+
+		if len(entries) == 0 {
+			return nil, nil
+		}
+
+		results := make([][]*Entry, 0)
+
+		for _, cluster := range clusterHashes(hashes, threshold) {
+			if len(cluster) > 1 {
+				group := make([]*Entry, len(cluster))
+
+				for i, index := range cluster {
+					group[i] = entries[index]
+				}
+
+				results = append(results, group)
+			}
+		}
+
+		sort.SliceStable(results, func(i, j int) bool {
+			return len(results[i]) > len(results[j])
+		})
+
+		return results, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Group the given hashes into clusters of indices.  Each unclustered hash (in order) starts a new
+// cluster containing every other unclustered hash within threshold bits of it.  Unlike joining
+// every pair of close hashes, this keeps a chain of small differences from grouping together very
+// different images: no two members of a cluster differ by more than twice the threshold.
+func clusterHashes(hashes []uint64, threshold int) [][]int {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	tree := &hashNode{
+		hash:     hashes[0],
+		indices:  []int{0},
+		children: make(map[int]*hashNode),
+	}
+
+	for i := 1; i < len(hashes); i++ {
+		tree.insert(hashes[i], i)
+	}
+
+	clustered := make([]bool, len(hashes))
+	clusters := make([][]int, 0)
+
+	for i, hash := range hashes {
+		if clustered[i] {
+			continue
+		}
+
+		cluster := []int{i}
+		clustered[i] = true
+
+		tree.search(hash, threshold, func(j int) {
+			if !clustered[j] {
+				clustered[j] = true
+				cluster = append(cluster, j)
+			}
+		})
+
+		sort.Ints(cluster[1:])
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters
+}
@@ -0,0 +1,38 @@
+package metabase
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterHashes(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Nil(clusterHashes(nil, 4))
+
+	assert.Equal([][]int{
+		{0, 2, 3},
+		{1},
+		{4},
+	}, clusterHashes([]uint64{
+		0x0000000000000000,
+		0xffffffffffffffff,
+		0x0000000000000001,
+		0x0000000000000000,
+		0x00000000ffff0000,
+	}, 2))
+
+	// a chain of hashes that each differ from the next by 2 bits is not collapsed into one cluster
+	assert.Equal([][]int{
+		{0, 1},
+		{2, 3},
+		{4},
+	}, clusterHashes([]uint64{
+		0x0000000000000000,
+		0x0000000000000003,
+		0x000000000000000f,
+		0x000000000000003f,
+		0x00000000000000ff,
+	}, 2))
+}
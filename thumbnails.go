@@ -0,0 +1,371 @@
+package metabase
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/typeutil"
+	"golang.org/x/image/draw"
+)
+
+var FFMpegCommandName = `ffmpeg`
+var DefaultThumbnailSize = 256
+var MaxThumbnailSize = 4096
+var ThumbnailQuality = 85
+var ThumbnailTimeout = 60 * time.Second
+
+// The color that transparent areas of images are composited onto.
+var ThumbnailBackground color.Color = color.White
+
+// Return the path to a JPEG thumbnail of the given entry that fits within size x size pixels,
+// generating it if it does not already exist in the cache.
+func (self *DB) GetThumbnail(entry *Entry, size int) (string, error) {
+	if size <= 0 {
+		size = DefaultThumbnailSize
+	} else if size > MaxThumbnailSize {
+		return ``, fmt.Errorf("Thumbnail size %d exceeds the maximum of %d", size, MaxThumbnailSize)
+	}
+
+	switch entry.Type {
+	case `image`, `video`:
+		break
+	default:
+		return ``, fmt.Errorf("Cannot generate thumbnails for entries of type %q", entry.Type)
+	}
+
+	thumbPath := self.thumbnailPath(entry, size)
+
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath, nil
+	} else if !os.IsNotExist(err) {
+		return ``, err
+	}
+
+	source := entry.InitialPath
+
+	if source == `` {
+		if absPath, err := entry.GetAbsolutePath(); err == nil {
+			source = absPath
+		} else {
+			return ``, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0700); err != nil {
+		return ``, err
+	}
+
+	// remove thumbnails generated from previous versions of this file
+	self.removeStaleThumbnails(entry)
+
+	// write to a temporary file first so that partial thumbnails are never served, and so that
+	// concurrent requests for the same thumbnail don't write to the same file
+	tmpFile, err := ioutil.TempFile(filepath.Dir(thumbPath), filepath.Base(thumbPath)+`.*.tmp`)
+
+	if err != nil {
+		return ``, err
+	}
+
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	switch entry.Type {
+	case `video`:
+		err = self.generateVideoThumbnail(source, tmpPath, size)
+	default:
+		err = self.generateImageThumbnail(entry, source, tmpPath, size)
+	}
+
+	if err != nil {
+		return ``, err
+	}
+
+	if err := os.Rename(tmpPath, thumbPath); err != nil {
+		return ``, err
+	}
+
+	return thumbPath, nil
+}
+
+// Return the JPEG-encoded thumbnail data for the given entry.
+func (self *DB) GetThumbnailBytes(entry *Entry, size int) ([]byte, error) {
+	if thumbPath, err := self.GetThumbnail(entry, size); err == nil {
+		return ioutil.ReadFile(thumbPath)
+	} else {
+		return nil, err
+	}
+}
+
+func (self *DB) thumbnailDirectory() string {
+	if self.ThumbnailDirectory != `` {
+		return self.ThumbnailDirectory
+	}
+
+	return filepath.Join(self.BaseDirectory, `thumbnails`)
+}
+
+// Thumbnails are keyed on the entry ID and checksum so that they're invalidated when the file changes.
+func (self *DB) thumbnailPath(entry *Entry, size int) string {
+	return filepath.Join(
+		self.thumbnailDirectory(),
+		thumbnailShard(entry.ID),
+		fmt.Sprintf("%s-%s-%d.jpg", entry.ID, thumbnailVersion(entry), size),
+	)
+}
+
+func thumbnailVersion(entry *Entry) string {
+	if entry.Checksum != `` {
+		return entry.Checksum
+	}
+
+	return fmt.Sprintf("%x", entry.LastModifiedAt)
+}
+
+func thumbnailShard(id string) string {
+	if len(id) >= 2 {
+		return id[0:2]
+	}
+
+	return `_`
+}
+
+func (self *DB) generateImageThumbnail(entry *Entry, source string, dest string, size int) error {
+	if file, err := os.Open(source); err == nil {
+		defer file.Close()
+
+		if img, _, err := image.Decode(file); err == nil {
+			thumb := makeThumbnail(img, int(typeutil.V(entry.Get(`image.orientation`)).Int()), size)
+
+			if out, err := os.Create(dest); err == nil {
+				defer out.Close()
+
+				return jpeg.Encode(out, thumb, &jpeg.Options{
+					Quality: ThumbnailQuality,
+				})
+			} else {
+				return err
+			}
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+func (self *DB) generateVideoThumbnail(source string, dest string, size int) error {
+	offset := self.ThumbnailVideoOffset
+
+	if offset == `` {
+		offset = `00:00:05`
+	}
+
+	var lastErr error
+
+	// try the configured offset first, then the very first frame (for videos shorter than the offset)
+	for _, ss := range []string{offset, `0`} {
+		ctx, cancel := context.WithTimeout(context.Background(), ThumbnailTimeout)
+
+		cmd := exec.CommandContext(ctx, FFMpegCommandName,
+			`-v`, `quiet`,
+			`-ss`, ss,
+			`-i`, source,
+			`-frames:v`, `1`,
+			`-vf`, fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", size, size),
+			`-f`, `image2`,
+			`-c:v`, `mjpeg`,
+			`-y`, dest,
+		)
+
+		cmd.Env = append(os.Environ(), `AV_LOG_FORCE_NOCOLOR=1`)
+
+		lastErr = cmd.Run()
+		cancel()
+
+		if lastErr == nil {
+			if stat, err := os.Stat(dest); err == nil && stat.Size() > 0 {
+				return nil
+			}
+		}
+	}
+
+	if lastErr != nil {
+		return fmt.Errorf("Failed to extract a frame from %s: %v", source, lastErr)
+	}
+
+	return fmt.Errorf("Failed to extract a frame from %s", source)
+}
+
+// Remove all cached thumbnails for the given entry IDs.
+func (self *DB) removeThumbnails(ids ...interface{}) {
+	for _, id := range ids {
+		idS := fmt.Sprintf("%v", id)
+
+		if matches, err := filepath.Glob(filepath.Join(
+			self.thumbnailDirectory(),
+			thumbnailShard(idS),
+			idS+`-*`,
+		)); err == nil {
+			for _, match := range matches {
+				os.Remove(match)
+			}
+		}
+	}
+}
+
+// Remove cached thumbnails of the given entry that were generated from a previous version of its
+// file, leaving thumbnails of other sizes in place.
+func (self *DB) removeStaleThumbnails(entry *Entry) {
+	current := entry.ID + `-` + thumbnailVersion(entry) + `-`
+
+	if matches, err := filepath.Glob(filepath.Join(
+		self.thumbnailDirectory(),
+		thumbnailShard(entry.ID),
+		entry.ID+`-*`,
+	)); err == nil {
+		for _, match := range matches {
+			if name := filepath.Base(match); !strings.HasPrefix(name, current) && !strings.HasSuffix(name, `.tmp`) {
+				os.Remove(match)
+			}
+		}
+	}
+}
+
+// Remove cached thumbnails belonging to entries that no longer exist.
+func (self *DB) pruneThumbnails() error {
+	dir := self.thumbnailDirectory()
+	var removed int
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	existing := make(map[string]bool)
+
+	if err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		if parts := strings.SplitN(info.Name(), `-`, 2); len(parts) == 2 {
+			id := parts[0]
+
+			if _, ok := existing[id]; !ok {
+				existing[id] = Metadata.Exists(id)
+			}
+
+			if !existing[id] {
+				if err := os.Remove(name); err == nil {
+					removed += 1
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if removed > 0 {
+		log.Debugf("Cleanup: removed %d orphaned thumbnails", removed)
+	}
+
+	return nil
+}
+
+// Scale the image to fit within size x size pixels, then rotate and/or flip it according to its EXIF
+// orientation.  Orienting the small thumbnail rather than the decoded image is far cheaper.
+func makeThumbnail(img image.Image, orientation int, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// orientations 5-8 swap the width and height
+	if orientation >= 5 && orientation <= 8 {
+		width, height = height, width
+	}
+
+	if width > size || height > size {
+		if width >= height {
+			height = (height * size) / width
+			width = size
+		} else {
+			width = (width * size) / height
+			height = size
+		}
+	}
+
+	if width < 1 {
+		width = 1
+	}
+
+	if height < 1 {
+		height = 1
+	}
+
+	if orientation >= 5 && orientation <= 8 {
+		width, height = height, width
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// JPEG has no transparency, so composite onto an opaque background
+	draw.Draw(thumb, thumb.Bounds(), image.NewUniform(ThumbnailBackground), image.ZP, draw.Src)
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Over, nil)
+
+	return applyOrientation(thumb, orientation)
+}
+
+// Rotate and/or flip the image according to its EXIF orientation.
+func applyOrientation(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	var out *image.RGBA
+
+	// orientations 5-8 swap the width and height
+	if orientation >= 5 {
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+	} else {
+		out = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+
+			switch orientation {
+			case 2: // mirror horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirror vertical
+				dx, dy = x, h-1-y
+			case 5: // mirror horizontal and rotate 270 CW
+				dx, dy = y, x
+			case 6: // rotate 90 CW
+				dx, dy = h-1-y, x
+			case 7: // mirror horizontal and rotate 90 CW
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 270 CW
+				dx, dy = y, w-1-x
+			}
+
+			out.SetRGBA(dx, dy, img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return out
+}
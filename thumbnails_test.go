@@ -0,0 +1,96 @@
+package metabase
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testThumbnailPixels(img *image.RGBA) [][]uint8 {
+	bounds := img.Bounds()
+	rows := make([][]uint8, bounds.Dy())
+
+	for y := range rows {
+		rows[y] = make([]uint8, bounds.Dx())
+
+		for x := range rows[y] {
+			rows[y][x] = img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y).R
+		}
+	}
+
+	return rows
+}
+
+func TestApplyOrientation(t *testing.T) {
+	assert := require.New(t)
+
+	// 1 2 3
+	// 4 5 6
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+
+	for i := 0; i < 6; i++ {
+		img.SetRGBA(i%3, i/3, color.RGBA{uint8(i + 1), 0, 0, 255})
+	}
+
+	for orientation, expected := range map[int][][]uint8{
+		0: {{1, 2, 3}, {4, 5, 6}},
+		1: {{1, 2, 3}, {4, 5, 6}},
+		2: {{3, 2, 1}, {6, 5, 4}},
+		3: {{6, 5, 4}, {3, 2, 1}},
+		4: {{4, 5, 6}, {1, 2, 3}},
+		5: {{1, 4}, {2, 5}, {3, 6}},
+		6: {{4, 1}, {5, 2}, {6, 3}},
+		7: {{6, 3}, {5, 2}, {4, 1}},
+		8: {{3, 6}, {2, 5}, {1, 4}},
+		9: {{1, 2, 3}, {4, 5, 6}},
+	} {
+		assert.Equal(expected, testThumbnailPixels(applyOrientation(img, orientation)), "orientation %d", orientation)
+	}
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	assert := require.New(t)
+
+	// left half red, right half blue
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			if x < 200 {
+				img.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.SetRGBA(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	for _, tc := range []struct {
+		orientation int
+		size        int
+		width       int
+		height      int
+	}{
+		{1, 100, 100, 50},
+		{3, 100, 100, 50},
+		{6, 100, 50, 100},
+		{8, 100, 50, 100},
+		{1, 1000, 400, 200},
+		{5, 1000, 200, 400},
+		{1, 1, 1, 1},
+	} {
+		thumb := makeThumbnail(img, tc.orientation, tc.size)
+		assert.Equal(tc.width, thumb.Bounds().Dx(), "%+v", tc)
+		assert.Equal(tc.height, thumb.Bounds().Dy(), "%+v", tc)
+	}
+
+	// rotating 90 degrees clockwise puts the left half of the image at the top
+	thumb := makeThumbnail(img, 6, 100)
+	assert.Equal(color.RGBA{255, 0, 0, 255}, thumb.RGBAAt(25, 10))
+	assert.Equal(color.RGBA{0, 0, 255, 255}, thumb.RGBAAt(25, 90))
+
+	// transparent areas are filled with the background color
+	thumb = makeThumbnail(image.NewRGBA(image.Rect(0, 0, 10, 10)), 1, 100)
+	assert.Equal(color.RGBA{255, 255, 255, 255}, thumb.RGBAAt(5, 5))
+}